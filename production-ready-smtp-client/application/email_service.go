@@ -9,30 +9,64 @@ import (
 )
 
 type EmailService struct {
-	sender      domain.EmailSender
-	retryConfig retry.Config
+	sender          domain.EmailSender
+	retryConfig     retry.Config
+	messageIDDomain string
+	newMessageID    func(domainName string) string
+	templates       domain.TemplateRenderer
+	unsubscriber    *Unsubscriber
 }
 
-func NewEmailService(sender domain.EmailSender) *EmailService {
+// NewEmailService creates an EmailService that sends through sender wrapped
+// in middlewares, the first middleware being the outermost
+func NewEmailService(sender domain.EmailSender, middlewares ...domain.Middleware) *EmailService {
 	return &EmailService{
-		sender:      sender,
-		retryConfig: retry.DefaultConfig(),
+		sender:       domain.Chain(sender, middlewares...),
+		retryConfig:  retry.DefaultConfig(),
+		newMessageID: domain.NewMessageID,
 	}
 }
 
+// SetMessageIDDomain sets the domain Message-IDs are generated under. By
+// default each email uses its From domain.
+func (s *EmailService) SetMessageIDDomain(domain string) {
+	s.messageIDDomain = domain
+}
+
+// SetMessageIDGenerator replaces domain.NewMessageID for the Message-IDs
+// SendEmail assigns, e.g. with a sequence that makes messages
+// reproducible in tests
+func (s *EmailService) SetMessageIDGenerator(generate func(domainName string) string) {
+	s.newMessageID = generate
+}
+
+// SendEmail validates and sends email, retrying temporary failures. The
+// email is given a Message-ID before the first attempt, unless it already
+// has one, so every attempt sends the same ID; it stays in
+// email.MessageID for threading replies and matching bounces.
 func (s *EmailService) SendEmail(ctx context.Context, email *domain.Email) error {
 	// Validate email
 	if err := email.Validate(); err != nil {
 		return fmt.Errorf("validation failed: %w", err)
 	}
 	
+	email.EnsureMessageIDWith(s.messageIDDomain, s.newMessageID)
+
+	outgoing, err := s.withoutSuppressed(ctx, email)
+	if err != nil {
+		email.Status = domain.StatusFailed
+		email.LastError = err.Error()
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	s.addUnsubscribeHeaders(outgoing)
+	
 	// Update status
 	email.Status = domain.StatusSending
 	
 	// Send with retry logic
-	err := retry.Do(ctx, s.retryConfig, func(ctx context.Context) error {
+	err = retry.Do(ctx, s.retryConfig, func(ctx context.Context) error {
 		email.Attempts++
-		return s.sender.Send(ctx, email)
+		return s.sender.Send(ctx, outgoing)
 	})
 	
 	if err != nil {
@@ -70,9 +104,95 @@ func (s *EmailService) SendBulkEmails(ctx context.Context, emails []*domain.Emai
 	return nil
 }
 
+// SetUnsubscriber makes SendEmail add one-click List-Unsubscribe headers
+// for the recipient, see Unsubscriber, and skip recipients that are in its
+// suppression store. Use a service without one for transactional mail such
+// as password resets.
+func (s *EmailService) SetUnsubscriber(unsubscriber *Unsubscriber) {
+	s.unsubscriber = unsubscriber
+}
+
+// withoutSuppressed returns email without the recipients in the
+// unsubscriber's suppression store, or email itself if none of them is
+// suppressed. The caller's recipient lists are left untouched. If every
+// recipient is suppressed, ErrAllRecipientsFiltered is returned as a
+// permanent error.
+func (s *EmailService) withoutSuppressed(ctx context.Context, email *domain.Email) (*domain.Email, error) {
+	if s.unsubscriber == nil {
+		return email, nil
+	}
+
+	filtered := *email
+	suppressed := 0
+	for _, list := range []*[]domain.Address{&filtered.To, &filtered.Cc, &filtered.Bcc} {
+		var kept []domain.Address
+		for _, addr := range *list {
+			ok, err := s.unsubscriber.config.Store.IsSuppressed(ctx, addr.Address)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check suppression of %s: %w", addr.Address, err)
+			}
+			if ok {
+				suppressed++
+				continue
+			}
+			kept = append(kept, addr)
+		}
+		*list = kept
+	}
+
+	if suppressed == 0 {
+		return email, nil
+	}
+	log.Printf("Skipping %d suppressed recipients of email %q", suppressed, email.Subject)
+	if len(filtered.To)+len(filtered.Cc)+len(filtered.Bcc) == 0 {
+		return nil, retry.Permanent(ErrAllRecipientsFiltered)
+	}
+	return &filtered, nil
+}
+
+// addUnsubscribeHeaders adds the List-Unsubscribe headers unless the email
+// already has them. The token identifies a single recipient, so emails to
+// several recipients get none.
+func (s *EmailService) addUnsubscribeHeaders(email *domain.Email) {
+	if s.unsubscriber == nil {
+		return
+	}
+	recipients := email.Recipients()
+	if len(recipients) != 1 {
+		return
+	}
+	if _, ok := email.Headers["List-Unsubscribe"]; ok {
+		return
+	}
+
+	if email.Headers == nil {
+		email.Headers = make(map[string]string)
+	}
+	for key, value := range s.unsubscriber.Headers(recipients[0]) {
+		email.Headers[key] = value
+	}
+}
+
+// SetTemplateRenderer sets the renderer SendWithTemplate uses
+func (s *EmailService) SetTemplateRenderer(templates domain.TemplateRenderer) {
+	s.templates = templates
+}
+
+// SendWithTemplate renders templateName with data into the HTML body of
+// email and sends it. Unless email already has a text body, the text
+// alternative is generated from the rendered HTML when the message is
+// composed.
 func (s *EmailService) SendWithTemplate(ctx context.Context, templateName string, data interface{}, email *domain.Email) error {
-	// TODO: Implement template rendering
-	// This would use the TemplateEngine to render HTML
+	if s.templates == nil {
+		return fmt.Errorf("no template renderer configured")
+	}
+
+	html, err := s.templates.Render(templateName, data)
+	if err != nil {
+		return fmt.Errorf("failed to render template %s: %w", templateName, err)
+	}
+	email.HTMLBody = html
+
 	return s.SendEmail(ctx, email)
 }
 
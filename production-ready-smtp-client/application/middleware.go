@@ -0,0 +1,218 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/htmlmail"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"log"
+	"net/textproto"
+	"time"
+)
+
+// ErrAllRecipientsFiltered is returned when a recipient filter removes
+// every recipient of an email
+var ErrAllRecipientsFiltered = errors.New("all recipients were filtered out")
+
+// middlewareSender implements domain.EmailSender around a per-email send
+// function. SendBulk runs each email through the same function so that
+// bulk sends get the same treatment as single ones.
+type middlewareSender struct {
+	next domain.EmailSender
+	send func(ctx context.Context, email *domain.Email) error
+}
+
+func (m *middlewareSender) Send(ctx context.Context, email *domain.Email) error {
+	return m.send(ctx, email)
+}
+
+func (m *middlewareSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	var failures []error
+	for _, email := range emails {
+		if err := m.send(ctx, email); err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to send %d emails: %v", len(failures), failures[0])
+	}
+
+	return nil
+}
+
+func (m *middlewareSender) Close() error {
+	return m.next.Close()
+}
+
+// Logging logs every send attempt and its outcome. A nil logger uses the
+// standard logger.
+func Logging(logger *log.Logger) domain.Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next domain.EmailSender) domain.EmailSender {
+		return &middlewareSender{
+			next: next,
+			send: func(ctx context.Context, email *domain.Email) error {
+				logger.Printf("Sending email %q to %v", email.Subject, email.To)
+				err := next.Send(ctx, email)
+				if err != nil {
+					logger.Printf("Send to %v failed: %v", email.To, err)
+				} else {
+					logger.Printf("Sent email to %v", email.To)
+				}
+				return err
+			},
+		}
+	}
+}
+
+// Timing reports how long each send took to observe, e.g. to feed a
+// latency histogram
+func Timing(observe func(email *domain.Email, duration time.Duration, err error)) domain.Middleware {
+	return func(next domain.EmailSender) domain.EmailSender {
+		return &middlewareSender{
+			next: next,
+			send: func(ctx context.Context, email *domain.Email) error {
+				start := time.Now()
+				err := next.Send(ctx, email)
+				observe(email, time.Since(start), err)
+				return err
+			},
+		}
+	}
+}
+
+// InjectHeaders adds headers to every email that does not already set
+// them, e.g. a List-Id or an X-Mailer. The headers are checked with
+// domain.ValidateHeaders up front, so reserved names such as From are
+// rejected here rather than on every send. The caller's email is left
+// untouched.
+func InjectHeaders(headers map[string]string) (domain.Middleware, error) {
+	if err := domain.ValidateHeaders(headers); err != nil {
+		return nil, err
+	}
+	injected := make(map[string]string, len(headers))
+	for key, value := range headers {
+		injected[key] = value
+	}
+
+	return func(next domain.EmailSender) domain.EmailSender {
+		return &middlewareSender{
+			next: next,
+			send: func(ctx context.Context, email *domain.Email) error {
+				prepared := email.Clone()
+				if prepared.Headers == nil {
+					prepared.Headers = make(map[string]string, len(injected))
+				}
+				// Header names are case-insensitive, so an email with a
+				// list-id keeps it rather than getting a second List-Id
+				existing := make(map[string]bool, len(prepared.Headers))
+				for key := range prepared.Headers {
+					existing[textproto.CanonicalMIMEHeaderKey(key)] = true
+				}
+				for key, value := range injected {
+					if !existing[textproto.CanonicalMIMEHeaderKey(key)] {
+						prepared.Headers[key] = value
+					}
+				}
+				return next.Send(ctx, prepared)
+			},
+		}
+	}, nil
+}
+
+// FilterRecipients drops every To, Cc and Bcc recipient for which allow
+// returns false, e.g. addresses on a suppression list. The caller's email
+// is left untouched. If no recipient is left, the email is not sent and
+// ErrAllRecipientsFiltered is returned as a permanent error.
+func FilterRecipients(allow func(addr string) bool) domain.Middleware {
+	return func(next domain.EmailSender) domain.EmailSender {
+		return &middlewareSender{
+			next: next,
+			send: func(ctx context.Context, email *domain.Email) error {
+				filtered := *email
+				filtered.To = filterAddresses(email.To, allow)
+				filtered.Cc = filterAddresses(email.Cc, allow)
+				filtered.Bcc = filterAddresses(email.Bcc, allow)
+
+				if len(filtered.To)+len(filtered.Cc)+len(filtered.Bcc) == 0 {
+					return retry.Permanent(ErrAllRecipientsFiltered)
+				}
+
+				return next.Send(ctx, &filtered)
+			},
+		}
+	}
+}
+
+// EmbedImages converts data: URI and local file images in HTML bodies into
+// inline parts, see htmlmail.EmbedImages. The caller's email is left
+// untouched. Images that can't be embedded fail the send permanently.
+func EmbedImages(opts htmlmail.ImageOptions) domain.Middleware {
+	return func(next domain.EmailSender) domain.EmailSender {
+		return &middlewareSender{
+			next: next,
+			send: func(ctx context.Context, email *domain.Email) error {
+				prepared := email.Clone()
+				if err := htmlmail.EmbedImages(prepared, opts); err != nil {
+					return retry.Permanent(err)
+				}
+				return next.Send(ctx, prepared)
+			},
+		}
+	}
+}
+
+// EnforceAttachmentPolicy rejects emails whose attachments break policy,
+// see domain.AttachmentPolicy, as a permanent error. Streamed attachments
+// are limited while they are sent. The caller's email is left untouched.
+func EnforceAttachmentPolicy(policy domain.AttachmentPolicy) domain.Middleware {
+	return func(next domain.EmailSender) domain.EmailSender {
+		return &middlewareSender{
+			next: next,
+			send: func(ctx context.Context, email *domain.Email) error {
+				if len(email.Attachments)+len(email.Embedded) == 0 {
+					return next.Send(ctx, email)
+				}
+				prepared := email.Clone()
+				if err := policy.Enforce(prepared); err != nil {
+					return retry.Permanent(err)
+				}
+				return next.Send(ctx, prepared)
+			},
+		}
+	}
+}
+
+// InlineCSS moves the <style> rules of HTML bodies into style attributes,
+// see htmlmail.InlineCSS. The caller's email is left untouched.
+func InlineCSS() domain.Middleware {
+	return func(next domain.EmailSender) domain.EmailSender {
+		return &middlewareSender{
+			next: next,
+			send: func(ctx context.Context, email *domain.Email) error {
+				if email.HTMLBody == "" {
+					return next.Send(ctx, email)
+				}
+				prepared := email.Clone()
+				prepared.HTMLBody = htmlmail.InlineCSS(email.HTMLBody)
+				return next.Send(ctx, prepared)
+			},
+		}
+	}
+}
+
+func filterAddresses(addrs []domain.Address, allow func(addr string) bool) []domain.Address {
+	var kept []domain.Address
+	for _, addr := range addrs {
+		if allow(addr.Address) {
+			kept = append(kept, addr)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,155 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/emailtest"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+func TestInjectHeaders(t *testing.T) {
+	inject, err := InjectHeaders(map[string]string{"X-Mailer": "go-smtp", "List-Id": "news.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := emailtest.NewRecorder()
+	sender := domain.Chain(next, inject)
+
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("News").
+		TextBody("body").
+		Header("List-Id", "own.example.com").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := next.Messages()[0].Email
+	if sent.Headers["X-Mailer"] != "go-smtp" || sent.Headers["List-Id"] != "own.example.com" {
+		t.Errorf("sent headers = %v", sent.Headers)
+	}
+	if _, ok := email.Headers["X-Mailer"]; ok || len(email.Headers) != 1 {
+		t.Errorf("caller's headers changed: %v", email.Headers)
+	}
+}
+
+func TestInjectHeadersKeepsExistingHeadersInAnyCase(t *testing.T) {
+	inject, err := InjectHeaders(map[string]string{"List-Id": "news.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := emailtest.NewRecorder()
+	sender := domain.Chain(next, inject)
+
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("News").
+		TextBody("body").
+		Header("list-id", "own.example.com").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+
+	if headers := next.Messages()[0].Email.Headers; len(headers) != 1 {
+		t.Errorf("sent headers = %v, want only the email's own list-id", headers)
+	}
+}
+
+func TestInjectHeadersRejectsInvalidHeaders(t *testing.T) {
+	for _, headers := range []map[string]string{
+		{"From": "spoof@example.com"},
+		{"content-type": "text/html"},
+		{"X-Bad Name": "value"},
+		{"X-Injected": "value\r\nBcc: evil@example.com"},
+	} {
+		if _, err := InjectHeaders(headers); err == nil {
+			t.Errorf("InjectHeaders(%v) succeeded", headers)
+		}
+	}
+}
+
+func TestEnforceAttachmentPolicy(t *testing.T) {
+	policy := domain.AttachmentPolicy{MaxSize: 10, BlockedExtensions: []string{".exe"}}
+	tests := []struct {
+		name     string
+		filename string
+		size     int
+		want     error
+	}{
+		{"allowed", "notes.txt", 10, nil},
+		{"too large", "notes.txt", 11, domain.ErrAttachmentTooLarge},
+		{"blocked", "setup.EXE", 1, domain.ErrAttachmentTypeBlocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := emailtest.NewRecorder()
+			sender := domain.Chain(next, EnforceAttachmentPolicy(policy))
+
+			email, err := domain.NewEmailBuilder().
+				From("from@example.com").
+				To("to@example.org").
+				Subject("Files").
+				TextBody("body").
+				Attach(tt.filename, "application/octet-stream", make([]byte, tt.size)).
+				Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sender.Send(context.Background(), email)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Send = %v", err)
+				}
+				next.AssertCount(t, 1)
+				return
+			}
+			if !errors.Is(err, tt.want) || retry.IsRetryable(err) {
+				t.Errorf("Send = %v, want a permanent %v", err, tt.want)
+			}
+			next.AssertCount(t, 0)
+		})
+	}
+}
+
+func TestEnforceAttachmentPolicyLimitsStreams(t *testing.T) {
+	next := emailtest.NewRecorder()
+	sender := domain.Chain(next, EnforceAttachmentPolicy(domain.AttachmentPolicy{MaxSize: 10}))
+
+	stream := strings.NewReader(strings.Repeat("x", 11))
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Files").
+		TextBody("body").
+		AttachStream("export.csv", "text/csv", stream).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := email.Attachments[0].Reader
+
+	// The size of a stream is only known once the transport reads it
+	err = sender.Send(context.Background(), email)
+	if !errors.Is(err, domain.ErrAttachmentTooLarge) || retry.IsRetryable(err) {
+		t.Errorf("Send = %v, want a permanent ErrAttachmentTooLarge", err)
+	}
+	next.AssertCount(t, 0)
+	if email.Attachments[0].Reader != original {
+		t.Error("caller's email was modified")
+	}
+}
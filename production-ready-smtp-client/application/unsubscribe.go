@@ -0,0 +1,155 @@
+package application
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidUnsubscribeToken is returned for tokens that are malformed or
+// were not signed with the configured secret
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// maxUnsubscribeBody bounds the body of an unsubscribe POST, which is
+// normally just "List-Unsubscribe=One-Click"
+const maxUnsubscribeBody = 4 << 10
+
+type UnsubscribeConfig struct {
+	// URL is the https endpoint the Unsubscriber is served at. The token
+	// is added as the "token" query parameter.
+	URL string
+	// Secret signs the tokens. Changing it invalidates the links in mail
+	// already sent.
+	Secret []byte
+	// Mailto optionally adds a mailto: unsubscribe address, for clients
+	// that don't support one-click unsubscribe
+	Mailto string
+	Store  domain.SuppressionStore
+}
+
+// Unsubscriber implements one-click unsubscribe (RFC 8058). It generates
+// the List-Unsubscribe headers for each recipient and, as an http.Handler,
+// records the unsubscribe POSTs mailbox providers send to its URL.
+type Unsubscriber struct {
+	config *UnsubscribeConfig
+	url    *url.URL
+}
+
+func NewUnsubscriber(config *UnsubscribeConfig) (*Unsubscriber, error) {
+	endpoint, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unsubscribe URL: %w", err)
+	}
+	// RFC 8058 requires one-click unsubscribe URLs to be https
+	if endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("unsubscribe URL must be an absolute https URL")
+	}
+	if len(config.Secret) < 16 {
+		return nil, fmt.Errorf("unsubscribe secret must be at least 16 bytes")
+	}
+	if config.Store == nil {
+		return nil, fmt.Errorf("suppression store is required")
+	}
+	if config.Mailto != "" && strings.ContainsAny(config.Mailto, "<>\r\n") {
+		return nil, fmt.Errorf("invalid unsubscribe mailto address: %s", config.Mailto)
+	}
+
+	return &Unsubscriber{config: config, url: endpoint}, nil
+}
+
+// Token returns the signed token that unsubscribes address
+func (u *Unsubscriber) Token(address string) string {
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString([]byte(address)) + "." + encoding.EncodeToString(u.sign(address))
+}
+
+// Verify returns the address token was issued for
+func (u *Unsubscriber) Verify(token string) (string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	address, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, u.sign(string(address))) {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	return string(address), nil
+}
+
+func (u *Unsubscriber) sign(address string) []byte {
+	mac := hmac.New(sha256.New, u.config.Secret)
+	mac.Write([]byte("unsubscribe\x00" + address))
+	return mac.Sum(nil)
+}
+
+// URL returns the one-click unsubscribe URL for address
+func (u *Unsubscriber) URL(address string) string {
+	endpoint := *u.url
+	query := endpoint.Query()
+	query.Set("token", u.Token(address))
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String()
+}
+
+// Headers returns the List-Unsubscribe and List-Unsubscribe-Post headers
+// for a message to address
+func (u *Unsubscriber) Headers(address string) map[string]string {
+	list := "<" + u.URL(address) + ">"
+	if u.config.Mailto != "" {
+		list += ", <mailto:" + u.config.Mailto + "?subject=unsubscribe>"
+	}
+	return map[string]string{
+		"List-Unsubscribe":      list,
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// ServeHTTP handles one-click unsubscribe POSTs. Only POST unsubscribes:
+// link scanners and prefetchers follow GET links, so RFC 8058 forbids
+// acting on them.
+func (u *Unsubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUnsubscribeBody)
+	if r.PostFormValue("List-Unsubscribe") != "One-Click" {
+		http.Error(w, "missing List-Unsubscribe=One-Click", http.StatusBadRequest)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+	address, err := u.Verify(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := u.config.Store.Suppress(r.Context(), address); err != nil {
+		// A server error makes the mailbox provider try again later
+		log.Printf("Failed to record unsubscribe of %s: %v", address, err)
+		http.Error(w, "failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "unsubscribed")
+}
@@ -0,0 +1,172 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/infrastructure"
+	"go-smtp/production-ready-smtp-client/pkg/emailtest"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+func newTestUnsubscriber(t *testing.T, secret string) (*Unsubscriber, *infrastructure.MemorySuppressionStore) {
+	t.Helper()
+	store := infrastructure.NewMemorySuppressionStore()
+	unsubscriber, err := NewUnsubscriber(&UnsubscribeConfig{
+		URL:    "https://example.com/unsubscribe",
+		Secret: []byte(secret),
+		Store:  store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsubscriber, store
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+	const oneClick = "List-Unsubscribe=One-Click"
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		body       string
+		status     int
+		suppressed bool
+	}{
+		{"one-click", http.MethodPost, "valid", oneClick, http.StatusOK, true},
+		{"GET", http.MethodGet, "valid", "", http.StatusMethodNotAllowed, false},
+		{"GET with one-click", http.MethodGet, "valid", oneClick, http.StatusMethodNotAllowed, false},
+		{"no one-click body", http.MethodPost, "valid", "", http.StatusBadRequest, false},
+		{"wrong one-click value", http.MethodPost, "valid", "List-Unsubscribe=Yes", http.StatusBadRequest, false},
+		{"no token", http.MethodPost, "", oneClick, http.StatusBadRequest, false},
+		{"bad token", http.MethodPost, "bad", oneClick, http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsubscriber, store := newTestUnsubscriber(t, "0123456789abcdef")
+
+			target := unsubscriber.URL("alice@example.org")
+			switch tt.token {
+			case "":
+				target = "https://example.com/unsubscribe"
+			case "bad":
+				target = "https://example.com/unsubscribe?token=" + url.QueryEscape(unsubscriber.Token("bob@example.org")+"x")
+			}
+			req := httptest.NewRequest(tt.method, target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			unsubscriber.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != http.MethodPost {
+				t.Errorf("Allow = %q", rec.Header().Get("Allow"))
+			}
+			suppressed, _ := store.IsSuppressed(context.Background(), "alice@example.org")
+			if suppressed != tt.suppressed {
+				t.Errorf("suppressed = %v, want %v", suppressed, tt.suppressed)
+			}
+		})
+	}
+}
+
+func TestUnsubscribeTokenTampering(t *testing.T) {
+	unsubscriber, _ := newTestUnsubscriber(t, "0123456789abcdef")
+	other, _ := newTestUnsubscriber(t, "fedcba9876543210")
+
+	token := unsubscriber.Token("alice@example.org")
+	address, err := unsubscriber.Verify(token)
+	if err != nil || address != "alice@example.org" {
+		t.Fatalf("Verify = %q, %v", address, err)
+	}
+
+	encoded, signature, _ := strings.Cut(token, ".")
+	bobEncoded, _, _ := strings.Cut(unsubscriber.Token("bob@example.org"), ".")
+	tests := map[string]string{
+		"empty":             "",
+		"no signature":      encoded,
+		"empty signature":   encoded + ".",
+		"swapped address":   bobEncoded + "." + signature,
+		"changed signature": encoded + "." + strings.Repeat("A", len(signature)),
+		"truncated":         token[:len(token)-2],
+		"not base64":        "!!!." + signature,
+		"other secret":      other.Token("alice@example.org"),
+	}
+	for name, tampered := range tests {
+		if address, err := unsubscriber.Verify(tampered); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+			t.Errorf("%s: Verify = %q, %v, want ErrInvalidUnsubscribeToken", name, address, err)
+		}
+	}
+}
+
+func TestSendEmailSkipsSuppressedRecipients(t *testing.T) {
+	unsubscriber, store := newTestUnsubscriber(t, "0123456789abcdef")
+	if err := store.Suppress(context.Background(), "Bob@Example.org"); err != nil {
+		t.Fatal(err)
+	}
+	next := emailtest.NewRecorder()
+	service := NewEmailService(next)
+	service.SetUnsubscriber(unsubscriber)
+
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("alice@example.org").
+		Cc("bob@example.org").
+		Subject("News").
+		TextBody("body").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SendEmail(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := next.Messages()[0].Email
+	if got := sent.Recipients(); len(got) != 1 || got[0] != "alice@example.org" {
+		t.Errorf("sent to %v, want alice@example.org only", got)
+	}
+	// With bob skipped the email has a single recipient, so it gets the link for alice
+	if !strings.Contains(sent.Headers["List-Unsubscribe"], url.QueryEscape(unsubscriber.Token("alice@example.org"))) {
+		t.Errorf("List-Unsubscribe = %q", sent.Headers["List-Unsubscribe"])
+	}
+	if len(email.Cc) != 1 {
+		t.Errorf("caller's Cc changed: %v", email.Cc)
+	}
+}
+
+func TestSendEmailAllRecipientsSuppressed(t *testing.T) {
+	unsubscriber, store := newTestUnsubscriber(t, "0123456789abcdef")
+	if err := store.Suppress(context.Background(), "alice@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	next := emailtest.NewRecorder()
+	service := NewEmailService(next)
+	service.SetUnsubscriber(unsubscriber)
+
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("alice@example.org").
+		Subject("News").
+		TextBody("body").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = service.SendEmail(context.Background(), email)
+	if !errors.Is(err, ErrAllRecipientsFiltered) || retry.IsRetryable(err) {
+		t.Errorf("SendEmail = %v, want a permanent ErrAllRecipientsFiltered", err)
+	}
+	next.AssertCount(t, 0)
+	if email.Status != domain.StatusFailed {
+		t.Errorf("status = %v, want failed", email.Status)
+	}
+}
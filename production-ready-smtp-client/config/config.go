@@ -6,8 +6,23 @@ import (
 	"strconv"
 )
 
+// Transport names accepted in MAIL_TRANSPORT
+const (
+	TransportSMTP     = "smtp"
+	TransportFile     = "file"
+	TransportMaildir  = "maildir"
+	TransportSendmail = "sendmail"
+)
+
 type Config struct {
-	SMTP SMTPConfig
+	Transport string
+	SMTP      SMTPConfig
+	File      FileConfig
+	Sendmail  SendmailConfig
+	DKIM      DKIMConfig
+	// MessageIDDomain is the domain Message-IDs are generated under,
+	// the From domain of each email if empty
+	MessageIDDomain string
 }
 
 type SMTPConfig struct {
@@ -16,19 +31,55 @@ type SMTPConfig struct {
 	Username string
 	Password string
 	PoolSize int
+	// FormatFlowed sends plain text bodies as format=flowed
+	FormatFlowed bool
+}
+
+// FileConfig configures the file and maildir transports, which write
+// messages to disk for local development
+type FileConfig struct {
+	Dir string
+}
+
+type SendmailConfig struct {
+	Path string
+}
+
+// DKIMConfig configures signing of the smtp and sendmail transports.
+// Signing is off unless a key file is given.
+type DKIMConfig struct {
+	Domain   string
+	Selector string
+	// KeyFile is a PEM encoded RSA or Ed25519 private key
+	KeyFile string
 }
 
 func Load() (*Config, error) {
 	poolSize, _ := strconv.Atoi(getEnv("SMTP_POOL_SIZE", "5"))
+	formatFlowed, _ := strconv.ParseBool(getEnv("SMTP_FORMAT_FLOWED", "false"))
 	
 	config := &Config{
+		Transport: getEnv("MAIL_TRANSPORT", TransportSMTP),
 		SMTP: SMTPConfig{
-			Host:     getEnv("SMTP_HOST", ""),
-			Port:     getEnv("SMTP_PORT", "587"),
-			Username: getEnv("SMTP_FROM", ""),
-			Password: getEnv("SMTP_PASSWORD", ""),
-			PoolSize: poolSize,
+			Host:         getEnv("SMTP_HOST", ""),
+			Port:         getEnv("SMTP_PORT", "587"),
+			Username:     getEnv("SMTP_FROM", ""),
+			Password:     getEnv("SMTP_PASSWORD", ""),
+			PoolSize:     poolSize,
+			FormatFlowed: formatFlowed,
+		},
+		File: FileConfig{
+			Dir: getEnv("MAIL_OUTPUT_DIR", "mail-output"),
+		},
+		Sendmail: SendmailConfig{
+			Path: getEnv("SENDMAIL_PATH", "/usr/sbin/sendmail"),
+		},
+		DKIM: DKIMConfig{
+			Domain:   getEnv("DKIM_DOMAIN", ""),
+			Selector: getEnv("DKIM_SELECTOR", ""),
+			KeyFile:  getEnv("DKIM_PRIVATE_KEY_FILE", ""),
 		},
+		MessageIDDomain: getEnv("MAIL_MESSAGE_ID_DOMAIN", ""),
 	}
 	
 	if err := config.Validate(); err != nil {
@@ -39,18 +90,51 @@ func Load() (*Config, error) {
 }
 
 func (c *Config) Validate() error {
-	if c.SMTP.Host == "" {
+	if err := c.DKIM.Validate(); err != nil {
+		return err
+	}
+
+	switch c.Transport {
+	case TransportSMTP:
+		return c.SMTP.Validate()
+	case TransportFile, TransportMaildir:
+		if c.File.Dir == "" {
+			return fmt.Errorf("MAIL_OUTPUT_DIR is required")
+		}
+		return nil
+	case TransportSendmail:
+		return nil
+	default:
+		return fmt.Errorf("unknown MAIL_TRANSPORT: %s", c.Transport)
+	}
+}
+
+func (c *SMTPConfig) Validate() error {
+	if c.Host == "" {
 		return fmt.Errorf("SMTP_HOST is required")
 	}
-	if c.SMTP.Username == "" {
+	if c.Username == "" {
 		return fmt.Errorf("SMTP_FROM is required")
 	}
-	if c.SMTP.Password == "" {
+	if c.Password == "" {
 		return fmt.Errorf("SMTP_PASSWORD is required")
 	}
 	return nil
 }
 
+func (c *DKIMConfig) Validate() error {
+	if c.KeyFile == "" {
+		return nil
+	}
+	if c.Domain == "" {
+		return fmt.Errorf("DKIM_DOMAIN is required with DKIM_PRIVATE_KEY_FILE")
+	}
+	if c.Selector == "" {
+		return fmt.Errorf("DKIM_SELECTOR is required with DKIM_PRIVATE_KEY_FILE")
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
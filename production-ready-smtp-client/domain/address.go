@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// Address is a mailbox with an optional display name, as in
+// "Acme Billing <billing@acme.com>"
+type Address struct {
+	Name    string
+	Address string
+}
+
+// ParseAddress parses a single RFC 5322 address, with or without a
+// display name. Encoded display names are decoded.
+func ParseAddress(s string) (Address, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return Address{}, err
+	}
+	return Address{Name: addr.Name, Address: addr.Address}, nil
+}
+
+// ParseAddressList parses a comma-separated list of addresses
+func ParseAddressList(s string) ([]Address, error) {
+	list, err := mail.ParseAddressList(s)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]Address, len(list))
+	for i, addr := range list {
+		addrs[i] = Address{Name: addr.Name, Address: addr.Address}
+	}
+	return addrs, nil
+}
+
+// String formats the address for use in a header field. The display name
+// is quoted or RFC 2047 encoded as needed; an address without one is
+// written bare.
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Address
+	}
+	return (&mail.Address{Name: a.Name, Address: a.Address}).String()
+}
+
+// Domain returns the lower-cased part of the address after the @
+func (a Address) Domain() string {
+	at := strings.LastIndexByte(a.Address, '@')
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(a.Address[at+1:])
+}
+
+// FormatAddressList formats addrs for use in a header field
+func FormatAddressList(addrs []Address) string {
+	formatted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		formatted[i] = addr.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// Mailboxes returns the plain addresses of addrs, without display names,
+// as used in the SMTP envelope
+func Mailboxes(addrs []Address) []string {
+	mailboxes := make([]string, len(addrs))
+	for i, addr := range addrs {
+		mailboxes[i] = addr.Address
+	}
+	return mailboxes
+}
+
+// parseOrRaw parses s as an address. If it can't be parsed, s is kept as
+// the bare address so that Validate reports it.
+func parseOrRaw(s string) Address {
+	addr, err := ParseAddress(s)
+	if err != nil {
+		return Address{Address: strings.TrimSpace(s)}
+	}
+	return addr
+}
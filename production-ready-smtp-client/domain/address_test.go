@@ -0,0 +1,225 @@
+package domain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Address
+	}{
+		{"billing@acme.com", Address{Address: "billing@acme.com"}},
+		{"<billing@acme.com>", Address{Address: "billing@acme.com"}},
+		{"Acme Billing <billing@acme.com>", Address{Name: "Acme Billing", Address: "billing@acme.com"}},
+		{`"Doe, Jane" <jane@example.com>`, Address{Name: "Doe, Jane", Address: "jane@example.com"}},
+		{`"Jane \"JD\" Doe" <jane@example.com>`, Address{Name: `Jane "JD" Doe`, Address: "jane@example.com"}},
+		{"=?UTF-8?q?J=C3=BCrgen_Gro=C3=9F?= <j@example.de>", Address{Name: "Jürgen Groß", Address: "j@example.de"}},
+		{"=?ISO-8859-1?Q?J=FCrgen?= <j@example.de>", Address{Name: "Jürgen", Address: "j@example.de"}},
+		{"=?UTF-8?B?5bGx55Sw?= <yamada@example.jp>", Address{Name: "山田", Address: "yamada@example.jp"}},
+		{"Jürgen <j@example.de>", Address{Name: "Jürgen", Address: "j@example.de"}},
+		{"info@bücher.de", Address{Address: "info@bücher.de"}},
+		{"info@xn--bcher-kva.de", Address{Address: "info@xn--bcher-kva.de"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseAddress(tt.in)
+		if err != nil {
+			t.Errorf("ParseAddress(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAddress(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAddressInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"not an address",
+		"jane@",
+		"@example.com",
+		"<jane@example.com",
+		"Doe, Jane <jane@example.com>",
+		`"Jane <jane@example.com>`,
+		"jane@example.com, bob@example.org",
+	} {
+		if got, err := ParseAddress(in); err == nil {
+			t.Errorf("ParseAddress(%q) = %+v, want error", in, got)
+		}
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	got, err := ParseAddressList(`"Doe, Jane" <jane@example.com>, bob@example.org, =?UTF-8?q?J=C3=BCrgen?= <j@example.de>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Address{
+		{Name: "Doe, Jane", Address: "jane@example.com"},
+		{Address: "bob@example.org"},
+		{Name: "Jürgen", Address: "j@example.de"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAddressList() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseAddressList("jane@example.com, not an address"); err == nil {
+		t.Error("ParseAddressList() with an invalid entry succeeded")
+	}
+}
+
+func TestAddressString(t *testing.T) {
+	tests := []struct {
+		addr Address
+		want string
+	}{
+		{Address{Address: "billing@acme.com"}, "billing@acme.com"},
+		{Address{Name: "Acme Billing", Address: "billing@acme.com"}, `"Acme Billing" <billing@acme.com>`},
+		{Address{Name: "Doe, Jane", Address: "jane@example.com"}, `"Doe, Jane" <jane@example.com>`},
+		{Address{Name: `Jane "JD" Doe`, Address: "jane@example.com"}, `"Jane \"JD\" Doe" <jane@example.com>`},
+		{Address{Name: `back\slash`, Address: "jane@example.com"}, `"back\\slash" <jane@example.com>`},
+		{Address{Name: "Jürgen Groß", Address: "j@example.de"}, "=?utf-8?q?J=C3=BCrgen_Gro=C3=9F?= <j@example.de>"},
+	}
+	for _, tt := range tests {
+		got := tt.addr.String()
+		if got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.addr, got, tt.want)
+		}
+		// Whatever the name, the header value must be ASCII and parse back
+		// to the same address
+		for _, r := range got {
+			if r > 0x7e {
+				t.Errorf("%+v.String() = %q is not ASCII", tt.addr, got)
+				break
+			}
+		}
+		if parsed, err := ParseAddress(got); err != nil || parsed != tt.addr {
+			t.Errorf("ParseAddress(%q) = %+v, %v, want %+v", got, parsed, err, tt.addr)
+		}
+	}
+}
+
+func TestFormatAddressList(t *testing.T) {
+	addrs := []Address{
+		{Name: "Doe, Jane", Address: "jane@example.com"},
+		{Address: "bob@example.org"},
+	}
+	want := `"Doe, Jane" <jane@example.com>, bob@example.org`
+	if got := FormatAddressList(addrs); got != want {
+		t.Errorf("FormatAddressList() = %q, want %q", got, want)
+	}
+	parsed, err := ParseAddressList(want)
+	if err != nil || !reflect.DeepEqual(parsed, addrs) {
+		t.Errorf("ParseAddressList(%q) = %+v, %v, want %+v", want, parsed, err, addrs)
+	}
+	if got := FormatAddressList(nil); got != "" {
+		t.Errorf("FormatAddressList(nil) = %q, want empty", got)
+	}
+}
+
+func TestMailboxes(t *testing.T) {
+	addrs := []Address{
+		{Name: "Doe, Jane", Address: "jane@example.com"},
+		{Name: "Jürgen", Address: "j@example.de"},
+		{Address: "bob@example.org"},
+	}
+	want := []string{"jane@example.com", "j@example.de", "bob@example.org"}
+	if got := Mailboxes(addrs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Mailboxes() = %q, want %q", got, want)
+	}
+}
+
+func TestAddressDomain(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"jane@example.com", "example.com"},
+		{"Jane@Example.COM", "example.com"},
+		{`"a@b"@example.org`, "example.org"},
+		{"info@BÜCHER.de", "bücher.de"},
+		{"info@xn--bcher-kva.de", "xn--bcher-kva.de"},
+		{"jane", ""},
+	}
+	for _, tt := range tests {
+		if got := (Address{Address: tt.address}).Domain(); got != tt.want {
+			t.Errorf("Domain(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestBuilderAddresses(t *testing.T) {
+	email, err := NewEmailBuilder().
+		From(`"Acme, Billing" <billing@acme.com>`).
+		To("=?UTF-8?q?J=C3=BCrgen?= <j@example.de>", "bob@example.org").
+		Cc("Jane Doe <jane@example.com>").
+		Subject("Invoice").
+		TextBody("body").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Address{Name: "Acme, Billing", Address: "billing@acme.com"}); email.From != want {
+		t.Errorf("From = %+v, want %+v", email.From, want)
+	}
+	if want := (Address{Name: "Jürgen", Address: "j@example.de"}); email.To[0] != want {
+		t.Errorf("To[0] = %+v, want %+v", email.To[0], want)
+	}
+	// Only the plain addresses go into the envelope
+	want := []string{"j@example.de", "bob@example.org", "jane@example.com"}
+	if got := email.Recipients(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Recipients() = %q, want %q", got, want)
+	}
+}
+
+func TestBuilderInvalidAddresses(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *EmailBuilder) *EmailBuilder
+		want  string
+	}{
+		{
+			name: "unquoted comma in from",
+			build: func(b *EmailBuilder) *EmailBuilder {
+				return b.From("Doe, Jane <jane@example.com>").To("bob@example.org")
+			},
+			want: "invalid from address",
+		},
+		{
+			name:  "missing domain",
+			build: func(b *EmailBuilder) *EmailBuilder { return b.From("jane@example.com").To("bob@") },
+			want:  "invalid to address",
+		},
+		{
+			name: "garbage cc",
+			build: func(b *EmailBuilder) *EmailBuilder {
+				return b.From("jane@example.com").To("bob@example.org").Cc("not an address")
+			},
+			want: "invalid recipient address: not an address",
+		},
+		{
+			// Sending to an internationalized domain needs SMTPUTF8 or
+			// the A-label, which the caller has to supply
+			name:  "IDN domain",
+			build: func(b *EmailBuilder) *EmailBuilder { return b.From("jane@example.com").To("info@bücher.de") },
+			want:  "invalid to address: info@bücher.de",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.build(NewEmailBuilder()).Subject("Invoice").TextBody("body").Build()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Build() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// The A-label of the same domain is accepted
+	if _, err := NewEmailBuilder().From("jane@example.com").To("info@xn--bcher-kva.de").
+		Subject("Invoice").TextBody("body").Build(); err != nil {
+		t.Errorf("Build() with an A-label domain: %v", err)
+	}
+}
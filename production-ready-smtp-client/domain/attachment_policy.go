@@ -0,0 +1,177 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrAttachmentTooLarge    = errors.New("attachment too large")
+	ErrAttachmentsTooLarge   = errors.New("attachments too large in total")
+	ErrAttachmentTypeBlocked = errors.New("attachment type not allowed")
+)
+
+// DefaultBlockedExtensions are executable and script types that mail
+// providers reject or quarantine
+var DefaultBlockedExtensions = []string{
+	".exe", ".js", ".jse", ".vbs", ".vbe", ".bat", ".cmd", ".com", ".scr",
+	".pif", ".msi", ".msp", ".jar", ".ps1", ".hta", ".cpl", ".wsf", ".lnk",
+}
+
+// AttachmentPolicy limits the attachments and embedded parts of an email.
+// A zero size means no limit.
+type AttachmentPolicy struct {
+	MaxSize      int64
+	MaxTotalSize int64
+	// BlockedExtensions are compared case-insensitively, with the dot
+	BlockedExtensions []string
+}
+
+// DefaultAttachmentPolicy returns limits that keep messages within what
+// the common providers accept: 10 MiB per file and 20 MiB in total, since
+// base64 grows content by a third and most providers cap messages at
+// 25 MiB
+func DefaultAttachmentPolicy() AttachmentPolicy {
+	return AttachmentPolicy{
+		MaxSize:           10 << 20,
+		MaxTotalSize:      20 << 20,
+		BlockedExtensions: DefaultBlockedExtensions,
+	}
+}
+
+// Check applies the policy to the attachments of email whose size is
+// known. Attachments read from a Reader are counted as empty; Enforce
+// limits those while they are read.
+func (p AttachmentPolicy) Check(email *Email) error {
+	var total int64
+	for _, att := range append(append([]Attachment(nil), email.Attachments...), email.Embedded...) {
+		if p.isBlocked(att.Filename) {
+			return fmt.Errorf("%w: %s", ErrAttachmentTypeBlocked, att.Filename)
+		}
+
+		size, err := att.size()
+		if err != nil {
+			return fmt.Errorf("attachment %s: %w", att.Filename, err)
+		}
+		if p.MaxSize > 0 && size > p.MaxSize {
+			return fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrAttachmentTooLarge, att.Filename, size, p.MaxSize)
+		}
+		total += size
+	}
+
+	if p.MaxTotalSize > 0 && total > p.MaxTotalSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrAttachmentsTooLarge, total, p.MaxTotalSize)
+	}
+	return nil
+}
+
+// Enforce checks email like Check and wraps the Reader of each streamed
+// attachment so that reading past the limits fails. It modifies email, so
+// callers apply it to a clone.
+func (p AttachmentPolicy) Enforce(email *Email) error {
+	if err := p.Check(email); err != nil {
+		return err
+	}
+	if p.MaxSize <= 0 && p.MaxTotalSize <= 0 {
+		return nil
+	}
+
+	// Streams share the budget left by the attachments of known size
+	var known int64
+	for _, att := range append(append([]Attachment(nil), email.Attachments...), email.Embedded...) {
+		if att.Data != nil || att.Reader == nil {
+			size, _ := att.size()
+			known += size
+		}
+	}
+	budget := &streamBudget{
+		limited:   p.MaxTotalSize > 0,
+		remaining: p.MaxTotalSize - known,
+	}
+
+	for _, list := range [][]Attachment{email.Attachments, email.Embedded} {
+		for i, att := range list {
+			if att.Data != nil || att.Reader == nil {
+				continue
+			}
+			list[i].Reader = &limitedAttachment{
+				r:        att.Reader,
+				filename: att.Filename,
+				limit:    p.MaxSize,
+				budget:   budget,
+			}
+		}
+	}
+	return nil
+}
+
+func (p AttachmentPolicy) isBlocked(filename string) bool {
+	// Windows ignores trailing dots and spaces, so "setup.exe." runs too
+	ext := strings.ToLower(filepath.Ext(strings.TrimRight(filename, ". ")))
+	if ext == "" {
+		return false
+	}
+	for _, blocked := range p.BlockedExtensions {
+		if strings.ToLower(blocked) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// size returns the size of the content if it is known without reading it
+func (a Attachment) size() (int64, error) {
+	switch {
+	case a.Data != nil:
+		return int64(len(a.Data)), nil
+	case a.Reader != nil:
+		return 0, nil
+	case a.Path != "":
+		info, err := os.Stat(a.Path)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	return 0, nil
+}
+
+// streamBudget is the total size left for streamed attachments
+type streamBudget struct {
+	limited   bool
+	remaining int64
+}
+
+// limitedAttachment fails the read that takes an attachment past its own
+// limit or the total budget
+type limitedAttachment struct {
+	r        io.Reader
+	filename string
+	limit    int64 // 0 for no limit
+	read     int64
+	budget   *streamBudget
+}
+
+func (l *limitedAttachment) consumed() bool {
+	s, ok := l.r.(consumable)
+	return ok && s.consumed()
+}
+
+func (l *limitedAttachment) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.limit > 0 && l.read > l.limit {
+		return n, fmt.Errorf("%w: %s is over %d bytes", ErrAttachmentTooLarge, l.filename, l.limit)
+	}
+	if l.budget.limited {
+		l.budget.remaining -= int64(n)
+		if l.budget.remaining < 0 {
+			return n, ErrAttachmentsTooLarge
+		}
+	}
+	return n, err
+}
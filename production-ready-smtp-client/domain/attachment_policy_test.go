@@ -0,0 +1,144 @@
+package domain
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func policyTestEmail(attachments []Attachment, embedded []Attachment) *Email {
+	return &Email{
+		From:        Address{Address: "from@example.com"},
+		To:          []Address{{Address: "to@example.org"}},
+		Subject:     "Files",
+		TextBody:    "body",
+		Attachments: attachments,
+		Embedded:    embedded,
+	}
+}
+
+func TestAttachmentPolicyCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(file, make([]byte, 300), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data := func(name string, size int) Attachment {
+		return Attachment{Filename: name, Data: make([]byte, size)}
+	}
+	policy := AttachmentPolicy{MaxSize: 400, MaxTotalSize: 500, BlockedExtensions: DefaultBlockedExtensions}
+
+	tests := []struct {
+		name        string
+		attachments []Attachment
+		embedded    []Attachment
+		want        error
+	}{
+		{"within limits", []Attachment{data("a.txt", 400), data("b.txt", 100)}, nil, nil},
+		{"one too large", []Attachment{data("a.txt", 401)}, nil, ErrAttachmentTooLarge},
+		{"total too large", []Attachment{data("a.txt", 300), data("b.txt", 201)}, nil, ErrAttachmentsTooLarge},
+		{"embedded count", []Attachment{data("a.txt", 300)}, []Attachment{data("logo.png", 201)}, ErrAttachmentsTooLarge},
+		{"file size from disk", []Attachment{{Filename: "report.pdf", Path: file}, data("b.txt", 201)}, nil, ErrAttachmentsTooLarge},
+		{"missing file", []Attachment{{Filename: "gone.pdf", Path: file + ".gone"}}, nil, os.ErrNotExist},
+		{"stream counted as empty", []Attachment{{Filename: "s.csv", Reader: strings.NewReader(strings.Repeat("x", 1000))}}, nil, nil},
+		{"blocked extension", []Attachment{data("setup.exe", 1)}, nil, ErrAttachmentTypeBlocked},
+		{"blocked embedded", nil, []Attachment{data("run.js", 1)}, ErrAttachmentTypeBlocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(policyTestEmail(tt.attachments, tt.embedded))
+			if tt.want == nil && err != nil || !errors.Is(err, tt.want) {
+				t.Errorf("Check = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachmentPolicyZeroHasNoLimits(t *testing.T) {
+	email := policyTestEmail([]Attachment{{Filename: "setup.exe", Data: make([]byte, 1<<20)}}, nil)
+	if err := (AttachmentPolicy{}).Check(email); err != nil {
+		t.Errorf("Check = %v", err)
+	}
+}
+
+func TestBlockedExtensions(t *testing.T) {
+	policy := DefaultAttachmentPolicy()
+	tests := []struct {
+		filename string
+		blocked  bool
+	}{
+		{"setup.exe", true},
+		{"SETUP.EXE", true},
+		{"invoice.pdf.exe", true},
+		{"setup.exe.", true},
+		{"setup.exe . ", true},
+		{"script.ps1", true},
+		{"report.pdf", false},
+		{"exe", false},
+		{"README", false},
+		{"archive.exe.zip", false},
+	}
+	for _, tt := range tests {
+		if got := policy.isBlocked(tt.filename); got != tt.blocked {
+			t.Errorf("isBlocked(%q) = %v, want %v", tt.filename, got, tt.blocked)
+		}
+	}
+}
+
+func TestAttachmentPolicyEnforceStreams(t *testing.T) {
+	stream := func(size int) Attachment {
+		return Attachment{Filename: "export.csv", Reader: strings.NewReader(strings.Repeat("x", size))}
+	}
+	tests := []struct {
+		name    string
+		policy  AttachmentPolicy
+		streams []Attachment
+		known   int
+		want    error
+	}{
+		{"within limits", AttachmentPolicy{MaxSize: 100, MaxTotalSize: 200}, []Attachment{stream(100), stream(50)}, 50, nil},
+		{"stream too large", AttachmentPolicy{MaxSize: 100}, []Attachment{stream(101)}, 0, ErrAttachmentTooLarge},
+		{"streams share the total", AttachmentPolicy{MaxTotalSize: 200}, []Attachment{stream(100), stream(101)}, 0, ErrAttachmentsTooLarge},
+		{"known sizes use up the total", AttachmentPolicy{MaxTotalSize: 200}, []Attachment{stream(100)}, 101, ErrAttachmentsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachments := append([]Attachment{{Filename: "known.txt", Data: make([]byte, tt.known)}}, tt.streams...)
+			email := policyTestEmail(attachments, nil)
+			if err := tt.policy.Enforce(email); err != nil {
+				t.Fatalf("Enforce = %v", err)
+			}
+
+			var err error
+			for _, att := range email.Attachments {
+				if _, err = att.ReadAll(); err != nil {
+					break
+				}
+			}
+			if tt.want == nil && err != nil || !errors.Is(err, tt.want) {
+				t.Errorf("reading = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachmentPolicyEnforceChecksKnownSizes(t *testing.T) {
+	email := policyTestEmail([]Attachment{{Filename: "a.txt", Data: make([]byte, 101)}}, nil)
+	if err := (AttachmentPolicy{MaxSize: 100}).Enforce(email); !errors.Is(err, ErrAttachmentTooLarge) {
+		t.Errorf("Enforce = %v, want ErrAttachmentTooLarge", err)
+	}
+}
+
+// Unlimited policies leave streams unwrapped
+func TestAttachmentPolicyEnforceWithoutLimits(t *testing.T) {
+	r := strings.NewReader("data")
+	email := policyTestEmail([]Attachment{{Filename: "s.csv", Reader: r}}, nil)
+	if err := (AttachmentPolicy{}).Enforce(email); err != nil {
+		t.Fatal(err)
+	}
+	if reader, ok := email.Attachments[0].Reader.(io.Reader); !ok || reader != io.Reader(r) {
+		t.Errorf("Reader = %T, want the original reader", email.Attachments[0].Reader)
+	}
+}
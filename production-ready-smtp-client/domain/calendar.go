@@ -0,0 +1,438 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CalendarMethod is the iTIP method (RFC 5546) of an invitation
+type CalendarMethod string
+
+const (
+	MethodRequest CalendarMethod = "REQUEST"
+	MethodCancel  CalendarMethod = "CANCEL"
+)
+
+// ParticipationRole is the ROLE of an attendee
+type ParticipationRole string
+
+const (
+	RoleRequired ParticipationRole = "REQ-PARTICIPANT"
+	RoleOptional ParticipationRole = "OPT-PARTICIPANT"
+	RoleChair    ParticipationRole = "CHAIR"
+)
+
+type Attendee struct {
+	Address
+	Role ParticipationRole
+	// RSVP asks the attendee's client to send a reply
+	RSVP bool
+}
+
+// CalendarEvent is a meeting invitation. Sending it again with the same
+// UID and a higher Sequence updates the meeting in the attendees'
+// calendars; Cancellation returns the event that cancels it.
+type CalendarEvent struct {
+	UID      string
+	Sequence int
+	Method   CalendarMethod
+
+	Summary     string
+	Description string
+	Location    string
+
+	Start time.Time
+	End   time.Time
+	// AllDay events use only the dates of Start and End, End being the
+	// day after the last day of the event
+	AllDay bool
+	// TimeZone is the zone the times are shown in. Times are sent in UTC
+	// if it is nil.
+	TimeZone *time.Location
+
+	Organizer Address
+	Attendees []Attendee
+}
+
+// Cancellation returns the event that cancels e
+func (e *CalendarEvent) Cancellation() *CalendarEvent {
+	cancel := e.clone()
+	cancel.Method = MethodCancel
+	cancel.Sequence++
+	return cancel
+}
+
+func (e *CalendarEvent) clone() *CalendarEvent {
+	clone := *e
+	clone.Attendees = append([]Attendee(nil), e.Attendees...)
+	return &clone
+}
+
+// Validate checks the event
+func (e *CalendarEvent) Validate() error {
+	if e.UID == "" {
+		return fmt.Errorf("event UID is required")
+	}
+	if e.Method != MethodRequest && e.Method != MethodCancel {
+		return fmt.Errorf("unsupported calendar method: %s", e.Method)
+	}
+	if e.Summary == "" {
+		return fmt.Errorf("event summary is required")
+	}
+	if e.Start.IsZero() || e.End.IsZero() || !e.End.After(e.Start) {
+		return fmt.Errorf("event must end after it starts")
+	}
+	if !isValidEmail(e.Organizer.Address) {
+		return fmt.Errorf("invalid organizer address: %s", e.Organizer.Address)
+	}
+	if len(e.Attendees) == 0 {
+		return fmt.Errorf("event needs at least one attendee")
+	}
+	for _, attendee := range e.Attendees {
+		if !isValidEmail(attendee.Address.Address) {
+			return fmt.Errorf("invalid attendee address: %s", attendee.Address.Address)
+		}
+	}
+	return nil
+}
+
+// ICS renders the event as an RFC 5545 iCalendar object. stamp is the
+// DTSTAMP, the time the object was created.
+func (e *CalendarEvent) ICS(stamp time.Time) string {
+	w := &icsWriter{}
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:-//go-smtp//production-ready-smtp-client//EN")
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:" + string(e.Method))
+
+	zone := e.zone()
+	if zone != nil && !e.AllDay {
+		writeTimeZone(w, zone, e.Start, e.End)
+	}
+
+	w.line("BEGIN:VEVENT")
+	w.line("UID:" + escapeText(e.UID))
+	w.line("SEQUENCE:" + strconv.Itoa(e.Sequence))
+	w.line("DTSTAMP:" + stamp.UTC().Format(icsUTCLayout))
+	w.line(e.formatTime("DTSTART", e.Start, zone))
+	w.line(e.formatTime("DTEND", e.End, zone))
+	w.line("SUMMARY:" + escapeText(e.Summary))
+	if e.Description != "" {
+		w.line("DESCRIPTION:" + escapeText(e.Description))
+	}
+	if e.Location != "" {
+		w.line("LOCATION:" + escapeText(e.Location))
+	}
+
+	w.line("ORGANIZER" + commonName(e.Organizer) + ":mailto:" + e.Organizer.Address)
+	for _, attendee := range e.Attendees {
+		role := attendee.Role
+		if role == "" {
+			role = RoleRequired
+		}
+		line := "ATTENDEE" + commonName(attendee.Address) +
+			";CUTYPE=INDIVIDUAL;ROLE=" + string(role) + ";PARTSTAT=NEEDS-ACTION"
+		if attendee.RSVP {
+			line += ";RSVP=TRUE"
+		}
+		w.line(line + ":mailto:" + attendee.Address.Address)
+	}
+
+	if e.Method == MethodCancel {
+		w.line("STATUS:CANCELLED")
+	} else {
+		w.line("STATUS:CONFIRMED")
+	}
+	w.line("TRANSP:OPAQUE")
+	w.line("END:VEVENT")
+	w.line("END:VCALENDAR")
+	return w.String()
+}
+
+// PlainText describes the event for the text body of an invitation that
+// has none
+func (e *CalendarEvent) PlainText() string {
+	var b strings.Builder
+	if e.Method == MethodCancel {
+		b.WriteString("Cancelled: ")
+	}
+	b.WriteString(e.Summary + "\n\n")
+
+	start, end := e.Start, e.End
+	if zone := e.zone(); zone != nil {
+		start, end = start.In(zone), end.In(zone)
+	}
+	if e.AllDay {
+		b.WriteString("When: " + start.Format("Mon Jan 2, 2006"))
+		if last := end.AddDate(0, 0, -1); last.After(start) {
+			b.WriteString(" - " + last.Format("Mon Jan 2, 2006"))
+		}
+	} else {
+		b.WriteString("When: " + start.Format("Mon Jan 2, 2006 15:04") + " - " + end.Format("15:04 MST"))
+	}
+	b.WriteString("\n")
+
+	if e.Location != "" {
+		b.WriteString("Where: " + e.Location + "\n")
+	}
+	b.WriteString("Organizer: " + e.Organizer.String() + "\n")
+	if e.Description != "" {
+		b.WriteString("\n" + e.Description + "\n")
+	}
+	return b.String()
+}
+
+const (
+	icsUTCLayout   = "20060102T150405Z"
+	icsLocalLayout = "20060102T150405"
+	icsDateLayout  = "20060102"
+)
+
+// zone returns the time zone to write, or nil for UTC. time.Local has no
+// usable TZID, so it is sent as UTC too.
+func (e *CalendarEvent) zone() *time.Location {
+	if e.TimeZone == nil || e.TimeZone == time.UTC || e.TimeZone == time.Local || e.TimeZone.String() == "Local" {
+		return nil
+	}
+	return e.TimeZone
+}
+
+func (e *CalendarEvent) formatTime(property string, t time.Time, zone *time.Location) string {
+	switch {
+	case e.AllDay:
+		if zone != nil {
+			t = t.In(zone)
+		}
+		return property + ";VALUE=DATE:" + t.Format(icsDateLayout)
+	case zone != nil:
+		return property + ";TZID=" + zone.String() + ":" + t.In(zone).Format(icsLocalLayout)
+	}
+	return property + ":" + t.UTC().Format(icsUTCLayout)
+}
+
+// writeTimeZone writes a VTIMEZONE for zone with the transitions from the
+// start of the year of start to the end of the year of end, which is what
+// a client needs to place the event
+func writeTimeZone(w *icsWriter, zone *time.Location, start, end time.Time) {
+	from := time.Date(start.In(zone).Year(), 1, 1, 0, 0, 0, 0, zone)
+	until := time.Date(end.In(zone).Year()+1, 1, 1, 0, 0, 0, 0, zone)
+
+	w.line("BEGIN:VTIMEZONE")
+	w.line("TZID:" + zone.String())
+
+	t := from
+	for {
+		periodStart, periodEnd := t.ZoneBounds()
+		name, offset := t.Zone()
+
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+
+		// An observance starts at the transition, written in the local
+		// time that was in effect before it
+		observed := "19700101T000000"
+		offsetFrom := offset
+		if !periodStart.IsZero() {
+			_, offsetFrom = periodStart.Add(-time.Second).Zone()
+			observed = periodStart.UTC().Add(time.Duration(offsetFrom) * time.Second).Format(icsLocalLayout)
+		}
+
+		w.line("BEGIN:" + kind)
+		w.line("DTSTART:" + observed)
+		w.line("TZOFFSETFROM:" + formatOffset(offsetFrom))
+		w.line("TZOFFSETTO:" + formatOffset(offset))
+		w.line("TZNAME:" + escapeText(name))
+		w.line("END:" + kind)
+
+		if periodEnd.IsZero() || !periodEnd.Before(until) {
+			break
+		}
+		t = periodEnd
+	}
+
+	w.line("END:VTIMEZONE")
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+// commonName returns the CN parameter for addr, or nothing if it has no
+// display name
+func commonName(addr Address) string {
+	if addr.Name == "" {
+		return ""
+	}
+	// Parameter values are quoted and can't contain quotes or control
+	// characters
+	name := strings.Map(func(r rune) rune {
+		if r == '"' || r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, addr.Name)
+	return `;CN="` + name + `"`
+}
+
+// escapeText escapes a TEXT value (RFC 5545 3.3.11)
+func escapeText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// icsWriter writes content lines folded at 75 octets (RFC 5545 3.1)
+// without splitting UTF-8 sequences
+type icsWriter struct {
+	b strings.Builder
+}
+
+func (w *icsWriter) line(s string) {
+	// Continuation lines start with a space, which counts towards the limit
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.b.WriteString(s[:cut])
+		w.b.WriteString("\r\n ")
+		s = s[cut:]
+		limit = 74
+	}
+	w.b.WriteString(s)
+	w.b.WriteString("\r\n")
+}
+
+func (w *icsWriter) String() string {
+	return w.b.String()
+}
+
+// newEventUID returns a globally unique UID for an event organized from
+// domainName
+func newEventUID(domainName string) string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic("domain: failed to read random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(random) + "@" + domainName
+}
+
+// CalendarEventBuilder provides a fluent interface for building events
+type CalendarEventBuilder struct {
+	event *CalendarEvent
+}
+
+// NewCalendarEventBuilder starts a new invitation. A UID is generated by
+// Build unless one is set.
+func NewCalendarEventBuilder() *CalendarEventBuilder {
+	return &CalendarEventBuilder{
+		event: &CalendarEvent{Method: MethodRequest},
+	}
+}
+
+// UID sets the UID, to update or cancel an event sent before
+func (b *CalendarEventBuilder) UID(uid string) *CalendarEventBuilder {
+	b.event.UID = uid
+	return b
+}
+
+// Sequence sets the revision of the event. Updates must increase it.
+func (b *CalendarEventBuilder) Sequence(sequence int) *CalendarEventBuilder {
+	b.event.Sequence = sequence
+	return b
+}
+
+func (b *CalendarEventBuilder) Summary(summary string) *CalendarEventBuilder {
+	b.event.Summary = summary
+	return b
+}
+
+func (b *CalendarEventBuilder) Description(description string) *CalendarEventBuilder {
+	b.event.Description = description
+	return b
+}
+
+func (b *CalendarEventBuilder) Location(location string) *CalendarEventBuilder {
+	b.event.Location = location
+	return b
+}
+
+// At sets the start and end of the event
+func (b *CalendarEventBuilder) At(start, end time.Time) *CalendarEventBuilder {
+	b.event.Start = start
+	b.event.End = end
+	b.event.AllDay = false
+	return b
+}
+
+// AllDay makes the event span whole days, from the date of first to the
+// date of last inclusive
+func (b *CalendarEventBuilder) AllDay(first, last time.Time) *CalendarEventBuilder {
+	b.event.Start = first
+	b.event.End = last.AddDate(0, 0, 1)
+	b.event.AllDay = true
+	return b
+}
+
+// TimeZone sets the zone the event is shown in, e.g. the result of
+// time.LoadLocation("Europe/Berlin")
+func (b *CalendarEventBuilder) TimeZone(zone *time.Location) *CalendarEventBuilder {
+	b.event.TimeZone = zone
+	return b
+}
+
+// Organizer sets the organizer, parsed like EmailBuilder.From
+func (b *CalendarEventBuilder) Organizer(organizer string) *CalendarEventBuilder {
+	b.event.Organizer = parseOrRaw(organizer)
+	return b
+}
+
+// Attendee adds a required attendee, parsed like EmailBuilder.To. With
+// rsvp the attendee is asked to reply.
+func (b *CalendarEventBuilder) Attendee(attendee string, rsvp bool) *CalendarEventBuilder {
+	return b.AttendeeWithRole(attendee, RoleRequired, rsvp)
+}
+
+// OptionalAttendee adds an attendee whose participation is optional
+func (b *CalendarEventBuilder) OptionalAttendee(attendee string, rsvp bool) *CalendarEventBuilder {
+	return b.AttendeeWithRole(attendee, RoleOptional, rsvp)
+}
+
+func (b *CalendarEventBuilder) AttendeeWithRole(attendee string, role ParticipationRole, rsvp bool) *CalendarEventBuilder {
+	b.event.Attendees = append(b.event.Attendees, Attendee{
+		Address: parseOrRaw(attendee),
+		Role:    role,
+		RSVP:    rsvp,
+	})
+	return b
+}
+
+// Cancel makes the event cancel the meeting with the same UID. The
+// sequence should be higher than that of the last update.
+func (b *CalendarEventBuilder) Cancel() *CalendarEventBuilder {
+	b.event.Method = MethodCancel
+	return b
+}
+
+func (b *CalendarEventBuilder) Build() (*CalendarEvent, error) {
+	if b.event.UID == "" && isValidEmail(b.event.Organizer.Address) {
+		b.event.UID = newEventUID(b.event.Organizer.Domain())
+	}
+	if err := b.event.Validate(); err != nil {
+		return nil, err
+	}
+	return b.event, nil
+}
@@ -0,0 +1,302 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var testStamp = time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+func testEvent() *CalendarEvent {
+	start := time.Date(2024, time.February, 1, 9, 30, 0, 0, time.UTC)
+	return &CalendarEvent{
+		UID:       "planning-2024@example.com",
+		Method:    MethodRequest,
+		Summary:   "Planning",
+		Start:     start,
+		End:       start.Add(time.Hour),
+		Organizer: Address{Name: "Sender", Address: "sender@example.com"},
+		Attendees: []Attendee{
+			{Address: Address{Name: "Alice", Address: "alice@example.org"}, RSVP: true},
+		},
+	}
+}
+
+// icsLines unfolds ics and splits it into content lines
+func icsLines(t *testing.T, ics string) []string {
+	t.Helper()
+	if !strings.HasSuffix(ics, "\r\n") {
+		t.Fatalf("ics does not end with CRLF:\n%s", ics)
+	}
+	unfolded := strings.ReplaceAll(ics, "\r\n ", "")
+	return strings.Split(strings.TrimSuffix(unfolded, "\r\n"), "\r\n")
+}
+
+func hasLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Planning", "Planning"},
+		{"Room 4, floor 2; east wing", `Room 4\, floor 2\; east wing`},
+		{`C:\shared\notes`, `C:\\shared\\notes`},
+		{"Agenda:\nbudget\r\nhiring\rmisc", `Agenda:\nbudget\nhiring\nmisc`},
+		{`\,`, `\\\,`},
+		{"Café über", "Café über"},
+	}
+	for _, tt := range tests {
+		if got := escapeText(tt.in); got != tt.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestICSWriterFolds(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Planning"},
+		{"exactly 75", "SUMMARY:" + strings.Repeat("a", 67)},
+		{"76", "SUMMARY:" + strings.Repeat("a", 68)},
+		{"long", "DESCRIPTION:" + strings.Repeat("abcdefghij", 30)},
+		{"multibyte", "DESCRIPTION:" + strings.Repeat("ü", 100)},
+		{"mixed", "LOCATION:" + strings.Repeat("a€", 60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &icsWriter{}
+			w.line(tt.line)
+			out := w.String()
+
+			physical := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			for i, line := range physical {
+				if len(line) > 75 {
+					t.Errorf("line %d is %d octets: %q", i, len(line), line)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d does not start with a space: %q", i, line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+				}
+			}
+			if len(tt.line) <= 75 && len(physical) != 1 {
+				t.Errorf("%d octet line folded into %d lines", len(tt.line), len(physical))
+			}
+			if got := icsLines(t, out); len(got) != 1 || got[0] != tt.line {
+				t.Errorf("unfolded = %q, want %q", got, tt.line)
+			}
+		})
+	}
+}
+
+func TestICSUTC(t *testing.T) {
+	for _, zone := range []*time.Location{nil, time.UTC, time.Local} {
+		event := testEvent()
+		event.TimeZone = zone
+		ics := event.ICS(testStamp)
+		lines := icsLines(t, ics)
+
+		for _, want := range []string{
+			"DTSTAMP:20240102T150405Z",
+			"DTSTART:20240201T093000Z",
+			"DTEND:20240201T103000Z",
+		} {
+			if !hasLine(lines, want) {
+				t.Errorf("zone %v: missing %q in\n%s", zone, want, ics)
+			}
+		}
+		if strings.Contains(ics, "VTIMEZONE") || strings.Contains(ics, "TZID") {
+			t.Errorf("zone %v: UTC event has a time zone:\n%s", zone, ics)
+		}
+	}
+}
+
+func TestICSTimeZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+	event := testEvent()
+	event.TimeZone = berlin
+	// The stamp is always UTC, whatever zone it is given in
+	ics := event.ICS(testStamp.In(berlin))
+	lines := icsLines(t, ics)
+
+	for _, want := range []string{
+		"DTSTAMP:20240102T150405Z",
+		"DTSTART;TZID=Europe/Berlin:20240201T103000",
+		"DTEND;TZID=Europe/Berlin:20240201T113000",
+		"TZID:Europe/Berlin",
+		// The observances from the start of 2024 to the start of 2025
+		"DTSTART:20231029T030000",
+		"DTSTART:20240331T020000",
+		"DTSTART:20241027T030000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"TZNAME:CEST",
+		"TZNAME:CET",
+	} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing %q in\n%s", want, ics)
+		}
+	}
+
+	// The VTIMEZONE must come before the event that refers to it
+	zoneAt := strings.Index(ics, "BEGIN:VTIMEZONE")
+	eventAt := strings.Index(ics, "BEGIN:VEVENT")
+	if zoneAt < 0 || zoneAt > eventAt {
+		t.Errorf("VTIMEZONE at %d, VEVENT at %d", zoneAt, eventAt)
+	}
+	if n := strings.Count(ics, "BEGIN:DAYLIGHT"); n != 1 {
+		t.Errorf("%d DAYLIGHT observances, want 1", n)
+	}
+	if n := strings.Count(ics, "BEGIN:STANDARD"); n != 2 {
+		t.Errorf("%d STANDARD observances, want 2", n)
+	}
+}
+
+func TestICSAllDay(t *testing.T) {
+	event := testEvent()
+	event.AllDay = true
+	event.Start = time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	event.End = time.Date(2024, time.February, 3, 0, 0, 0, 0, time.UTC)
+	ics := event.ICS(testStamp)
+	lines := icsLines(t, ics)
+
+	for _, want := range []string{
+		"DTSTART;VALUE=DATE:20240201",
+		"DTEND;VALUE=DATE:20240203",
+	} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing %q in\n%s", want, ics)
+		}
+	}
+}
+
+func TestICSMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  func() *CalendarEvent
+		method string
+		status string
+		seq    string
+	}{
+		{"request", testEvent, "METHOD:REQUEST", "STATUS:CONFIRMED", "SEQUENCE:0"},
+		{"cancellation", func() *CalendarEvent { return testEvent().Cancellation() }, "METHOD:CANCEL", "STATUS:CANCELLED", "SEQUENCE:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := icsLines(t, tt.event().ICS(testStamp))
+			for _, want := range []string{tt.method, tt.status, tt.seq, "UID:planning-2024@example.com"} {
+				if !hasLine(lines, want) {
+					t.Errorf("missing %q in %q", want, lines)
+				}
+			}
+		})
+	}
+}
+
+func TestICSProperties(t *testing.T) {
+	event := testEvent()
+	event.Summary = "Budget, hiring; misc"
+	event.Description = "Line one\nLine two"
+	event.Organizer.Name = `The "Boss"`
+	event.Attendees = append(event.Attendees, Attendee{
+		Address: Address{Address: "bob@example.org"},
+		Role:    RoleOptional,
+	})
+	lines := icsLines(t, event.ICS(testStamp))
+
+	for _, want := range []string{
+		`SUMMARY:Budget\, hiring\; misc`,
+		`DESCRIPTION:Line one\nLine two`,
+		`ORGANIZER;CN="The Boss":mailto:sender@example.com`,
+		`ATTENDEE;CN="Alice";CUTYPE=INDIVIDUAL;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:alice@example.org`,
+		`ATTENDEE;CUTYPE=INDIVIDUAL;ROLE=OPT-PARTICIPANT;PARTSTAT=NEEDS-ACTION:mailto:bob@example.org`,
+	} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing %q in %q", want, lines)
+		}
+	}
+	if hasLine(lines, "LOCATION:") {
+		t.Errorf("empty location written")
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	event := testEvent()
+	cancel := event.Cancellation()
+	cancel.Attendees[0].RSVP = false
+
+	if event.Method != MethodRequest || event.Sequence != 0 {
+		t.Errorf("original changed to %s, sequence %d", event.Method, event.Sequence)
+	}
+	if !event.Attendees[0].RSVP {
+		t.Error("cancellation shares attendees with the original")
+	}
+	if cancel.UID != event.UID {
+		t.Errorf("cancellation UID = %q, want %q", cancel.UID, event.UID)
+	}
+}
+
+func TestCalendarEventValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(e *CalendarEvent)
+		valid  bool
+	}{
+		{"valid", func(e *CalendarEvent) {}, true},
+		{"no UID", func(e *CalendarEvent) { e.UID = "" }, false},
+		{"unknown method", func(e *CalendarEvent) { e.Method = "PUBLISH" }, false},
+		{"no summary", func(e *CalendarEvent) { e.Summary = "" }, false},
+		{"ends before start", func(e *CalendarEvent) { e.End = e.Start.Add(-time.Minute) }, false},
+		{"zero length", func(e *CalendarEvent) { e.End = e.Start }, false},
+		{"invalid organizer", func(e *CalendarEvent) { e.Organizer.Address = "sender" }, false},
+		{"no attendees", func(e *CalendarEvent) { e.Attendees = nil }, false},
+		{"invalid attendee", func(e *CalendarEvent) { e.Attendees[0].Address.Address = "alice@" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := testEvent()
+			tt.modify(event)
+			if err := event.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCalendarEventBuilderUID(t *testing.T) {
+	build := func() *CalendarEvent {
+		event, err := NewCalendarEventBuilder().
+			Summary("Planning").
+			At(time.Now(), time.Now().Add(time.Hour)).
+			Organizer("Sender <sender@example.com>").
+			Attendee("alice@example.org", true).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+	first, second := build(), build()
+	if !strings.HasSuffix(first.UID, "@example.com") {
+		t.Errorf("UID = %q, want one at the organizer's domain", first.UID)
+	}
+	if first.UID == second.UID {
+		t.Errorf("two events got the same UID %q", first.UID)
+	}
+}
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// sniffLength is the number of leading bytes DetectContentType considers
+const sniffLength = 512
+
+// genericContentTypes are the sniffing results that say little about the
+// content, so a type derived from the file extension is preferred
+var genericContentTypes = map[string]bool{
+	"application/octet-stream":  true,
+	"text/plain; charset=utf-8": true,
+	"text/xml; charset=utf-8":   true,
+}
+
+// DetectContentType determines the MIME type of an attachment from the
+// leading bytes of its content, falling back to the extension of filename
+// when the content is not conclusive
+func DetectContentType(filename string, data []byte) string {
+	sniffed := http.DetectContentType(data)
+	if !genericContentTypes[sniffed] {
+		return sniffed
+	}
+
+	if byExtension := mime.TypeByExtension(filepath.Ext(filename)); byExtension != "" {
+		return byExtension
+	}
+	return sniffed
+}
@@ -0,0 +1,30 @@
+package domain
+
+import "testing"
+
+func TestDetectContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		want     string
+	}{
+		{"content wins over extension", "photo.txt", png, "image/png"},
+		{"pdf", "report", []byte("%PDF-1.4\n"), "application/pdf"},
+		{"html", "page", []byte("<!DOCTYPE html><html>"), "text/html; charset=utf-8"},
+		{"text falls back to extension", "data.csv", []byte("a,b\n1,2\n"), "text/csv; charset=utf-8"},
+		{"binary falls back to extension", "archive.zip", []byte{0x00, 0x01, 0x02}, "application/zip"},
+		{"plain text without extension", "README", []byte("hello"), "text/plain; charset=utf-8"},
+		{"binary without extension", "blob", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+		{"unknown extension", "data.unknownext", []byte{0x00, 0x01}, "application/octet-stream"},
+		{"empty", "empty.json", nil, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType(tt.filename, tt.data); got != tt.want {
+				t.Errorf("DetectContentType(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
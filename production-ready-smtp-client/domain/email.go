@@ -1,35 +1,135 @@
 package domain
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Email represents an email message
 type Email struct {
-	ID          string
-	From        string
-	To          []string
-	Cc          []string
-	Bcc         []string
+	ID   string
+	From Address
+	To   []Address
+	Cc   []Address
+	Bcc  []Address
+	// ReplyTo and Sender are only written when set. Sender names the
+	// mailbox that actually sent the message on behalf of From.
+	ReplyTo []Address
+	Sender  Address
+	// MessageID, InReplyTo and References hold message IDs without the
+	// angle brackets. MessageID is generated when the email is sent if
+	// left empty.
+	MessageID   string
+	InReplyTo   string
+	References  []string
 	Subject     string
 	TextBody    string
 	HTMLBody    string
 	Attachments []Attachment
-	Headers     map[string]string
-	Priority    Priority
-	CreatedAt   time.Time
-	Status      EmailStatus
-	Attempts    int
-	LastError   string
+	// Embedded holds inline parts, such as images, that HTMLBody refers to
+	// as cid:<ContentID>
+	Embedded []Attachment
+	// Calendar makes the email a meeting invitation or cancellation. The
+	// event is sent as a text/calendar alternative and an .ics attachment.
+	Calendar  *CalendarEvent
+	Headers   map[string]string
+	Priority  Priority
+	CreatedAt time.Time
+	Status    EmailStatus
+	Attempts  int
+	LastError string
 }
 
-// Attachment represents an email attachment
+// Attachment represents an email attachment. The content comes from Data,
+// Reader or Path, in that order of preference; Reader and Path let large
+// files be streamed into the message instead of held in memory.
 type Attachment struct {
 	Filename    string
 	ContentType string
 	Data        []byte
+	// Reader can only be consumed once. Readers attached with
+	// AttachStream or AttachReader fail with ErrStreamConsumed when a
+	// second send opens them after the first read from them, e.g. a retry
+	// after a failure during DATA, rather than sending what is left of
+	// the stream. Prefer Path when the content is a file.
+	Reader io.Reader
+	Path   string
+	// ContentID identifies an embedded part, without the angle brackets
+	ContentID string
+	// Message is an email attached as a message/rfc822 part. It is
+	// composed when the email is sent, in place of Data, Reader and Path.
+	Message *Email
+}
+
+// ErrStreamConsumed is returned when an attachment stream is opened again
+// after it was read from
+var ErrStreamConsumed = errors.New("attachment stream was already read")
+
+// Open returns a reader for the attachment content
+func (a Attachment) Open() (io.ReadCloser, error) {
+	switch {
+	case a.Data != nil:
+		return io.NopCloser(bytes.NewReader(a.Data)), nil
+	case a.Reader != nil:
+		if s, ok := a.Reader.(consumable); ok && s.consumed() {
+			return nil, ErrStreamConsumed
+		}
+		return io.NopCloser(a.Reader), nil
+	case a.Path != "":
+		return os.Open(a.Path)
+	default:
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+}
+
+// consumable is implemented by attachment readers that know whether they
+// were read from
+type consumable interface {
+	consumed() bool
+}
+
+// stream is the Reader of attachments added with AttachStream
+type stream struct {
+	mu   sync.Mutex
+	r    io.Reader
+	used bool
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used = true
+	return s.r.Read(p)
+}
+
+func (s *stream) consumed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// ReadAll returns the whole attachment content, for transports that need
+// it in memory
+func (a Attachment) ReadAll() ([]byte, error) {
+	if a.Data != nil {
+		return a.Data, nil
+	}
+
+	r, err := a.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
 }
 
 // Priority represents email priority
@@ -55,12 +155,12 @@ const (
 
 // Validate checks if the email is valid
 func (e *Email) Validate() error {
-	if e.From == "" {
+	if e.From.Address == "" {
 		return fmt.Errorf("from address is required")
 	}
 	
-	if !isValidEmail(e.From) {
-		return fmt.Errorf("invalid from address: %s", e.From)
+	if !isValidEmail(e.From.Address) {
+		return fmt.Errorf("invalid from address: %s", e.From.Address)
 	}
 	
 	if len(e.To) == 0 {
@@ -68,8 +168,30 @@ func (e *Email) Validate() error {
 	}
 	
 	for _, addr := range e.To {
-		if !isValidEmail(addr) {
-			return fmt.Errorf("invalid to address: %s", addr)
+		if !isValidEmail(addr.Address) {
+			return fmt.Errorf("invalid to address: %s", addr.Address)
+		}
+	}
+	
+	for _, addr := range append(e.Cc, e.Bcc...) {
+		if !isValidEmail(addr.Address) {
+			return fmt.Errorf("invalid recipient address: %s", addr.Address)
+		}
+	}
+	
+	for _, addr := range e.ReplyTo {
+		if !isValidEmail(addr.Address) {
+			return fmt.Errorf("invalid reply-to address: %s", addr.Address)
+		}
+	}
+	
+	if e.Sender.Address != "" && !isValidEmail(e.Sender.Address) {
+		return fmt.Errorf("invalid sender address: %s", e.Sender.Address)
+	}
+	
+	for _, id := range append([]string{e.MessageID, e.InReplyTo}, e.References...) {
+		if id != "" && !isValidMessageID(id) {
+			return fmt.Errorf("invalid message ID: %q", id)
 		}
 	}
 	
@@ -77,13 +199,71 @@ func (e *Email) Validate() error {
 		return fmt.Errorf("subject is required")
 	}
 	
-	if e.TextBody == "" && e.HTMLBody == "" {
+	if e.TextBody == "" && e.HTMLBody == "" && e.Calendar == nil {
 		return fmt.Errorf("at least one body (text or HTML) is required")
 	}
 	
+	if e.Calendar != nil {
+		if err := e.Calendar.Validate(); err != nil {
+			return fmt.Errorf("invalid calendar event: %w", err)
+		}
+	}
+	
+	if len(e.Embedded) > 0 && e.HTMLBody == "" {
+		return fmt.Errorf("embedded parts require an HTML body")
+	}
+	
+	for _, part := range e.Embedded {
+		if !isValidContentID(part.ContentID) {
+			return fmt.Errorf("invalid content ID for embedded %s: %q", part.Filename, part.ContentID)
+		}
+	}
+	
+	if err := e.ValidateHeaders(); err != nil {
+		return err
+	}
+	
 	return nil
 }
 
+// Clone returns a copy of the email that shares no slices or maps with the
+// original, so either can be modified without affecting the other
+func (e *Email) Clone() *Email {
+	clone := *e
+	clone.To = append([]Address(nil), e.To...)
+	clone.Cc = append([]Address(nil), e.Cc...)
+	clone.Bcc = append([]Address(nil), e.Bcc...)
+	clone.ReplyTo = append([]Address(nil), e.ReplyTo...)
+	clone.References = append([]string(nil), e.References...)
+	clone.Attachments = append([]Attachment(nil), e.Attachments...)
+	for i, att := range clone.Attachments {
+		if att.Message != nil {
+			clone.Attachments[i].Message = att.Message.Clone()
+		}
+	}
+	clone.Embedded = append([]Attachment(nil), e.Embedded...)
+	if e.Calendar != nil {
+		clone.Calendar = e.Calendar.clone()
+	}
+	if e.Headers != nil {
+		clone.Headers = make(map[string]string, len(e.Headers))
+		for key, value := range e.Headers {
+			clone.Headers[key] = value
+		}
+	}
+	return &clone
+}
+
+// Recipients returns the plain addresses of every To, Cc and Bcc
+// recipient, for the SMTP envelope
+func (e *Email) Recipients() []string {
+	recipients := make([]string, 0, len(e.To)+len(e.Cc)+len(e.Bcc))
+	recipients = append(recipients, Mailboxes(e.To)...)
+	recipients = append(recipients, Mailboxes(e.Cc)...)
+	recipients = append(recipients, Mailboxes(e.Bcc)...)
+	return recipients
+}
+
 // isValidEmail validates email address format
 func isValidEmail(email string) bool {
 	// RFC 5322 simplified regex
@@ -92,9 +272,27 @@ func isValidEmail(email string) bool {
 	return re.MatchString(email)
 }
 
+// isValidContentID checks that id can be used as the msg-id of a
+// Content-ID header and in a cid: URL
+func isValidContentID(id string) bool {
+	pattern := `^[a-zA-Z0-9!#$%&'*+\-/=?^_{|}~.@]+$`
+	re := regexp.MustCompile(pattern)
+	return re.MatchString(id)
+}
+
+// isValidMessageID checks that id can be used as a msg-id, which has the
+// form left@right
+func isValidMessageID(id string) bool {
+	at := strings.LastIndexByte(id, '@')
+	return at > 0 && at < len(id)-1 && isValidContentID(id)
+}
+
 // EmailBuilder provides a fluent interface for building emails
 type EmailBuilder struct {
 	email *Email
+	// err is the first error of a builder method that can fail, such as
+	// AttachFile, reported by Build
+	err error
 }
 
 
@@ -110,26 +308,84 @@ func NewEmailBuilder() *EmailBuilder {
 	}
 }
 
+// From sets the sender from an address such as "billing@acme.com" or
+// "Acme Billing <billing@acme.com>"
 func (b *EmailBuilder) From(from string) *EmailBuilder {
-	b.email.From = from
+	b.email.From = parseOrRaw(from)
 	return b
 }
 
+// FromAddress sets the sender from a display name and an address
+func (b *EmailBuilder) FromAddress(name, address string) *EmailBuilder {
+	b.email.From = Address{Name: name, Address: address}
+	return b
+}
+
+// To adds recipients, each parsed like the argument of From
 func (b *EmailBuilder) To(to ...string) *EmailBuilder {
-	b.email.To = append(b.email.To, to...)
+	b.email.To = appendParsed(b.email.To, to)
 	return b
 }
 
 func (b *EmailBuilder) Cc(cc ...string) *EmailBuilder {
-	b.email.Cc = append(b.email.Cc, cc...)
+	b.email.Cc = appendParsed(b.email.Cc, cc)
 	return b
 }
 
 func (b *EmailBuilder) Bcc(bcc ...string) *EmailBuilder {
+	b.email.Bcc = appendParsed(b.email.Bcc, bcc)
+	return b
+}
+
+func (b *EmailBuilder) ToAddress(to ...Address) *EmailBuilder {
+	b.email.To = append(b.email.To, to...)
+	return b
+}
+
+func (b *EmailBuilder) CcAddress(cc ...Address) *EmailBuilder {
+	b.email.Cc = append(b.email.Cc, cc...)
+	return b
+}
+
+func (b *EmailBuilder) BccAddress(bcc ...Address) *EmailBuilder {
 	b.email.Bcc = append(b.email.Bcc, bcc...)
 	return b
 }
 
+func appendParsed(addrs []Address, values []string) []Address {
+	for _, value := range values {
+		addrs = append(addrs, parseOrRaw(value))
+	}
+	return addrs
+}
+
+// ReplyTo adds addresses that replies should go to instead of From
+func (b *EmailBuilder) ReplyTo(replyTo ...string) *EmailBuilder {
+	b.email.ReplyTo = appendParsed(b.email.ReplyTo, replyTo)
+	return b
+}
+
+// Sender sets the mailbox that sends the message on behalf of From
+func (b *EmailBuilder) Sender(sender string) *EmailBuilder {
+	b.email.Sender = parseOrRaw(sender)
+	return b
+}
+
+// InReplyTo sets the message ID of the message this one replies to, with
+// or without angle brackets
+func (b *EmailBuilder) InReplyTo(messageID string) *EmailBuilder {
+	b.email.InReplyTo = trimMessageID(messageID)
+	return b
+}
+
+// References adds message IDs of earlier messages in the thread
+func (b *EmailBuilder) References(messageIDs ...string) *EmailBuilder {
+	for _, id := range messageIDs {
+		b.email.References = append(b.email.References, trimMessageID(id))
+	}
+	return b
+}
+
 func (b *EmailBuilder) Subject(subject string) *EmailBuilder {
 	b.email.Subject = subject
 	return b
@@ -154,12 +410,124 @@ func (b *EmailBuilder) Attach(filename, contentType string, data []byte) *EmailB
 	return b
 }
 
+// AttachPath attaches the file at path, which is read only while the
+// message is being sent
+func (b *EmailBuilder) AttachPath(filename, contentType, path string) *EmailBuilder {
+	b.email.Attachments = append(b.email.Attachments, Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Path:        path,
+	})
+	return b
+}
+
+// AttachStream attaches content read from r while the message is being
+// sent. A send that opens it again after it was read fails with
+// ErrStreamConsumed, see Attachment.Reader.
+func (b *EmailBuilder) AttachStream(filename, contentType string, r io.Reader) *EmailBuilder {
+	b.email.Attachments = append(b.email.Attachments, Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Reader:      &stream{r: r},
+	})
+	return b
+}
+
+// AttachFile attaches the file at path under its base name, with the
+// content type detected from its first bytes. The file is read only while
+// the message is being sent.
+func (b *EmailBuilder) AttachFile(path string) *EmailBuilder {
+	f, err := os.Open(path)
+	if err != nil {
+		b.setErr(fmt.Errorf("failed to attach %s: %w", path, err))
+		return b
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		b.setErr(fmt.Errorf("failed to attach %s: %w", path, err))
+		return b
+	}
+
+	filename := filepath.Base(path)
+	return b.AttachPath(filename, DetectContentType(filename, head[:n]), path)
+}
+
+// AttachReader attaches content read from r, with the content type
+// detected from its first bytes, which are read immediately. See
+// Attachment.Reader for the caveat about retries.
+func (b *EmailBuilder) AttachReader(filename string, r io.Reader) *EmailBuilder {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		b.setErr(fmt.Errorf("failed to attach %s: %w", filename, err))
+		return b
+	}
+
+	head = head[:n]
+	return b.AttachStream(filename, DetectContentType(filename, head), io.MultiReader(bytes.NewReader(head), r))
+}
+
+// AttachMessage attaches email as a message/rfc822 part, e.g. to forward
+// it, named after its subject
+func (b *EmailBuilder) AttachMessage(email *Email) *EmailBuilder {
+	b.email.Attachments = append(b.email.Attachments, Attachment{
+		Filename:    messageFilename(email.Subject),
+		ContentType: MessageContentType,
+		Message:     email,
+	})
+	return b
+}
+
+// AttachRawMessage attaches a complete message, such as the content of an
+// .eml file, as a message/rfc822 part. Its bytes are sent unchanged apart
+// from line endings, so the original headers are preserved exactly.
+func (b *EmailBuilder) AttachRawMessage(filename string, raw []byte) *EmailBuilder {
+	b.email.Attachments = append(b.email.Attachments, Attachment{
+		Filename:    filename,
+		ContentType: MessageContentType,
+		Data:        raw,
+	})
+	return b
+}
+
+func (b *EmailBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Embed adds an inline part that HTMLBody can reference as
+// cid:<contentID>. The content type is detected from data.
+func (b *EmailBuilder) Embed(contentID, filename string, data []byte) *EmailBuilder {
+	b.email.Embedded = append(b.email.Embedded, Attachment{
+		Filename:    filename,
+		ContentType: DetectContentType(filename, data),
+		Data:        data,
+		ContentID:   contentID,
+	})
+	return b
+}
+
 func (b *EmailBuilder) Priority(priority Priority) *EmailBuilder {
 	b.email.Priority = priority
 	return b
 }
 
+// Invite attaches a calendar event, making the email an invitation or,
+// for a cancelling event, a cancellation. Attendees are not added as
+// recipients.
+func (b *EmailBuilder) Invite(event *CalendarEvent) *EmailBuilder {
+	b.email.Calendar = event
+	return b
+}
+
 func (b *EmailBuilder) Build() (*Email, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
 	if err := b.email.Validate(); err != nil {
 		return nil, err
 	}
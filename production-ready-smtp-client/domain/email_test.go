@@ -0,0 +1,132 @@
+package domain
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func streamAttachment(t *testing.T, content string) Attachment {
+	t.Helper()
+	email, err := NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Data").
+		TextBody("body").
+		AttachStream("data.csv", "text/csv", strings.NewReader(content)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return email.Attachments[0]
+}
+
+func TestStreamCanOnlyBeReadOnce(t *testing.T) {
+	att := streamAttachment(t, "a,b\n1,2\n")
+
+	// Opening without reading, as a send that fails before the
+	// attachment does, leaves the stream usable
+	r, err := att.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	data, err := att.ReadAll()
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Fatalf("ReadAll = %q, %v", data, err)
+	}
+
+	if _, err := att.Open(); !errors.Is(err, ErrStreamConsumed) {
+		t.Errorf("second Open = %v, want ErrStreamConsumed", err)
+	}
+	if _, err := att.ReadAll(); !errors.Is(err, ErrStreamConsumed) {
+		t.Errorf("second ReadAll = %v, want ErrStreamConsumed", err)
+	}
+}
+
+func TestPartlyReadStreamIsConsumed(t *testing.T) {
+	att := streamAttachment(t, "a,b\n1,2\n")
+
+	r, err := att.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rest of the stream must not be sent as the whole content
+	if _, err := att.Open(); !errors.Is(err, ErrStreamConsumed) {
+		t.Errorf("Open after a partial read = %v, want ErrStreamConsumed", err)
+	}
+}
+
+func TestEnforcedStreamIsConsumed(t *testing.T) {
+	email, err := NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Data").
+		TextBody("body").
+		AttachStream("data.csv", "text/csv", strings.NewReader("a,b\n")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DefaultAttachmentPolicy().Enforce(email); err != nil {
+		t.Fatal(err)
+	}
+
+	att := email.Attachments[0]
+	if _, err := att.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := att.Open(); !errors.Is(err, ErrStreamConsumed) {
+		t.Errorf("second Open = %v, want ErrStreamConsumed", err)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name      string
+		html      string
+		contentID string
+		wantErr   string
+	}{
+		{"valid", `<img src="cid:logo@example.com">`, "logo@example.com", ""},
+		{"no html body", "", "logo", "embedded parts require an HTML body"},
+		{"empty content ID", `<img src="cid:">`, "", "invalid content ID for embedded logo.png"},
+		{"space in content ID", `<img src="cid:my logo">`, "my logo", "invalid content ID"},
+		{"angle brackets", `<img src="cid:logo">`, "<logo>", "invalid content ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := NewEmailBuilder().
+				From("from@example.com").
+				To("to@example.org").
+				Subject("Logo").
+				TextBody("body").
+				HTMLBody(tt.html).
+				Embed(tt.contentID, "logo.png", png).
+				Build()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Build() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(email.Embedded) != 1 || len(email.Attachments) != 0 {
+				t.Fatalf("%d embedded, %d attachments", len(email.Embedded), len(email.Attachments))
+			}
+			part := email.Embedded[0]
+			if part.ContentID != tt.contentID || part.Filename != "logo.png" || part.ContentType != "image/png" {
+				t.Errorf("embedded part = %q %q %q", part.ContentID, part.Filename, part.ContentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MessageContentType is the content type of an attached email
+const MessageContentType = "message/rfc822"
+
+// NewForwardBuilder starts a forward of original, which is attached as a
+// message/rfc822 part so that its headers and parts reach the recipients
+// intact. The subject gets a single "Fwd: " prefix. Sender, recipients and
+// any covering text are left to the caller.
+func NewForwardBuilder(original *Email) *EmailBuilder {
+	b := NewEmailBuilder()
+	b.email.Subject = forwardSubject(original.Subject)
+	return b.AttachMessage(original)
+}
+
+// forwardSubject prefixes subject with "Fwd: " unless it already has it
+func forwardSubject(subject string) string {
+	for _, prefix := range []string{"fwd:", "fw:"} {
+		if len(subject) >= len(prefix) && strings.EqualFold(subject[:len(prefix)], prefix) {
+			return subject
+		}
+	}
+	return "Fwd: " + subject
+}
+
+// messageFilename derives a file name for an attached email from its
+// subject, replacing the characters file systems reject
+func messageFilename(subject string) string {
+	const maxLength = 100
+
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(subject))
+	if utf8.RuneCountInString(name) > maxLength {
+		name = string([]rune(name)[:maxLength])
+	}
+	if name == "" {
+		name = "message"
+	}
+	return name + ".eml"
+}
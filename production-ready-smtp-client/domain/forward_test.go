@@ -0,0 +1,114 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForwardSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Invoice", "Fwd: Invoice"},
+		{"", "Fwd: "},
+		{"Fwd: Invoice", "Fwd: Invoice"},
+		{"FWD: Invoice", "FWD: Invoice"},
+		{"Fw: Invoice", "Fw: Invoice"},
+		{"Re: Invoice", "Fwd: Re: Invoice"},
+		{"Forwarding rules", "Fwd: Forwarding rules"},
+	}
+	for _, tt := range tests {
+		if got := forwardSubject(tt.subject); got != tt.want {
+			t.Errorf("forwardSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestMessageFilename(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Invoice 42", "Invoice 42.eml"},
+		{"  padded  ", "padded.eml"},
+		{"", "message.eml"},
+		{`a/b\c:d*e?f"g<h>i|j`, "a_b_c_d_e_f_g_h_i_j.eml"},
+		{"tab\there", "tab_here.eml"},
+		{"Grüße", "Grüße.eml"},
+		{strings.Repeat("ü", 120), strings.Repeat("ü", 100) + ".eml"},
+	}
+	for _, tt := range tests {
+		if got := messageFilename(tt.subject); got != tt.want {
+			t.Errorf("messageFilename(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestNewForwardBuilder(t *testing.T) {
+	original := &Email{
+		From:     Address{Address: "customer@example.net"},
+		To:       []Address{{Address: "support@example.com"}},
+		Subject:  "Broken invoice",
+		TextBody: "The total is wrong.",
+	}
+
+	forward, err := NewForwardBuilder(original).
+		From("agent@example.com").
+		To("billing@example.com").
+		TextBody("Please have a look.").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if forward.Subject != "Fwd: Broken invoice" {
+		t.Errorf("Subject = %q", forward.Subject)
+	}
+	if len(forward.Attachments) != 1 {
+		t.Fatalf("attachments = %+v", forward.Attachments)
+	}
+	att := forward.Attachments[0]
+	if att.Message != original || att.ContentType != MessageContentType || att.Filename != "Broken invoice.eml" {
+		t.Errorf("attachment = %+v", att)
+	}
+	// Recipients are left to the caller
+	if strings.Join(forward.Recipients(), ",") != "billing@example.com" {
+		t.Errorf("recipients = %v", forward.Recipients())
+	}
+}
+
+func TestAttachSeveralMessages(t *testing.T) {
+	first := &Email{Subject: "First"}
+	raw := []byte("Subject: Second\r\n\r\nbody\r\n")
+
+	digest, err := NewEmailBuilder().
+		From("digest@example.com").
+		To("team@example.com").
+		Subject("Digest").
+		TextBody("Two messages.").
+		AttachMessage(first).
+		AttachRawMessage("second.eml", raw).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(digest.Attachments) != 2 {
+		t.Fatalf("attachments = %+v", digest.Attachments)
+	}
+	if a := digest.Attachments[0]; a.Message != first || a.Filename != "First.eml" {
+		t.Errorf("first = %+v", a)
+	}
+	if a := digest.Attachments[1]; a.Message != nil || string(a.Data) != string(raw) || a.ContentType != MessageContentType {
+		t.Errorf("second = %+v", a)
+	}
+
+	// Clones copy attached emails too, so a later change to the original
+	// does not reach a queued copy
+	clone := digest.Clone()
+	first.Subject = "Changed"
+	if clone.Attachments[0].Message.Subject != "First" {
+		t.Error("clone shares the attached email")
+	}
+}
@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ErrHeaderInjection is returned for a value that would end up in a header
+// but contains a line break, which could add headers or start the body
+var ErrHeaderInjection = errors.New("line break in header value")
+
+// ErrInvalidAddress is returned for an address that is not a single
+// RFC 5322 addr-spec
+var ErrInvalidAddress = errors.New("invalid address")
+
+// reservedHeaders are written from the typed fields of Email and cannot be
+// set or overridden through Email.Headers
+var reservedHeaders = map[string]bool{
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Sender":                    true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Message-Id":                true,
+	"In-Reply-To":               true,
+	"References":                true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+	"Content-Id":                true,
+}
+
+// IsReservedHeader reports whether name is a header that Email.Headers
+// cannot set
+func IsReservedHeader(name string) bool {
+	return reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+// ValidateHeaders checks every value of e that is written into a header
+// field: the custom headers, subject, addresses, message IDs and the
+// names and types of attachments. It is part of Validate, and is also run
+// by the composer for emails that never went through Validate.
+func (e *Email) ValidateHeaders() error {
+	if err := ValidateHeaders(e.Headers); err != nil {
+		return err
+	}
+
+	if err := checkHeaderValue("Subject", e.Subject); err != nil {
+		return err
+	}
+
+	addresses := []struct {
+		field string
+		addrs []Address
+	}{
+		{"From", []Address{e.From}},
+		{"Sender", []Address{e.Sender}},
+		{"To", e.To},
+		{"Cc", e.Cc},
+		{"Bcc", e.Bcc},
+		{"Reply-To", e.ReplyTo},
+	}
+	for _, list := range addresses {
+		for _, addr := range list.addrs {
+			if err := checkHeaderValue(list.field, addr.Name+addr.Address); err != nil {
+				return err
+			}
+			if err := checkAddress(list.field, addr.Address); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, id := range append([]string{e.MessageID, e.InReplyTo}, e.References...) {
+		if err := checkHeaderValue("Message-ID", id); err != nil {
+			return err
+		}
+	}
+
+	for _, att := range append(append([]Attachment{}, e.Attachments...), e.Embedded...) {
+		if err := checkHeaderValue("attachment filename", att.Filename); err != nil {
+			return err
+		}
+		if err := checkHeaderValue("attachment content type", att.ContentType); err != nil {
+			return err
+		}
+		if err := checkHeaderValue("content ID", att.ContentID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateHeaders checks custom headers as Email.Headers accepts them:
+// valid names that are not reserved, and values without line breaks
+func ValidateHeaders(headers map[string]string) error {
+	for key, value := range headers {
+		if !isValidHeaderName(key) {
+			return fmt.Errorf("invalid header name: %q", key)
+		}
+		if IsReservedHeader(key) {
+			return fmt.Errorf("header %s cannot be set through Headers", key)
+		}
+		if err := checkHeaderValue(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHeaderValue rejects CR, LF and NUL, the characters that can break
+// out of a header field
+func checkHeaderValue(field, value string) error {
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("%s: %w", field, ErrHeaderInjection)
+	}
+	return nil
+}
+
+// checkAddress rejects an address that is not a single addr-spec. The
+// composer writes Address as it is, so "b@example.com>, evil@example.com"
+// would otherwise become a second recipient. An empty address is left to
+// Validate, since Sender is optional.
+func checkAddress(field, address string) error {
+	if address == "" {
+		return nil
+	}
+	if strings.ContainsAny(address, "<>,") {
+		return fmt.Errorf("%s %q: %w", field, address, ErrInvalidAddress)
+	}
+	if _, err := mail.ParseAddress(address); err != nil {
+		return fmt.Errorf("%s %q: %w: %v", field, address, ErrInvalidAddress, err)
+	}
+	return nil
+}
+
+// isValidHeaderName checks name against the RFC 5322 field-name grammar:
+// printable ASCII except the colon
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
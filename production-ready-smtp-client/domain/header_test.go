@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateHeadersAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(e *Email)
+		wantErr error
+	}{
+		{"valid", func(e *Email) {}, nil},
+		{"display name", func(e *Email) { e.To[0].Name = `Bob "The Builder", Jr.` }, nil},
+		{"no Sender", func(e *Email) { e.Sender = Address{} }, nil},
+		{"second recipient", func(e *Email) { e.To[0].Address = "b@example.com>, evil@x.com" }, ErrInvalidAddress},
+		{"angle brackets", func(e *Email) { e.Cc = []Address{{Address: "<c@example.com>"}} }, ErrInvalidAddress},
+		{"name in address", func(e *Email) { e.From.Address = "Evil <evil@x.com>" }, ErrInvalidAddress},
+		{"comma", func(e *Email) { e.Bcc = []Address{{Address: "a@x.com,b@x.com"}} }, ErrInvalidAddress},
+		{"no domain", func(e *Email) { e.ReplyTo = []Address{{Address: "reply"}} }, ErrInvalidAddress},
+		{"bad Sender", func(e *Email) { e.Sender = Address{Address: "s@x.com, t@x.com"} }, ErrInvalidAddress},
+		{"line break", func(e *Email) { e.To[0].Address = "b@example.com\r\nBcc: evil@x.com" }, ErrHeaderInjection},
+	}
+
+	for _, tt := range tests {
+		email := &Email{
+			From: Address{Address: "from@example.com"},
+			To:   []Address{{Address: "to@example.com"}},
+		}
+		tt.modify(email)
+
+		err := email.ValidateHeaders()
+		if tt.wantErr == nil && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
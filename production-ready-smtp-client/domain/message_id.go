@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// messageIDCounter keeps IDs generated in the same nanosecond apart even
+// if the random source were to repeat
+var messageIDCounter atomic.Uint64
+
+// NewMessageID returns a globally unique message ID, without angle
+// brackets, under domainName. It combines the time, a process-wide counter
+// and 96 random bits, so IDs from concurrent sends and from different
+// hosts don't collide.
+func NewMessageID(domainName string) string {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic("domain: failed to read random bytes: " + err.Error())
+	}
+
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "." +
+		strconv.FormatUint(messageIDCounter.Add(1), 36) + "." +
+		hex.EncodeToString(random) + "@" + domainName
+}
+
+// EnsureMessageID gives the email a Message-ID under domainName, or under
+// the From domain if domainName is empty, unless it already has one. It
+// returns the Message-ID. Assigning the ID before the first attempt keeps
+// it stable across retries, so recipients can deduplicate the message and
+// bounces can be matched to it.
+func (e *Email) EnsureMessageID(domainName string) string {
+	return e.EnsureMessageIDWith(domainName, NewMessageID)
+}
+
+// EnsureMessageIDWith is EnsureMessageID with generate in place of
+// NewMessageID
+func (e *Email) EnsureMessageIDWith(domainName string, generate func(domainName string) string) string {
+	if e.MessageID == "" {
+		if domainName == "" {
+			domainName = e.From.Domain()
+		}
+		e.MessageID = generate(domainName)
+	}
+	return e.MessageID
+}
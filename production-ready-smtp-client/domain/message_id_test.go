@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func TestNewMessageIDFormat(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-z]+\.[0-9a-z]+\.[0-9a-f]{24}@example\.com$`)
+	id := NewMessageID("example.com")
+	if !format.MatchString(id) {
+		t.Errorf("NewMessageID() = %q, want time.counter.random@example.com", id)
+	}
+	if !isValidMessageID(id) {
+		t.Errorf("NewMessageID() = %q is not a valid msg-id", id)
+	}
+}
+
+func TestNewMessageIDUnique(t *testing.T) {
+	const workers, perWorker = 8, 1000
+
+	ids := make(chan string, workers*perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				ids <- NewMessageID("example.com")
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool, workers*perWorker)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate message ID %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestEnsureMessageID(t *testing.T) {
+	tests := []struct {
+		name       string
+		messageID  string
+		domainName string
+		want       *regexp.Regexp
+	}{
+		{"from domain", "", "", regexp.MustCompile(`@sender\.example\.com$`)},
+		{"given domain", "", "mail.example.org", regexp.MustCompile(`@mail\.example\.org$`)},
+		{"existing kept", "existing@example.net", "mail.example.org", regexp.MustCompile(`^existing@example\.net$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &Email{
+				From:      Address{Address: "news@Sender.Example.com"},
+				MessageID: tt.messageID,
+			}
+			id := email.EnsureMessageID(tt.domainName)
+			if !tt.want.MatchString(id) {
+				t.Errorf("EnsureMessageID() = %q, want match for %s", id, tt.want)
+			}
+			if email.MessageID != id {
+				t.Errorf("MessageID = %q, want %q", email.MessageID, id)
+			}
+			// A retry keeps the ID
+			if again := email.EnsureMessageID(tt.domainName); again != id {
+				t.Errorf("second EnsureMessageID() = %q, want %q", again, id)
+			}
+		})
+	}
+}
+
+func TestEnsureMessageIDWith(t *testing.T) {
+	var domains []string
+	generate := func(domainName string) string {
+		domains = append(domains, domainName)
+		return "generated@" + domainName
+	}
+
+	email := &Email{From: Address{Address: "news@example.com"}}
+	if id := email.EnsureMessageIDWith("", generate); id != "generated@example.com" {
+		t.Errorf("EnsureMessageIDWith() = %q, want %q", id, "generated@example.com")
+	}
+	email.EnsureMessageIDWith("", generate)
+	if len(domains) != 1 {
+		t.Errorf("generate called %d times, want once", len(domains))
+	}
+}
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNoMessageID is returned when replying to an email that was never
+// given a Message-ID, so a reply could not be threaded to it
+var ErrNoMessageID = errors.New("email has no message ID")
+
+// NewReplyBuilder starts a reply to original, a previously sent email. The
+// reply continues the conversation from the same side: it keeps From,
+// Sender and Reply-To, goes to the original To and Cc recipients, and
+// threads under original through In-Reply-To and References. Each
+// recipient is kept once, and From is dropped unless the original went
+// only to it. The subject gets a single "Re: " prefix. Bodies and
+// attachments are left to the caller.
+func NewReplyBuilder(original *Email) (*EmailBuilder, error) {
+	if original.MessageID == "" {
+		return nil, ErrNoMessageID
+	}
+
+	b := NewEmailBuilder()
+	b.email.From = original.From
+	b.email.Sender = original.Sender
+	b.email.ReplyTo = append([]Address(nil), original.ReplyTo...)
+	b.email.To, b.email.Cc = replyRecipients(original.From, original.To, original.Cc)
+	b.email.Subject = replySubject(original.Subject)
+	b.email.InReplyTo = original.MessageID
+
+	// RFC 5322 3.6.4: the parent's References followed by its Message-ID,
+	// or its In-Reply-To if it has no References
+	references := original.References
+	if len(references) == 0 && original.InReplyTo != "" {
+		references = []string{original.InReplyTo}
+	}
+	b.email.References = append(append([]string(nil), references...), original.MessageID)
+
+	return b, nil
+}
+
+// replyRecipients returns to and cc without duplicates, compared
+// case-insensitively, and without from, which is sending the reply. If
+// that leaves no To recipient, the Cc recipients become To; a message that
+// went only to from, such as a note to self, is answered to from again.
+func replyRecipients(from Address, to, cc []Address) ([]Address, []Address) {
+	seen := map[string]bool{strings.ToLower(from.Address): true}
+	unique := func(addrs []Address) []Address {
+		var kept []Address
+		for _, addr := range addrs {
+			key := strings.ToLower(addr.Address)
+			if !seen[key] {
+				seen[key] = true
+				kept = append(kept, addr)
+			}
+		}
+		return kept
+	}
+
+	replyTo, replyCc := unique(to), unique(cc)
+	switch {
+	case len(replyTo) > 0:
+	case len(replyCc) > 0:
+		// A reply needs a To recipient
+		replyTo, replyCc = replyCc, nil
+	case len(to) > 0:
+		replyTo = []Address{to[0]}
+	}
+	return replyTo, replyCc
+}
+
+// replySubject prefixes subject with "Re: " unless it already has it
+func replySubject(subject string) string {
+	if len(subject) >= 3 && strings.EqualFold(subject[:3], "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// trimMessageID removes the angle brackets around a message ID
+func trimMessageID(id string) string {
+	id = strings.TrimSpace(id)
+	return strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+}
@@ -0,0 +1,301 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReplySubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Ticket 42", "Re: Ticket 42"},
+		{"", "Re: "},
+		{"Re: Ticket 42", "Re: Ticket 42"},
+		{"RE: Ticket 42", "RE: Ticket 42"},
+		{"re:Ticket 42", "re:Ticket 42"},
+		{"Fwd: Ticket 42", "Re: Fwd: Ticket 42"},
+		{"Regarding ticket 42", "Re: Regarding ticket 42"},
+		{"Re", "Re: Re"},
+	}
+	for _, tt := range tests {
+		if got := replySubject(tt.subject); got != tt.want {
+			t.Errorf("replySubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestReplySubjectNotDoubled(t *testing.T) {
+	original := &Email{
+		From:      Address{Address: "support@example.com"},
+		To:        []Address{{Address: "customer@example.net"}},
+		Subject:   "Ticket 42",
+		MessageID: "1@example.com",
+	}
+	subject := original.Subject
+	for i := 0; i < 3; i++ {
+		b, err := NewReplyBuilder(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := b.TextBody("More").Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		subject = reply.Subject
+		reply.MessageID = NewMessageID("example.com")
+		original = reply
+	}
+	if subject != "Re: Ticket 42" {
+		t.Errorf("subject after three replies = %q, want %q", subject, "Re: Ticket 42")
+	}
+}
+
+func TestReplyThreading(t *testing.T) {
+	tests := []struct {
+		name           string
+		inReplyTo      string
+		references     []string
+		wantReferences []string
+	}{
+		{
+			name:           "first reply",
+			wantReferences: []string{"3@example.com"},
+		},
+		{
+			name:           "parent with references",
+			inReplyTo:      "2@example.net",
+			references:     []string{"1@example.com", "2@example.net"},
+			wantReferences: []string{"1@example.com", "2@example.net", "3@example.com"},
+		},
+		{
+			name:           "parent with only in-reply-to",
+			inReplyTo:      "2@example.net",
+			wantReferences: []string{"2@example.net", "3@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := &Email{
+				From:       Address{Address: "support@example.com"},
+				To:         []Address{{Address: "customer@example.net"}},
+				Subject:    "Ticket 42",
+				MessageID:  "3@example.com",
+				InReplyTo:  tt.inReplyTo,
+				References: tt.references,
+			}
+			b, err := NewReplyBuilder(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := b.TextBody("Thanks").Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.InReplyTo != "3@example.com" {
+				t.Errorf("InReplyTo = %q, want %q", reply.InReplyTo, "3@example.com")
+			}
+			if !reflect.DeepEqual(reply.References, tt.wantReferences) {
+				t.Errorf("References = %q, want %q", reply.References, tt.wantReferences)
+			}
+
+			// The reply must not share the original's slices
+			reply.References[0] = "changed@example.com"
+			if len(tt.references) > 0 && original.References[0] == "changed@example.com" {
+				t.Error("reply shares References with the original")
+			}
+		})
+	}
+}
+
+func TestReplyThreadChain(t *testing.T) {
+	email := &Email{
+		From:      Address{Address: "support@example.com"},
+		To:        []Address{{Address: "customer@example.net"}},
+		Subject:   "Ticket 42",
+		MessageID: "0@example.com",
+	}
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, email.MessageID)
+		b, err := NewReplyBuilder(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := b.TextBody("More").Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reply.References, ids) {
+			t.Fatalf("reply %d References = %q, want %q", i+1, reply.References, ids)
+		}
+		if reply.InReplyTo != email.MessageID {
+			t.Fatalf("reply %d InReplyTo = %q, want %q", i+1, reply.InReplyTo, email.MessageID)
+		}
+		reply.MessageID = NewMessageID("example.com")
+		email = reply
+	}
+}
+
+func TestReplyRecipients(t *testing.T) {
+	from := Address{Name: "Support", Address: "support@example.com"}
+	alice := Address{Name: "Alice", Address: "alice@example.net"}
+	bob := Address{Address: "bob@example.org"}
+
+	tests := []struct {
+		name   string
+		to     []Address
+		cc     []Address
+		wantTo []Address
+		wantCc []Address
+	}{
+		{
+			name:   "unchanged",
+			to:     []Address{alice},
+			cc:     []Address{bob},
+			wantTo: []Address{alice},
+			wantCc: []Address{bob},
+		},
+		{
+			name:   "duplicates in to",
+			to:     []Address{alice, bob, {Address: "ALICE@example.net"}},
+			wantTo: []Address{alice, bob},
+		},
+		{
+			name:   "cc already in to",
+			to:     []Address{alice},
+			cc:     []Address{{Address: "Alice@Example.net"}, bob, bob},
+			wantTo: []Address{alice},
+			wantCc: []Address{bob},
+		},
+		{
+			name:   "sender excluded",
+			to:     []Address{alice, {Address: "Support@example.com"}},
+			cc:     []Address{from},
+			wantTo: []Address{alice},
+		},
+		{
+			name:   "only cc left",
+			to:     []Address{from},
+			cc:     []Address{alice, bob},
+			wantTo: []Address{alice, bob},
+		},
+		{
+			name:   "note to self",
+			to:     []Address{from},
+			wantTo: []Address{from},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := &Email{
+				From:      from,
+				To:        tt.to,
+				Cc:        tt.cc,
+				Subject:   "Ticket 42",
+				MessageID: "1@example.com",
+			}
+			b, err := NewReplyBuilder(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := b.TextBody("Thanks").Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reply.To, tt.wantTo) {
+				t.Errorf("To = %+v, want %+v", reply.To, tt.wantTo)
+			}
+			if !reflect.DeepEqual(reply.Cc, tt.wantCc) {
+				t.Errorf("Cc = %+v, want %+v", reply.Cc, tt.wantCc)
+			}
+			if reply.From != from {
+				t.Errorf("From = %+v, want %+v", reply.From, from)
+			}
+		})
+	}
+}
+
+func TestReplyKeepsSenderAndReplyTo(t *testing.T) {
+	original := &Email{
+		From:      Address{Name: "Support", Address: "support@example.com"},
+		Sender:    Address{Address: "mailer@example.com"},
+		ReplyTo:   []Address{{Address: "tickets@example.com"}},
+		To:        []Address{{Address: "customer@example.net"}},
+		Bcc:       []Address{{Address: "archive@example.com"}},
+		Subject:   "Ticket 42",
+		MessageID: "1@example.com",
+		TextBody:  "Original body",
+	}
+	b, err := NewReplyBuilder(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := b.TextBody("Thanks").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Sender != original.Sender {
+		t.Errorf("Sender = %+v, want %+v", reply.Sender, original.Sender)
+	}
+	if !reflect.DeepEqual(reply.ReplyTo, original.ReplyTo) {
+		t.Errorf("ReplyTo = %+v, want %+v", reply.ReplyTo, original.ReplyTo)
+	}
+	if len(reply.Bcc) != 0 {
+		t.Errorf("Bcc = %+v, want none", reply.Bcc)
+	}
+	if reply.TextBody != "Thanks" {
+		t.Errorf("TextBody = %q, want the caller's", reply.TextBody)
+	}
+	if reply.MessageID != "" {
+		t.Errorf("MessageID = %q, want a new one to be assigned on send", reply.MessageID)
+	}
+
+	reply.ReplyTo[0].Address = "changed@example.com"
+	if original.ReplyTo[0].Address != "tickets@example.com" {
+		t.Error("reply shares ReplyTo with the original")
+	}
+}
+
+func TestReplyWithoutMessageID(t *testing.T) {
+	original := &Email{
+		From:    Address{Address: "support@example.com"},
+		To:      []Address{{Address: "customer@example.net"}},
+		Subject: "Ticket 42",
+	}
+	if _, err := NewReplyBuilder(original); !errors.Is(err, ErrNoMessageID) {
+		t.Errorf("NewReplyBuilder() = %v, want ErrNoMessageID", err)
+	}
+}
+
+func TestBuilderThreadingTrimsBrackets(t *testing.T) {
+	email, err := NewEmailBuilder().
+		From("support@example.com").
+		To("customer@example.net").
+		Subject("Re: Ticket 42").
+		TextBody("Thanks").
+		InReplyTo(" <2@example.net> ").
+		References("<1@example.com>", "2@example.net").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.InReplyTo != "2@example.net" {
+		t.Errorf("InReplyTo = %q, want %q", email.InReplyTo, "2@example.net")
+	}
+	if want := []string{"1@example.com", "2@example.net"}; !reflect.DeepEqual(email.References, want) {
+		t.Errorf("References = %q, want %q", email.References, want)
+	}
+
+	if _, err := NewEmailBuilder().
+		From("support@example.com").
+		To("customer@example.net").
+		Subject("Re: Ticket 42").
+		TextBody("Thanks").
+		InReplyTo("not a message id").
+		Build(); err == nil {
+		t.Error("Build() with an invalid In-Reply-To succeeded")
+	}
+}
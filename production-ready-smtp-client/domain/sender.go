@@ -15,4 +15,29 @@ type EmailRepository interface {
 	GetByID(ctx context.Context, id string) (*Email, error)
 	GetPending(ctx context.Context, limit int) ([]*Email, error)
 	UpdateStatus(ctx context.Context, id string, status EmailStatus) error
-}
\ No newline at end of file
+}
+
+// TemplateRenderer renders a named template with data into an HTML body
+type TemplateRenderer interface {
+	Render(templateName string, data interface{}) (string, error)
+}
+
+// Middleware wraps an EmailSender to add behaviour such as logging,
+// metrics or filtering without changing the sender itself
+type Middleware func(EmailSender) EmailSender
+
+// Chain wraps sender in middlewares. The first middleware is the outermost,
+// so it sees each email first and each result last.
+func Chain(sender EmailSender, middlewares ...Middleware) EmailSender {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		sender = middlewares[i](sender)
+	}
+	return sender
+}
+
+// SuppressionStore records addresses that must not be mailed again, such
+// as those that unsubscribed
+type SuppressionStore interface {
+	Suppress(ctx context.Context, address string) error
+	IsSuppressed(ctx context.Context, address string) (bool, error)
+}
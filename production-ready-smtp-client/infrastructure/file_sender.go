@@ -0,0 +1,223 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileFormat selects how FileSender lays messages out on disk
+type FileFormat string
+
+const (
+	// FormatEML writes each message as <dir>/<name>.eml
+	FormatEML FileFormat = "eml"
+	// FormatMaildir delivers each message into the Maildir at <dir>
+	FormatMaildir FileFormat = "maildir"
+)
+
+type FileSenderConfig struct {
+	Dir    string
+	Format FileFormat
+	// Hostname is used for the Message-ID and Maildir file names,
+	// os.Hostname() by default
+	Hostname string
+}
+
+// FileSender stores messages on disk instead of delivering them, so the
+// application can run without an SMTP server. Every message gets a JSON
+// sidecar recording the envelope, since Bcc recipients do not appear in
+// the message itself.
+type FileSender struct {
+	config   *FileSenderConfig
+	composer *composer.Composer
+	counter  atomic.Uint64
+}
+
+// fileEnvelope is the JSON sidecar written next to each stored message
+type fileEnvelope struct {
+	ID         string    `json:"id,omitempty"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Cc         []string  `json:"cc,omitempty"`
+	Bcc        []string  `json:"bcc,omitempty"`
+	Recipients []string  `json:"recipients"`
+	Subject    string    `json:"subject"`
+	Message    string    `json:"message"`
+	StoredAt   time.Time `json:"stored_at"`
+}
+
+func NewFileSender(config *FileSenderConfig) (*FileSender, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("output directory is required")
+	}
+
+	cfg := *config
+	if cfg.Format == "" {
+		cfg.Format = FormatEML
+	}
+	if cfg.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine hostname: %w", err)
+		}
+		cfg.Hostname = hostname
+	}
+
+	var dirs []string
+	switch cfg.Format {
+	case FormatEML:
+		dirs = []string{cfg.Dir}
+	case FormatMaildir:
+		dirs = []string{
+			filepath.Join(cfg.Dir, "tmp"),
+			filepath.Join(cfg.Dir, "new"),
+			filepath.Join(cfg.Dir, "cur"),
+			filepath.Join(cfg.Dir, "envelopes"),
+		}
+	default:
+		return nil, fmt.Errorf("unknown file format: %s", cfg.Format)
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	return &FileSender{
+		config:   &cfg,
+		composer: composer.New(composer.Options{Hostname: cfg.Hostname}),
+	}, nil
+}
+
+func (s *FileSender) Send(ctx context.Context, email *domain.Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Composing into the file would report these as a write failure,
+	// which looks retryable
+	if err := email.ValidateHeaders(); err != nil {
+		return retry.Permanent(fmt.Errorf("invalid email: %w", err))
+	}
+	if err := checkAttachments(email); err != nil {
+		return retry.Permanent(err)
+	}
+
+	name, err := s.uniqueName()
+	if err != nil {
+		return fmt.Errorf("failed to generate file name: %w", err)
+	}
+
+	var messagePath, envelopePath string
+	switch s.config.Format {
+	case FormatMaildir:
+		// Write into tmp/ and rename into new/ so readers never see a
+		// partially written message
+		tmpPath := filepath.Join(s.config.Dir, "tmp", name)
+		messagePath = filepath.Join(s.config.Dir, "new", name)
+		envelopePath = filepath.Join(s.config.Dir, "envelopes", name+".json")
+
+		if err := s.writeMessage(tmpPath, email); err != nil {
+			return writeError(err)
+		}
+		if err := os.Rename(tmpPath, messagePath); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to deliver message: %w", err)
+		}
+	default:
+		messagePath = filepath.Join(s.config.Dir, name+".eml")
+		envelopePath = filepath.Join(s.config.Dir, name+".json")
+
+		if err := s.writeMessage(messagePath, email); err != nil {
+			return writeError(err)
+		}
+	}
+
+	envelope := fileEnvelope{
+		ID:         email.ID,
+		From:       email.From.String(),
+		To:         formatAddresses(email.To),
+		Cc:         formatAddresses(email.Cc),
+		Bcc:        formatAddresses(email.Bcc),
+		Recipients: email.Recipients(),
+		Subject:    email.Subject,
+		Message:    filepath.Base(messagePath),
+		StoredAt:   time.Now(),
+	}
+
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to encode envelope: %w", err))
+	}
+	if err := os.WriteFile(envelopePath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write envelope: %w", err)
+	}
+
+	return nil
+}
+
+// writeError classifies a failure of writeMessage
+func writeError(err error) error {
+	err = fmt.Errorf("failed to write message: %w", err)
+	if isPermanentBuildError(err) {
+		return retry.Permanent(err)
+	}
+	return err
+}
+
+// writeMessage composes email straight into a new file at path, removing
+// the file again if composing fails
+func (s *FileSender) writeMessage(path string, email *domain.Email) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	err = s.composer.Compose(w, email)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// uniqueName returns a file name that sorts by delivery time and follows
+// the Maildir convention of time.unique.hostname
+func (s *FileSender) uniqueName() (string, error) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return fmt.Sprintf("%d.M%06dP%dQ%dR%s.%s",
+		now.Unix(), now.Nanosecond()/1000, os.Getpid(), s.counter.Add(1),
+		hex.EncodeToString(random), s.config.Hostname), nil
+}
+
+func (s *FileSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	return sendEach(ctx, emails, s.Send)
+}
+
+func (s *FileSender) Close() error {
+	return nil
+}
@@ -0,0 +1,151 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+func newTestFileSender(t *testing.T, format FileFormat) (*FileSender, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sender, err := NewFileSender(&FileSenderConfig{Dir: dir, Format: format, Hostname: "test.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender, dir
+}
+
+// readEnvelope reads the JSON sidecar at path
+func readEnvelope(t *testing.T, path string) fileEnvelope {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope fileEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatalf("envelope %s: %v", path, err)
+	}
+	return envelope
+}
+
+func checkEnvelope(t *testing.T, envelope fileEnvelope, message string) {
+	t.Helper()
+	if envelope.Message != message {
+		t.Errorf("envelope message = %q, want %q", envelope.Message, message)
+	}
+	if envelope.From != `"Sender" <from@example.com>` {
+		t.Errorf("envelope from = %q", envelope.From)
+	}
+	if strings.Join(envelope.Bcc, ",") != "bcc@example.org" {
+		t.Errorf("envelope bcc = %v", envelope.Bcc)
+	}
+	if strings.Join(envelope.Recipients, ",") != "to@example.org,cc@example.org,bcc@example.org" {
+		t.Errorf("envelope recipients = %v", envelope.Recipients)
+	}
+	if envelope.Subject != "Hello" || envelope.StoredAt.IsZero() {
+		t.Errorf("envelope = %+v", envelope)
+	}
+}
+
+func checkStoredMessage(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\r\nSubject: Hello\r\n") {
+		t.Errorf("message has no Subject line:\n%s", data)
+	}
+	if strings.Contains(string(data), "bcc@example.org") {
+		t.Error("Bcc address written into the message")
+	}
+}
+
+func TestFileSenderEML(t *testing.T) {
+	sender, dir := newTestFileSender(t, FormatEML)
+	if err := sender.Send(context.Background(), sendmailTestEmail(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	envelopes, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(messages) != 1 || len(envelopes) != 1 {
+		t.Fatalf("stored %v and %v, want one message and one envelope", messages, envelopes)
+	}
+	name := strings.TrimSuffix(filepath.Base(messages[0]), ".eml")
+	if envelopes[0] != filepath.Join(dir, name+".json") {
+		t.Errorf("envelope %s does not match message %s", envelopes[0], messages[0])
+	}
+	if !strings.HasSuffix(name, ".test.example.com") {
+		t.Errorf("file name %q does not end in the hostname", name)
+	}
+
+	checkStoredMessage(t, messages[0])
+	checkEnvelope(t, readEnvelope(t, envelopes[0]), filepath.Base(messages[0]))
+}
+
+func TestFileSenderMaildir(t *testing.T) {
+	sender, dir := newTestFileSender(t, FormatMaildir)
+	for i := 0; i < 2; i++ {
+		if err := sender.Send(context.Background(), sendmailTestEmail(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, sub := range []string{"tmp", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil || len(entries) != 0 {
+			t.Errorf("%s/ = %v, %v, want empty", sub, entries, err)
+		}
+	}
+	messages, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("new/ = %v, %v, want two messages", messages, err)
+	}
+	if messages[0].Name() == messages[1].Name() {
+		t.Error("messages share a file name")
+	}
+
+	for _, message := range messages {
+		checkStoredMessage(t, filepath.Join(dir, "new", message.Name()))
+		envelope := readEnvelope(t, filepath.Join(dir, "envelopes", message.Name()+".json"))
+		checkEnvelope(t, envelope, message.Name())
+	}
+}
+
+func TestFileSenderInvalidEmailIsPermanent(t *testing.T) {
+	for _, format := range []FileFormat{FormatEML, FormatMaildir} {
+		sender, dir := newTestFileSender(t, format)
+		email := sendmailTestEmail(t)
+		email.Headers = map[string]string{"X-Campaign": "v\r\nBcc: x@evil.com"}
+
+		err := sender.Send(context.Background(), email)
+		if err == nil || retry.IsRetryable(err) {
+			t.Errorf("%s: Send = %v, want a permanent error", format, err)
+		}
+
+		var files []string
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if len(files) != 0 {
+			t.Errorf("%s: invalid email left %v", format, files)
+		}
+	}
+}
+
+func TestFileSenderUnknownFormat(t *testing.T) {
+	if _, err := NewFileSender(&FileSenderConfig{Dir: t.TempDir(), Format: "mbox"}); err == nil {
+		t.Error("NewFileSender accepted an unknown format")
+	}
+}
@@ -0,0 +1,113 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxErrorBodySize = 64 * 1024
+
+func defaultHTTPClient() *http.Client {
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// providerErrorParser extracts the provider's own error message from a
+// failed response body. It reports temporary when the body identifies a
+// transient failure that the status code alone does not reveal.
+type providerErrorParser func(body []byte) (detail string, temporary bool)
+
+// doProviderRequest executes req and translates the outcome into the retry
+// classification: network failures, 408, 429 and 5xx responses are
+// temporary, as is anything parse flags as temporary. Every other non-2xx
+// response is permanent.
+func doProviderRequest(client *http.Client, provider string, req *http.Request, parse providerErrorParser) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, fmt.Errorf("%s request interrupted: %w", provider, ctxErr)
+		}
+		return nil, retry.Temporary(fmt.Errorf("%s request failed: %w", provider, err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		return nil, retry.Temporary(fmt.Errorf("%s response read failed: %w", provider, err))
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, nil
+	}
+
+	detail, temporary := parse(body)
+	detail = strings.TrimSpace(detail)
+	if detail == "" {
+		detail = strings.TrimSpace(string(body))
+	}
+	sendErr := fmt.Errorf("%s returned %d: %s", provider, resp.StatusCode, detail)
+
+	switch {
+	case temporary,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return nil, retry.Temporary(sendErr)
+	default:
+		return nil, retry.Permanent(sendErr)
+	}
+}
+
+// providerHeaders returns the custom headers of email together with the
+// Message-ID, priority, threading and Sender headers that the MIME builder
+// would otherwise have added. Without the Message-ID each provider makes
+// up its own, and the ID stored for threading and bounce matching is not
+// the one on the wire. Reply-To has a dedicated field in every provider
+// API and is left to the adapters.
+func providerHeaders(email *domain.Email) map[string]string {
+	headers := make(map[string]string, len(email.Headers)+6)
+	for key, value := range email.Headers {
+		headers[key] = value
+	}
+	if email.MessageID != "" {
+		headers["Message-ID"] = composer.FormatMessageIDs(email.MessageID)
+	}
+	if email.Sender.Address != "" {
+		headers["Sender"] = email.Sender.String()
+	}
+	if email.InReplyTo != "" {
+		headers["In-Reply-To"] = composer.FormatMessageIDs(email.InReplyTo)
+	}
+	if len(email.References) > 0 {
+		headers["References"] = composer.FormatMessageIDs(email.References...)
+	}
+	if xPriority, importance := composer.PriorityHeaders(email.Priority); xPriority != "" {
+		headers["X-Priority"] = xPriority
+		headers["Importance"] = importance
+	}
+	return headers
+}
+
+// sendEach sends emails one after another through send. It is used by the
+// transports that gain nothing from sending in parallel: HTTP providers
+// apply their own rate limits and local transports are not network bound.
+func sendEach(ctx context.Context, emails []*domain.Email, send func(context.Context, *domain.Email) error) error {
+	var failures []error
+	for _, email := range emails {
+		if err := send(ctx, email); err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to send %d emails: %v", len(failures), failures[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package infrastructure
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+// providerTestEmail has every field the provider adapters translate
+func providerTestEmail(t *testing.T) *domain.Email {
+	t.Helper()
+	email, err := domain.NewEmailBuilder().
+		From("Sender <from@example.com>").
+		To("Alice <alice@example.org>").
+		Cc("cc@example.org").
+		Bcc("bcc@example.org").
+		ReplyTo("replies@example.com").
+		Subject("Provider test").
+		TextBody("plain body").
+		HTMLBody(`<p>html body <img src="cid:logo"></p>`).
+		Header("X-Campaign", "spring").
+		Attach("report.pdf", "application/pdf", []byte("%PDF-report")).
+		Embed("logo", "logo.png", []byte("png-bytes")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	email.MessageID = testMessageID
+	return email
+}
+
+// testMessageID is the Message-ID of providerTestEmail, which every
+// adapter must send rather than let the provider make up its own
+const testMessageID = "1234.5678@example.com"
+
+// recordedRequest is what a provider test server received
+type recordedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// providerServer answers every request with status and response and
+// records the last request
+func providerServer(t *testing.T, status int, response string) (*httptest.Server, *recordedRequest) {
+	t.Helper()
+	recorded := &recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		*recorded = recordedRequest{method: r.Method, path: r.URL.Path, header: r.Header.Clone(), body: body}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, recorded
+}
+
+// errorCase is a provider response and whether the send error it causes
+// should be retried
+type errorCase struct {
+	name      string
+	status    int
+	response  string
+	retryable bool
+}
+
+// statusErrorCases are the classifications every provider shares
+var statusErrorCases = []errorCase{
+	{"rate limited", http.StatusTooManyRequests, `{}`, true},
+	{"server error", http.StatusInternalServerError, `{}`, true},
+	{"unavailable", http.StatusServiceUnavailable, `{}`, true},
+	{"bad request", http.StatusBadRequest, `{}`, false},
+	{"unauthorized", http.StatusUnauthorized, `{}`, false},
+	{"unprocessable", http.StatusUnprocessableEntity, `{}`, false},
+}
+
+// checkErrorClassification sends through a sender created for each case's
+// server and checks the retry classification of the error
+func checkErrorClassification(t *testing.T, cases []errorCase, newSender func(baseURL string) domain.EmailSender) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _ := providerServer(t, tc.status, tc.response)
+			err := newSender(server.URL).Send(context.Background(), providerTestEmail(t))
+			if err == nil {
+				t.Fatal("Send succeeded")
+			}
+			if got := retry.IsRetryable(err); got != tc.retryable {
+				t.Errorf("retryable = %v, want %v (%v)", got, tc.retryable, err)
+			}
+		})
+	}
+}
+
+// checkInvalidEmailIsPermanent sends emails with a reserved and with a
+// CRLF-injected custom header, which must fail permanently without a
+// request to the provider
+func checkInvalidEmailIsPermanent(t *testing.T, newSender func(baseURL string) domain.EmailSender) {
+	t.Helper()
+	for _, headers := range []map[string]string{
+		{"From": "spoof@evil.com"},
+		{"X-Campaign": "v\r\nBcc: x@evil.com"},
+	} {
+		server, recorded := providerServer(t, http.StatusOK, `{}`)
+		email := providerTestEmail(t)
+		email.Headers = headers
+
+		err := newSender(server.URL).Send(context.Background(), email)
+		if err == nil || retry.IsRetryable(err) {
+			t.Errorf("Send with headers %q = %v, want a permanent error", headers, err)
+		}
+		if recorded.method != "" {
+			t.Errorf("request sent for headers %q", headers)
+		}
+	}
+}
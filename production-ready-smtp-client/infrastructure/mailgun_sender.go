@@ -0,0 +1,179 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+const defaultMailgunURL = "https://api.mailgun.net"
+
+type MailgunConfig struct {
+	APIKey string
+	Domain string
+	// BaseURL overrides the API endpoint, e.g. https://api.eu.mailgun.net
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// MailgunSender delivers email through the Mailgun messages API
+type MailgunSender struct {
+	config *MailgunConfig
+}
+
+func NewMailgunSender(config *MailgunConfig) (*MailgunSender, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("mailgun API key is required")
+	}
+	if config.Domain == "" {
+		return nil, fmt.Errorf("mailgun domain is required")
+	}
+
+	cfg := *config
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultMailgunURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = defaultHTTPClient()
+	}
+
+	return &MailgunSender{config: &cfg}, nil
+}
+
+func (s *MailgunSender) Send(ctx context.Context, email *domain.Email) error {
+	// The API takes custom headers as they are, so reserved names and
+	// line breaks must be caught here
+	if err := email.ValidateHeaders(); err != nil {
+		return retry.Permanent(fmt.Errorf("invalid email: %w", err))
+	}
+
+	body, contentType, err := s.buildForm(email)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to build mailgun form: %w", err))
+	}
+
+	url := fmt.Sprintf("%s/v3/%s/messages", strings.TrimRight(s.config.BaseURL, "/"), s.config.Domain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to create mailgun request: %w", err))
+	}
+	req.SetBasicAuth("api", s.config.APIKey)
+	req.Header.Set("Content-Type", contentType)
+
+	_, err = doProviderRequest(s.config.HTTPClient, "mailgun", req, parseMailgunError)
+	return err
+}
+
+func (s *MailgunSender) buildForm(email *domain.Email) (*bytes.Buffer, string, error) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+
+	fields := [][2]string{
+		{"from", email.From.String()},
+		{"subject", email.Subject},
+	}
+	for _, addr := range email.To {
+		fields = append(fields, [2]string{"to", addr.String()})
+	}
+	for _, addr := range email.Cc {
+		fields = append(fields, [2]string{"cc", addr.String()})
+	}
+	for _, addr := range email.Bcc {
+		fields = append(fields, [2]string{"bcc", addr.String()})
+	}
+	if text := composer.TextBody(email); text != "" {
+		fields = append(fields, [2]string{"text", text})
+	}
+	if email.HTMLBody != "" {
+		fields = append(fields, [2]string{"html", email.HTMLBody})
+	}
+	if len(email.ReplyTo) > 0 {
+		fields = append(fields, [2]string{"h:Reply-To", domain.FormatAddressList(email.ReplyTo)})
+	}
+	for key, value := range providerHeaders(email) {
+		fields = append(fields, [2]string{"h:" + key, value})
+	}
+
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return nil, "", err
+		}
+	}
+
+	attachments, err := providerAttachments(email)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, att := range attachments {
+		if err := writeMailgunFile(form, "attachment", att.Filename, att); err != nil {
+			return nil, "", err
+		}
+	}
+	// Mailgun uses the file name of an inline part as its Content-ID
+	for _, embedded := range email.Embedded {
+		if err := writeMailgunFile(form, "inline", embedded.ContentID, embedded); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return &buf, form.FormDataContentType(), nil
+}
+
+func writeMailgunFile(form *multipart.Writer, field, filename string, att domain.Attachment) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     field,
+		"filename": filename,
+	}))
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	r, err := att.Open()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", field, att.Filename, err)
+	}
+	defer r.Close()
+
+	_, err = io.Copy(part, r)
+	return err
+}
+
+func parseMailgunError(body []byte) (string, bool) {
+	var resp struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return "", false
+	}
+	return resp.Message, false
+}
+
+func (s *MailgunSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	return sendEach(ctx, emails, s.Send)
+}
+
+func (s *MailgunSender) Close() error {
+	return nil
+}
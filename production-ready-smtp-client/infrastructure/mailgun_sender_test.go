@@ -0,0 +1,115 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+func newTestMailgun(t *testing.T, baseURL string) *MailgunSender {
+	t.Helper()
+	sender, err := NewMailgunSender(&MailgunConfig{APIKey: "key", Domain: "mg.example.com", BaseURL: baseURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender
+}
+
+// mailgunFile is a file part of a Mailgun form
+type mailgunFile struct {
+	filename    string
+	contentType string
+	data        string
+}
+
+func parseMailgunForm(t *testing.T, recorded *recordedRequest) (map[string][]string, map[string][]mailgunFile) {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(recorded.header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type: %v", err)
+	}
+
+	fields := make(map[string][]string)
+	files := make(map[string][]mailgunFile)
+	reader := multipart.NewReader(bytes.NewReader(recorded.body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return fields, files
+		}
+		if err != nil {
+			t.Fatalf("reading form: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FileName() == "" {
+			fields[part.FormName()] = append(fields[part.FormName()], string(data))
+			continue
+		}
+		files[part.FormName()] = append(files[part.FormName()], mailgunFile{
+			filename:    part.FileName(),
+			contentType: part.Header.Get("Content-Type"),
+			data:        string(data),
+		})
+	}
+}
+
+func TestMailgunRequest(t *testing.T) {
+	server, recorded := providerServer(t, http.StatusOK, `{"message":"Queued"}`)
+	if err := newTestMailgun(t, server.URL).Send(context.Background(), providerTestEmail(t)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if recorded.path != "/v3/mg.example.com/messages" {
+		t.Errorf("path = %q", recorded.path)
+	}
+	if user, pass, ok := (&http.Request{Header: recorded.header}).BasicAuth(); !ok || user != "api" || pass != "key" {
+		t.Errorf("basic auth = %q, %q", user, pass)
+	}
+
+	fields, files := parseMailgunForm(t, recorded)
+	wantFields := map[string]string{
+		"from":         `"Sender" <from@example.com>`,
+		"to":           `"Alice" <alice@example.org>`,
+		"cc":           "cc@example.org",
+		"bcc":          "bcc@example.org",
+		"subject":      "Provider test",
+		"text":         "plain body",
+		"h:Reply-To":   "replies@example.com",
+		"h:X-Campaign": "spring",
+		"h:Message-ID": "<" + testMessageID + ">",
+	}
+	for name, want := range wantFields {
+		if got := fields[name]; len(got) != 1 || got[0] != want {
+			t.Errorf("field %s = %q, want %q", name, got, want)
+		}
+	}
+
+	if got := files["attachment"]; len(got) != 1 || got[0] != (mailgunFile{"report.pdf", "application/pdf", "%PDF-report"}) {
+		t.Errorf("attachment = %+v", got)
+	}
+	// Mailgun takes the Content-ID of an inline part from its file name
+	if got := files["inline"]; len(got) != 1 || got[0] != (mailgunFile{"logo", "image/png", "png-bytes"}) {
+		t.Errorf("inline = %+v", got)
+	}
+}
+
+func TestMailgunErrors(t *testing.T) {
+	checkErrorClassification(t, statusErrorCases, func(baseURL string) domain.EmailSender {
+		return newTestMailgun(t, baseURL)
+	})
+}
+
+func TestMailgunInvalidEmailIsPermanent(t *testing.T) {
+	checkInvalidEmailIsPermanent(t, func(baseURL string) domain.EmailSender {
+		return newTestMailgun(t, baseURL)
+	})
+}
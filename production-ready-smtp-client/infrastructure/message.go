@@ -0,0 +1,86 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"time"
+)
+
+// BuildMessage renders email as a MIME message. It is shared by every
+// transport that hands a complete message to something else (an SMTP DATA
+// command, a sendmail pipe, a file), so they all produce identical output.
+// hostname is used as the right-hand side of the Message-ID.
+func BuildMessage(email *domain.Email, hostname string) ([]byte, error) {
+	return composer.New(composer.Options{Hostname: hostname}).Bytes(email)
+}
+
+// formatAddresses formats each address for a header field or a provider
+// API that accepts display names
+func formatAddresses(addrs []domain.Address) []string {
+	if len(addrs) == 0 {
+		return nil
+	}
+	formatted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		formatted[i] = addr.String()
+	}
+	return formatted
+}
+
+// providerAttachments returns the attachments of email for a provider
+// API, with attached emails composed, and the invitation of a calendar
+// email. The APIs can't add a text/calendar alternative, so the event goes
+// only as an attachment, with the method that lets clients offer to accept
+// it.
+func providerAttachments(email *domain.Email) ([]domain.Attachment, error) {
+	attachments := append([]domain.Attachment(nil), email.Attachments...)
+	for i, att := range attachments {
+		if att.Message == nil {
+			continue
+		}
+		raw, err := composer.New(composer.Options{}).Bytes(att.Message)
+		if err != nil {
+			return nil, fmt.Errorf("attached message %s: %w", att.Filename, err)
+		}
+		attachments[i].Data = raw
+		attachments[i].Message = nil
+	}
+	if email.Calendar != nil {
+		invite := composer.CalendarAttachment([]byte(email.Calendar.ICS(time.Now())))
+		invite.ContentType = "text/calendar; charset=UTF-8; method=" + string(email.Calendar.Method)
+		attachments = append(attachments, invite)
+	}
+	return attachments, nil
+}
+
+// checkAttachments verifies that file-backed attachments can be opened
+// and that streamed ones were not read by an earlier send, so a streaming
+// transport does not discover either halfway through a message
+func checkAttachments(email *domain.Email) error {
+	for _, att := range append(append([]domain.Attachment{}, email.Attachments...), email.Embedded...) {
+		if att.Data != nil || (att.Reader == nil && att.Path == "") {
+			continue
+		}
+		// Opening a stream does not read from it
+		r, err := att.Open()
+		if err != nil {
+			return fmt.Errorf("attachment %s: %w", att.Filename, err)
+		}
+		r.Close()
+	}
+	return nil
+}
+
+// isPermanentBuildError reports whether composing a message failed
+// because of the email itself, which no retry fixes, rather than because
+// of the writer it was composed into. Streamed attachments over the
+// attachment policy limits are only found while they are read.
+func isPermanentBuildError(err error) bool {
+	return errors.Is(err, composer.ErrLineTooLong) ||
+		errors.Is(err, composer.ErrNeeds8BitMIME) ||
+		errors.Is(err, domain.ErrStreamConsumed) ||
+		errors.Is(err, domain.ErrAttachmentTooLarge) ||
+		errors.Is(err, domain.ErrAttachmentsTooLarge)
+}
@@ -0,0 +1,95 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+func TestIsPermanentBuildError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{composer.ErrLineTooLong, true},
+		{domain.ErrStreamConsumed, true},
+		{fmt.Errorf("failed to read a.csv: %w", domain.ErrAttachmentTooLarge), true},
+		{fmt.Errorf("failed to read a.csv: %w", domain.ErrAttachmentsTooLarge), true},
+		{errors.New("broken pipe"), false},
+	}
+	for _, tt := range tests {
+		if got := isPermanentBuildError(tt.err); got != tt.want {
+			t.Errorf("isPermanentBuildError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// oversizedStreamEmail has a streamed attachment that the policy only
+// rejects once it is read during the send
+func oversizedStreamEmail(t *testing.T, policy domain.AttachmentPolicy) *domain.Email {
+	t.Helper()
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Export").
+		TextBody("body").
+		Attach("small.txt", "text/plain", []byte("0123456789")).
+		AttachStream("export.csv", "text/csv", strings.NewReader(strings.Repeat("a,b\n", 100))).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Enforce(email); err != nil {
+		t.Fatalf("Enforce rejected the email before reading: %v", err)
+	}
+	return email
+}
+
+func TestOversizedStreamIsPermanent(t *testing.T) {
+	policies := []struct {
+		policy domain.AttachmentPolicy
+		want   error
+	}{
+		{domain.AttachmentPolicy{MaxSize: 100}, domain.ErrAttachmentTooLarge},
+		{domain.AttachmentPolicy{MaxTotalSize: 200}, domain.ErrAttachmentsTooLarge},
+	}
+	transports := []struct {
+		name      string
+		newSender func(t *testing.T) domain.EmailSender
+	}{
+		{"sendmail", func(t *testing.T) domain.EmailSender {
+			sender, _ := newTestSendmail(t)
+			return sender
+		}},
+		{"ses", func(t *testing.T) domain.EmailSender {
+			server, _ := providerServer(t, http.StatusOK, `{"MessageId":"id"}`)
+			return newTestSES(t, server.URL)
+		}},
+		{"file", func(t *testing.T) domain.EmailSender {
+			sender, err := NewFileSender(&FileSenderConfig{Dir: t.TempDir(), Hostname: "test.example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sender
+		}},
+	}
+
+	for _, transport := range transports {
+		for _, p := range policies {
+			t.Run(fmt.Sprintf("%s %v", transport.name, p.want), func(t *testing.T) {
+				email := oversizedStreamEmail(t, p.policy)
+				err := transport.newSender(t).Send(context.Background(), email)
+				if !errors.Is(err, p.want) || retry.IsRetryable(err) {
+					t.Errorf("Send = %v, want a permanent %v", err, p.want)
+				}
+			})
+		}
+	}
+}
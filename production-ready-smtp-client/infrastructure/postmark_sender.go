@@ -0,0 +1,181 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const defaultPostmarkURL = "https://api.postmarkapp.com"
+
+type PostmarkConfig struct {
+	ServerToken string
+	// MessageStream selects a Postmark message stream, "outbound" if empty
+	MessageStream string
+	// BaseURL overrides the API endpoint, mainly for tests
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// PostmarkSender delivers email through the Postmark email API
+type PostmarkSender struct {
+	config *PostmarkConfig
+}
+
+type postmarkHeader struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type postmarkAttachment struct {
+	Name        string `json:"Name"`
+	Content     string `json:"Content"`
+	ContentType string `json:"ContentType"`
+	ContentID   string `json:"ContentID,omitempty"`
+}
+
+type postmarkMessage struct {
+	From          string               `json:"From"`
+	To            string               `json:"To"`
+	Cc            string               `json:"Cc,omitempty"`
+	Bcc           string               `json:"Bcc,omitempty"`
+	ReplyTo       string               `json:"ReplyTo,omitempty"`
+	Subject       string               `json:"Subject"`
+	TextBody      string               `json:"TextBody,omitempty"`
+	HtmlBody      string               `json:"HtmlBody,omitempty"`
+	Headers       []postmarkHeader     `json:"Headers,omitempty"`
+	Attachments   []postmarkAttachment `json:"Attachments,omitempty"`
+	MessageStream string               `json:"MessageStream,omitempty"`
+}
+
+// Postmark error codes for failures that clear up on their own, see
+// https://postmarkapp.com/developer/api/overview#error-codes
+var postmarkTemporaryErrorCodes = map[int]bool{
+	100: true, // Maintenance
+	429: true, // Rate limit exceeded
+}
+
+func NewPostmarkSender(config *PostmarkConfig) (*PostmarkSender, error) {
+	if config.ServerToken == "" {
+		return nil, fmt.Errorf("postmark server token is required")
+	}
+
+	cfg := *config
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultPostmarkURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = defaultHTTPClient()
+	}
+
+	return &PostmarkSender{config: &cfg}, nil
+}
+
+func (s *PostmarkSender) Send(ctx context.Context, email *domain.Email) error {
+	// The API takes custom headers as they are, so reserved names and
+	// line breaks must be caught here
+	if err := email.ValidateHeaders(); err != nil {
+		return retry.Permanent(fmt.Errorf("invalid email: %w", err))
+	}
+
+	msg, err := s.buildPayload(email)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to build postmark payload: %w", err))
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to encode postmark payload: %w", err))
+	}
+
+	url := strings.TrimRight(s.config.BaseURL, "/") + "/email"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to create postmark request: %w", err))
+	}
+	req.Header.Set("X-Postmark-Server-Token", s.config.ServerToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	_, err = doProviderRequest(s.config.HTTPClient, "postmark", req, parsePostmarkError)
+	return err
+}
+
+func (s *PostmarkSender) buildPayload(email *domain.Email) (postmarkMessage, error) {
+	msg := postmarkMessage{
+		From:          email.From.String(),
+		To:            domain.FormatAddressList(email.To),
+		Cc:            domain.FormatAddressList(email.Cc),
+		Bcc:           domain.FormatAddressList(email.Bcc),
+		ReplyTo:       domain.FormatAddressList(email.ReplyTo),
+		Subject:       email.Subject,
+		TextBody:      composer.TextBody(email),
+		HtmlBody:      email.HTMLBody,
+		MessageStream: s.config.MessageStream,
+	}
+
+	headers := providerHeaders(email)
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		msg.Headers = append(msg.Headers, postmarkHeader{Name: name, Value: headers[name]})
+	}
+
+	attachments, err := providerAttachments(email)
+	if err != nil {
+		return msg, err
+	}
+	for _, att := range append(attachments, email.Embedded...) {
+		contentType := att.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		data, err := att.ReadAll()
+		if err != nil {
+			return msg, fmt.Errorf("attachment %s: %w", att.Filename, err)
+		}
+		attachment := postmarkAttachment{
+			Name:        att.Filename,
+			Content:     base64.StdEncoding.EncodeToString(data),
+			ContentType: contentType,
+		}
+		if att.ContentID != "" {
+			attachment.ContentID = "cid:" + att.ContentID
+		}
+		msg.Attachments = append(msg.Attachments, attachment)
+	}
+
+	return msg, nil
+}
+
+// parsePostmarkError reads the ErrorCode from the body, since Postmark
+// reports most failures as 422 and only the code tells them apart
+func parsePostmarkError(body []byte) (string, bool) {
+	var resp struct {
+		ErrorCode int    `json:"ErrorCode"`
+		Message   string `json:"Message"`
+	}
+	if json.Unmarshal(body, &resp) != nil || resp.Message == "" {
+		return "", false
+	}
+	return fmt.Sprintf("error code %d: %s", resp.ErrorCode, resp.Message), postmarkTemporaryErrorCodes[resp.ErrorCode]
+}
+
+func (s *PostmarkSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	return sendEach(ctx, emails, s.Send)
+}
+
+func (s *PostmarkSender) Close() error {
+	return nil
+}
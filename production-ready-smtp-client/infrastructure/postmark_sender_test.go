@@ -0,0 +1,100 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+func newTestPostmark(t *testing.T, baseURL string) *PostmarkSender {
+	t.Helper()
+	sender, err := NewPostmarkSender(&PostmarkConfig{ServerToken: "token", BaseURL: baseURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender
+}
+
+func TestPostmarkRequest(t *testing.T) {
+	server, recorded := providerServer(t, http.StatusOK, `{"ErrorCode":0,"Message":"OK"}`)
+	if err := newTestPostmark(t, server.URL).Send(context.Background(), providerTestEmail(t)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if recorded.path != "/email" {
+		t.Errorf("path = %q", recorded.path)
+	}
+	if got := recorded.header.Get("X-Postmark-Server-Token"); got != "token" {
+		t.Errorf("X-Postmark-Server-Token = %q", got)
+	}
+
+	var msg postmarkMessage
+	if err := json.Unmarshal(recorded.body, &msg); err != nil {
+		t.Fatalf("decoding body: %v\n%s", err, recorded.body)
+	}
+	if msg.To != `"Alice" <alice@example.org>` || msg.Cc != "cc@example.org" || msg.Bcc != "bcc@example.org" {
+		t.Errorf("to = %q, cc = %q, bcc = %q", msg.To, msg.Cc, msg.Bcc)
+	}
+	if msg.ReplyTo != "replies@example.com" {
+		t.Errorf("reply to = %q", msg.ReplyTo)
+	}
+	if !hasPostmarkHeader(msg.Headers, "X-Campaign", "spring") ||
+		!hasPostmarkHeader(msg.Headers, "Message-ID", "<"+testMessageID+">") {
+		t.Errorf("headers = %+v", msg.Headers)
+	}
+
+	want := []postmarkAttachment{
+		{
+			Name:        "report.pdf",
+			Content:     base64.StdEncoding.EncodeToString([]byte("%PDF-report")),
+			ContentType: "application/pdf",
+		},
+		{
+			Name:        "logo.png",
+			Content:     base64.StdEncoding.EncodeToString([]byte("png-bytes")),
+			ContentType: "image/png",
+			ContentID:   "cid:logo",
+		},
+	}
+	if len(msg.Attachments) != len(want) {
+		t.Fatalf("attachments = %+v", msg.Attachments)
+	}
+	for i := range want {
+		if msg.Attachments[i] != want[i] {
+			t.Errorf("attachment %d = %+v, want %+v", i, msg.Attachments[i], want[i])
+		}
+	}
+}
+
+func hasPostmarkHeader(headers []postmarkHeader, name, value string) bool {
+	for _, h := range headers {
+		if h.Name == name && h.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPostmarkErrors(t *testing.T) {
+	cases := append([]errorCase{
+		// Postmark reports most failures as 422 and tells them apart by
+		// ErrorCode
+		{"maintenance", http.StatusUnprocessableEntity, `{"ErrorCode":100,"Message":"Maintenance"}`, true},
+		{"rate limit code", http.StatusUnprocessableEntity, `{"ErrorCode":429,"Message":"Rate limit exceeded"}`, true},
+		{"inactive recipient", http.StatusUnprocessableEntity, `{"ErrorCode":406,"Message":"Inactive recipient"}`, false},
+		{"invalid email", http.StatusUnprocessableEntity, `{"ErrorCode":300,"Message":"Invalid email request"}`, false},
+	}, statusErrorCases...)
+	checkErrorClassification(t, cases, func(baseURL string) domain.EmailSender {
+		return newTestPostmark(t, baseURL)
+	})
+}
+
+func TestPostmarkInvalidEmailIsPermanent(t *testing.T) {
+	checkInvalidEmailIsPermanent(t, func(baseURL string) domain.EmailSender {
+		return newTestPostmark(t, baseURL)
+	})
+}
@@ -0,0 +1,251 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"log"
+	"strings"
+)
+
+// Headers the Router reads to classify a message. They are set through
+// EmailBuilder.Header like any other custom header, and removed before the
+// email is handed to its route so that internal names never reach
+// recipients.
+const (
+	CategoryHeader = "X-Mail-Category"
+	TenantHeader   = "X-Tenant"
+)
+
+// DefaultRouteName is reported when no rule matched
+const DefaultRouteName = "default"
+
+// Route sends the emails it matches through Sender. Every non-empty
+// criterion must match; within a criterion any listed value matches.
+type Route struct {
+	Name   string
+	Sender domain.EmailSender
+
+	// SenderDomains matches the domain of the From address
+	SenderDomains []string
+	// RecipientDomains matches when every To, Cc and Bcc recipient is in
+	// one of the domains, so a mixed message never reaches a transport
+	// that can only deliver some of its recipients
+	RecipientDomains []string
+	// Categories matches the value of the CategoryHeader header
+	Categories []string
+	// Priorities matches the email priority
+	Priorities []domain.Priority
+	// Tenants matches the value of the TenantHeader header
+	Tenants []string
+}
+
+// Router is a domain.EmailSender that picks a backend for each email from
+// an ordered list of routes, falling back to a default sender
+type Router struct {
+	routes   []Route
+	fallback domain.EmailSender
+
+	// OnRoute, if set, is called with the name of the route chosen for
+	// each email before it is sent
+	OnRoute func(email *domain.Email, route string)
+}
+
+func NewRouter(fallback domain.EmailSender, routes ...Route) (*Router, error) {
+	if fallback == nil {
+		return nil, fmt.Errorf("default sender is required")
+	}
+
+	routes = append([]Route(nil), routes...)
+	for i, route := range routes {
+		if route.Sender == nil {
+			return nil, fmt.Errorf("route %d (%s) has no sender", i, route.Name)
+		}
+		if route.Name == "" {
+			routes[i].Name = fmt.Sprintf("route-%d", i)
+		}
+	}
+
+	return &Router{
+		routes:   routes,
+		fallback: fallback,
+	}, nil
+}
+
+// Resolve returns the name of the route and the sender that would be used
+// for email. The first matching route wins.
+func (r *Router) Resolve(email *domain.Email) (string, domain.EmailSender) {
+	for _, route := range r.routes {
+		if route.matches(email) {
+			return route.Name, route.Sender
+		}
+	}
+	return DefaultRouteName, r.fallback
+}
+
+func (r *Router) Send(ctx context.Context, email *domain.Email) error {
+	name, sender := r.Resolve(email)
+	r.report(email, name)
+
+	if err := sender.Send(ctx, withoutRoutingHeaders(email)); err != nil {
+		return fmt.Errorf("route %s: %w", name, err)
+	}
+	return nil
+}
+
+// SendBulk groups emails by route and hands each group to its backend's
+// SendBulk, so backends keep their own batching behaviour
+func (r *Router) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	var order []string
+	groups := make(map[string][]*domain.Email)
+	senders := make(map[string]domain.EmailSender)
+
+	for _, email := range emails {
+		name, sender := r.Resolve(email)
+		r.report(email, name)
+
+		if _, ok := groups[name]; !ok {
+			order = append(order, name)
+			senders[name] = sender
+		}
+		groups[name] = append(groups[name], withoutRoutingHeaders(email))
+	}
+
+	var failures []error
+	for _, name := range order {
+		if err := senders[name].SendBulk(ctx, groups[name]); err != nil {
+			failures = append(failures, fmt.Errorf("route %s: %w", name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed on %d routes: %v", len(failures), failures[0])
+	}
+
+	return nil
+}
+
+// Close closes every backend once, even if several routes share it
+func (r *Router) Close() error {
+	seen := make(map[domain.EmailSender]bool)
+	var firstErr error
+
+	for _, sender := range append(r.senders(), r.fallback) {
+		if seen[sender] {
+			continue
+		}
+		seen[sender] = true
+
+		if err := sender.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (r *Router) senders() []domain.EmailSender {
+	senders := make([]domain.EmailSender, len(r.routes))
+	for i, route := range r.routes {
+		senders[i] = route.Sender
+	}
+	return senders
+}
+
+func (r *Router) report(email *domain.Email, name string) {
+	if r.OnRoute != nil {
+		r.OnRoute(email, name)
+		return
+	}
+	log.Printf("Routing email to %v via %s", email.To, name)
+}
+
+func (route Route) matches(email *domain.Email) bool {
+	if len(route.SenderDomains) > 0 && !containsFold(route.SenderDomains, email.From.Domain()) {
+		return false
+	}
+
+	if len(route.RecipientDomains) > 0 {
+		recipients := append(append(append([]domain.Address{}, email.To...), email.Cc...), email.Bcc...)
+		if len(recipients) == 0 {
+			return false
+		}
+		for _, rcpt := range recipients {
+			if !containsFold(route.RecipientDomains, rcpt.Domain()) {
+				return false
+			}
+		}
+	}
+
+	if len(route.Categories) > 0 && !containsFold(route.Categories, headerValue(email, CategoryHeader)) {
+		return false
+	}
+
+	if len(route.Tenants) > 0 && !containsFold(route.Tenants, headerValue(email, TenantHeader)) {
+		return false
+	}
+
+	if len(route.Priorities) > 0 {
+		found := false
+		for _, p := range route.Priorities {
+			if p == email.Priority {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withoutRoutingHeaders returns email without CategoryHeader and
+// TenantHeader. The caller's email is left untouched.
+func withoutRoutingHeaders(email *domain.Email) *domain.Email {
+	var routing []string
+	for key := range email.Headers {
+		if strings.EqualFold(key, CategoryHeader) || strings.EqualFold(key, TenantHeader) {
+			routing = append(routing, key)
+		}
+	}
+	if len(routing) == 0 {
+		return email
+	}
+
+	stripped := *email
+	stripped.Headers = make(map[string]string, len(email.Headers))
+	for key, value := range email.Headers {
+		stripped.Headers[key] = value
+	}
+	for _, key := range routing {
+		delete(stripped.Headers, key)
+	}
+	return &stripped
+}
+
+// headerValue looks up a custom header case-insensitively
+func headerValue(email *domain.Email, key string) string {
+	if value, ok := email.Headers[key]; ok {
+		return value
+	}
+	for k, v := range email.Headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func containsFold(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
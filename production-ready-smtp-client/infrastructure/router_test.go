@@ -0,0 +1,169 @@
+package infrastructure_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/infrastructure"
+	"go-smtp/production-ready-smtp-client/pkg/emailtest"
+)
+
+func TestRouterRemovesRoutingHeaders(t *testing.T) {
+	fallback, marketing := emailtest.NewRecorder(), emailtest.NewRecorder()
+	router, err := infrastructure.NewRouter(fallback, infrastructure.Route{Name: "marketing", Sender: marketing, Categories: []string{"newsletter"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.OnRoute = func(*domain.Email, string) {}
+
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("News").
+		TextBody("body").
+		Header(infrastructure.CategoryHeader, "newsletter").
+		Header("x-tenant", "acme").
+		Header("X-Campaign", "spring").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := router.Send(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.SendBulk(context.Background(), []*domain.Email{email}); err != nil {
+		t.Fatal(err)
+	}
+
+	fallback.AssertCount(t, 0)
+	marketing.AssertCount(t, 2)
+	for _, sent := range marketing.Messages() {
+		if headers := sent.Email.Headers; len(headers) != 1 || headers["X-Campaign"] != "spring" {
+			t.Errorf("sent headers = %v, want only X-Campaign", headers)
+		}
+	}
+	if email.Headers[infrastructure.CategoryHeader] != "newsletter" || email.Headers["x-tenant"] != "acme" {
+		t.Errorf("caller's headers changed: %v", email.Headers)
+	}
+}
+
+// routeTestEmail builds an email from from to the comma-separated to
+func routeTestEmail(t *testing.T, from, to string, headers map[string]string, priority domain.Priority) *domain.Email {
+	t.Helper()
+	builder := domain.NewEmailBuilder().
+		From(from).
+		To(strings.Split(to, ",")...).
+		Subject("Routed").
+		TextBody("body").
+		Priority(priority)
+	for key, value := range headers {
+		builder.Header(key, value)
+	}
+	email, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return email
+}
+
+func TestRouterResolve(t *testing.T) {
+	sender := emailtest.NewRecorder()
+	router, err := infrastructure.NewRouter(sender,
+		infrastructure.Route{Name: "urgent", Sender: sender, Priorities: []domain.Priority{domain.PriorityHigh}},
+		infrastructure.Route{Name: "internal", Sender: sender, RecipientDomains: []string{"corp.example", "staff.corp.example"}},
+		infrastructure.Route{Name: "newsletter", Sender: sender, SenderDomains: []string{"news.example.com"}, Categories: []string{"newsletter"}},
+		infrastructure.Route{Name: "acme", Sender: sender, Tenants: []string{"acme", "globex"}},
+		infrastructure.Route{Sender: sender, SenderDomains: []string{"billing.example.com"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	normal := domain.PriorityNormal
+	category := map[string]string{infrastructure.CategoryHeader: "newsletter"}
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		headers  map[string]string
+		priority domain.Priority
+		want     string
+	}{
+		{"no rule", "app@example.com", "user@example.org", nil, normal, infrastructure.DefaultRouteName},
+		{"priority", "app@example.com", "user@example.org", nil, domain.PriorityHigh, "urgent"},
+		{"low priority", "app@example.com", "user@example.org", nil, domain.PriorityLow, infrastructure.DefaultRouteName},
+		{"recipient domain", "app@example.com", "a@corp.example,b@STAFF.corp.example", nil, normal, "internal"},
+		{"mixed recipient domains", "app@example.com", "a@corp.example,b@example.org", nil, normal, infrastructure.DefaultRouteName},
+		{"subdomain is another domain", "app@example.com", "a@sub.corp.example", nil, normal, infrastructure.DefaultRouteName},
+		{"sender domain and category", "news@NEWS.example.com", "user@example.org", category, normal, "newsletter"},
+		{"sender domain without category", "news@news.example.com", "user@example.org", nil, normal, infrastructure.DefaultRouteName},
+		{"category from another domain", "app@example.com", "user@example.org", category, normal, infrastructure.DefaultRouteName},
+		{"category header in any case", "news@news.example.com", "user@example.org", map[string]string{"x-mail-category": "NEWSLETTER"}, normal, "newsletter"},
+		{"tenant", "app@example.com", "user@example.org", map[string]string{infrastructure.TenantHeader: "globex"}, normal, "acme"},
+		{"other tenant", "app@example.com", "user@example.org", map[string]string{infrastructure.TenantHeader: "initech"}, normal, infrastructure.DefaultRouteName},
+		{"unnamed route", "invoices@billing.example.com", "user@example.org", nil, normal, "route-4"},
+		{"first matching rule wins", "app@example.com", "a@corp.example", map[string]string{infrastructure.TenantHeader: "acme"}, domain.PriorityHigh, "urgent"},
+		{"earlier rule over later", "app@example.com", "a@corp.example", map[string]string{infrastructure.TenantHeader: "acme"}, normal, "internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := routeTestEmail(t, tt.from, tt.to, tt.headers, tt.priority)
+			if got, _ := router.Resolve(email); got != tt.want {
+				t.Errorf("Resolve = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterSendsThroughRoute(t *testing.T) {
+	fallback, internal := emailtest.NewRecorder(), emailtest.NewRecorder()
+	router, err := infrastructure.NewRouter(fallback,
+		infrastructure.Route{Name: "internal", Sender: internal, RecipientDomains: []string{"corp.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routes []string
+	router.OnRoute = func(_ *domain.Email, route string) { routes = append(routes, route) }
+
+	inside := routeTestEmail(t, "app@example.com", "a@corp.example", nil, domain.PriorityNormal)
+	outside := routeTestEmail(t, "app@example.com", "b@example.org", nil, domain.PriorityNormal)
+	if err := router.Send(context.Background(), inside); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.SendBulk(context.Background(), []*domain.Email{outside, inside, outside}); err != nil {
+		t.Fatal(err)
+	}
+
+	internal.AssertCount(t, 2)
+	fallback.AssertCount(t, 2)
+	fallback.AssertNotSent(t, emailtest.SentTo("a@corp.example"))
+	if got := strings.Join(routes, ","); got != "internal,default,internal,default" {
+		t.Errorf("routes = %s", got)
+	}
+}
+
+func TestRouterReportsRouteInErrors(t *testing.T) {
+	fallback := emailtest.NewRecorder().FailNext(1, 550)
+	router, err := infrastructure.NewRouter(fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.OnRoute = func(*domain.Email, string) {}
+
+	err = router.Send(context.Background(), routeTestEmail(t, "app@example.com", "b@example.org", nil, domain.PriorityNormal))
+	if err == nil || !strings.HasPrefix(err.Error(), "route default: ") {
+		t.Errorf("Send = %v, want an error naming the route", err)
+	}
+}
+
+func TestNewRouterRequiresSenders(t *testing.T) {
+	if _, err := infrastructure.NewRouter(nil); err == nil {
+		t.Error("NewRouter accepted a nil default sender")
+	}
+	if _, err := infrastructure.NewRouter(emailtest.NewRecorder(), infrastructure.Route{Name: "empty"}); err == nil {
+		t.Error("NewRouter accepted a route without sender")
+	}
+}
@@ -0,0 +1,206 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"net/http"
+	"strings"
+)
+
+const defaultSendGridURL = "https://api.sendgrid.com"
+
+type SendGridConfig struct {
+	APIKey string
+	// BaseURL overrides the API endpoint, mainly for tests
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// SendGridSender delivers email through the SendGrid v3 Mail Send API
+type SendGridSender struct {
+	config *SendGridConfig
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyToList      []sendGridAddress         `json:"reply_to_list,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+func NewSendGridSender(config *SendGridConfig) (*SendGridSender, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("sendgrid API key is required")
+	}
+
+	cfg := *config
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultSendGridURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = defaultHTTPClient()
+	}
+
+	return &SendGridSender{config: &cfg}, nil
+}
+
+func (s *SendGridSender) Send(ctx context.Context, email *domain.Email) error {
+	// The API takes custom headers as they are, so reserved names and
+	// line breaks must be caught here
+	if err := email.ValidateHeaders(); err != nil {
+		return retry.Permanent(fmt.Errorf("invalid email: %w", err))
+	}
+
+	msg, err := s.buildPayload(email)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to build sendgrid payload: %w", err))
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to encode sendgrid payload: %w", err))
+	}
+
+	url := strings.TrimRight(s.config.BaseURL, "/") + "/v3/mail/send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to create sendgrid request: %w", err))
+	}
+	req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = doProviderRequest(s.config.HTTPClient, "sendgrid", req, parseSendGridError)
+	return err
+}
+
+func (s *SendGridSender) buildPayload(email *domain.Email) (sendGridMessage, error) {
+	msg := sendGridMessage{
+		Personalizations: []sendGridPersonalization{{
+			To:  sendGridAddresses(email.To),
+			Cc:  sendGridAddresses(email.Cc),
+			Bcc: sendGridAddresses(email.Bcc),
+		}},
+		From:        sendGridAddress{Email: email.From.Address, Name: email.From.Name},
+		ReplyToList: sendGridAddresses(email.ReplyTo),
+		Subject:     email.Subject,
+	}
+
+	// SendGrid requires text/plain to come before text/html
+	if text := composer.TextBody(email); text != "" {
+		msg.Content = append(msg.Content, sendGridContent{Type: "text/plain", Value: text})
+	}
+	if email.HTMLBody != "" {
+		msg.Content = append(msg.Content, sendGridContent{Type: "text/html", Value: email.HTMLBody})
+	}
+
+	attachments, err := providerAttachments(email)
+	if err != nil {
+		return msg, err
+	}
+	for _, att := range attachments {
+		data, err := att.ReadAll()
+		if err != nil {
+			return msg, fmt.Errorf("attachment %s: %w", att.Filename, err)
+		}
+		msg.Attachments = append(msg.Attachments, sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(data),
+			Type:        att.ContentType,
+			Filename:    att.Filename,
+			Disposition: "attachment",
+		})
+	}
+
+	for _, embedded := range email.Embedded {
+		data, err := embedded.ReadAll()
+		if err != nil {
+			return msg, fmt.Errorf("embedded %s: %w", embedded.Filename, err)
+		}
+		msg.Attachments = append(msg.Attachments, sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(data),
+			Type:        embedded.ContentType,
+			Filename:    embedded.Filename,
+			Disposition: "inline",
+			ContentID:   embedded.ContentID,
+		})
+	}
+
+	if headers := providerHeaders(email); len(headers) > 0 {
+		msg.Headers = headers
+	}
+
+	return msg, nil
+}
+
+func sendGridAddresses(addrs []domain.Address) []sendGridAddress {
+	if len(addrs) == 0 {
+		return nil
+	}
+	out := make([]sendGridAddress, len(addrs))
+	for i, addr := range addrs {
+		out[i] = sendGridAddress{Email: addr.Address, Name: addr.Name}
+	}
+	return out
+}
+
+func parseSendGridError(body []byte) (string, bool) {
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+			Field   string `json:"field"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return "", false
+	}
+
+	var messages []string
+	for _, e := range resp.Errors {
+		if e.Field != "" {
+			messages = append(messages, e.Field+": "+e.Message)
+		} else {
+			messages = append(messages, e.Message)
+		}
+	}
+	return strings.Join(messages, "; "), false
+}
+
+func (s *SendGridSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	return sendEach(ctx, emails, s.Send)
+}
+
+func (s *SendGridSender) Close() error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+func newTestSendGrid(t *testing.T, baseURL string) *SendGridSender {
+	t.Helper()
+	sender, err := NewSendGridSender(&SendGridConfig{APIKey: "key", BaseURL: baseURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender
+}
+
+func TestSendGridRequest(t *testing.T) {
+	server, recorded := providerServer(t, http.StatusAccepted, "")
+	if err := newTestSendGrid(t, server.URL).Send(context.Background(), providerTestEmail(t)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if recorded.path != "/v3/mail/send" {
+		t.Errorf("path = %q", recorded.path)
+	}
+	if got := recorded.header.Get("Authorization"); got != "Bearer key" {
+		t.Errorf("Authorization = %q", got)
+	}
+
+	var msg sendGridMessage
+	if err := json.Unmarshal(recorded.body, &msg); err != nil {
+		t.Fatalf("decoding body: %v\n%s", err, recorded.body)
+	}
+	if len(msg.Personalizations) != 1 {
+		t.Fatalf("personalizations = %+v", msg.Personalizations)
+	}
+	p := msg.Personalizations[0]
+	if len(p.To) != 1 || p.To[0] != (sendGridAddress{Email: "alice@example.org", Name: "Alice"}) {
+		t.Errorf("to = %+v", p.To)
+	}
+	if len(p.Cc) != 1 || p.Cc[0].Email != "cc@example.org" {
+		t.Errorf("cc = %+v", p.Cc)
+	}
+	if len(p.Bcc) != 1 || p.Bcc[0].Email != "bcc@example.org" {
+		t.Errorf("bcc = %+v", p.Bcc)
+	}
+	if len(msg.ReplyToList) != 1 || msg.ReplyToList[0].Email != "replies@example.com" {
+		t.Errorf("reply_to_list = %+v", msg.ReplyToList)
+	}
+	if msg.Headers["X-Campaign"] != "spring" || msg.Headers["Message-ID"] != "<"+testMessageID+">" {
+		t.Errorf("headers = %v", msg.Headers)
+	}
+
+	if len(msg.Attachments) != 2 {
+		t.Fatalf("attachments = %+v", msg.Attachments)
+	}
+	want := []sendGridAttachment{
+		{
+			Content:     base64.StdEncoding.EncodeToString([]byte("%PDF-report")),
+			Type:        "application/pdf",
+			Filename:    "report.pdf",
+			Disposition: "attachment",
+		},
+		{
+			Content:     base64.StdEncoding.EncodeToString([]byte("png-bytes")),
+			Type:        "image/png",
+			Filename:    "logo.png",
+			Disposition: "inline",
+			ContentID:   "logo",
+		},
+	}
+	for i := range want {
+		if msg.Attachments[i] != want[i] {
+			t.Errorf("attachment %d = %+v, want %+v", i, msg.Attachments[i], want[i])
+		}
+	}
+}
+
+func TestSendGridErrors(t *testing.T) {
+	checkErrorClassification(t, statusErrorCases, func(baseURL string) domain.EmailSender {
+		return newTestSendGrid(t, baseURL)
+	})
+}
+
+func TestSendGridInvalidEmailIsPermanent(t *testing.T) {
+	checkInvalidEmailIsPermanent(t, func(baseURL string) domain.EmailSender {
+		return newTestSendGrid(t, baseURL)
+	})
+}
@@ -0,0 +1,142 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/dkim"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const defaultSendmailPath = "/usr/sbin/sendmail"
+
+// Exit codes from sysexits.h that a sendmail-compatible program uses to
+// signal a condition that may clear up on its own.
+var sendmailTemporaryExitCodes = map[int]bool{
+	69: true, // EX_UNAVAILABLE
+	71: true, // EX_OSERR
+	73: true, // EX_CANTCREAT
+	74: true, // EX_IOERR
+	75: true, // EX_TEMPFAIL
+}
+
+type SendmailConfig struct {
+	// Path is the sendmail-compatible binary, /usr/sbin/sendmail by default
+	Path string
+	// Args are passed before the envelope arguments, "-i" by default
+	Args []string
+	// Hostname is used for the Message-ID, os.Hostname() by default
+	Hostname string
+	// DKIM signs every message if set
+	DKIM *dkim.Signer
+}
+
+// SendmailSender hands messages to the local MTA by piping them into a
+// sendmail-compatible command instead of speaking SMTP itself.
+type SendmailSender struct {
+	config *SendmailConfig
+}
+
+func NewSendmailSender(config *SendmailConfig) (*SendmailSender, error) {
+	cfg := *config
+	if cfg.Path == "" {
+		cfg.Path = defaultSendmailPath
+	}
+	if cfg.Args == nil {
+		cfg.Args = []string{"-i"}
+	}
+	if cfg.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine hostname: %w", err)
+		}
+		cfg.Hostname = hostname
+	}
+
+	path, err := exec.LookPath(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("sendmail binary not found: %w", err)
+	}
+	cfg.Path = path
+
+	return &SendmailSender{config: &cfg}, nil
+}
+
+func (s *SendmailSender) Send(ctx context.Context, email *domain.Email) error {
+	message, err := BuildMessage(email, s.config.Hostname)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to build message: %w", err))
+	}
+	if s.config.DKIM != nil {
+		if message, err = s.config.DKIM.Sign(message); err != nil {
+			return retry.Permanent(fmt.Errorf("failed to sign message: %w", err))
+		}
+	}
+
+	// The recipients are given explicitly, so Bcc addresses are delivered
+	// without having to appear in the headers.
+	args := append([]string{}, s.config.Args...)
+	args = append(args, "-f", email.From.Address, "--")
+	args = append(args, email.Recipients()...)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.config.Path, args...)
+	// sendmail expects local line endings on its standard input
+	cmd.Stdin = bytes.NewReader(bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n")))
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return s.classify(ctx, err, stderr.String())
+	}
+
+	return nil
+}
+
+// classify maps a failed sendmail run onto a retryable or permanent error
+func (s *SendmailSender) classify(ctx context.Context, err error, stderr string) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("sendmail interrupted: %w", ctx.Err())
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		// The command could not be started at all
+		return retry.Temporary(fmt.Errorf("sendmail failed: %w", err))
+	}
+
+	code := exitErr.ExitCode()
+	detail := strings.TrimSpace(stderr)
+	if detail == "" {
+		detail = err.Error()
+	}
+
+	sendErr := fmt.Errorf("sendmail exited with status %d: %s", code, detail)
+	if sendmailTemporaryExitCodes[code] || code < 0 {
+		return retry.Temporary(sendErr)
+	}
+	return retry.Permanent(sendErr)
+}
+
+func (s *SendmailSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	var failures []error
+	for _, email := range emails {
+		if err := s.Send(ctx, email); err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to send %d emails: %v", len(failures), failures[0])
+	}
+
+	return nil
+}
+
+func (s *SendmailSender) Close() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package infrastructure
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+// fakeSendmail writes a script that records its arguments and standard
+// input in dir and exits with the status stored in dir/status
+func fakeSendmail(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "sendmail")
+	script := `#!/bin/sh
+for arg in "$@"; do printf '%s\n' "$arg"; done > "` + dir + `/argv"
+cat > "` + dir + `/stdin"
+echo "fake failure" >&2
+exit $(cat "` + dir + `/status")
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	setStatus(t, dir, 0)
+	return path
+}
+
+func setStatus(t *testing.T, dir string, code int) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "status"), []byte(strconv.Itoa(code)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestSendmail(t *testing.T) (*SendmailSender, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sender, err := NewSendmailSender(&SendmailConfig{
+		Path:     fakeSendmail(t, dir),
+		Hostname: "test.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender, dir
+}
+
+func sendmailTestEmail(t *testing.T) *domain.Email {
+	t.Helper()
+	email, err := domain.NewEmailBuilder().
+		From("Sender <from@example.com>").
+		To("to@example.org").
+		Cc("cc@example.org").
+		Bcc("bcc@example.org").
+		Subject("Hello").
+		TextBody("line one\nline two").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return email
+}
+
+func TestSendmailArguments(t *testing.T) {
+	sender, dir := newTestSendmail(t)
+	if err := sender.Send(context.Background(), sendmailTestEmail(t)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	argv, err := os.ReadFile(filepath.Join(dir, "argv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-i\n-f\nfrom@example.com\n--\nto@example.org\ncc@example.org\nbcc@example.org\n"
+	if string(argv) != want {
+		t.Errorf("argv = %q, want %q", argv, want)
+	}
+
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stdin), "\r") {
+		t.Error("stdin contains CR, want LF line endings")
+	}
+	if !strings.Contains(string(stdin), "\nSubject: Hello\n") {
+		t.Errorf("stdin has no Subject line:\n%s", stdin)
+	}
+	if strings.Contains(string(stdin), "bcc@example.org") {
+		t.Error("Bcc address written into the message")
+	}
+}
+
+func TestSendmailExitCodes(t *testing.T) {
+	sender, dir := newTestSendmail(t)
+	tests := []struct {
+		code      int
+		retryable bool
+	}{
+		{64, false}, // EX_USAGE
+		{67, false}, // EX_NOUSER
+		{69, true},  // EX_UNAVAILABLE
+		{71, true},  // EX_OSERR
+		{73, true},  // EX_CANTCREAT
+		{74, true},  // EX_IOERR
+		{75, true},  // EX_TEMPFAIL
+		{78, false}, // EX_CONFIG
+		{1, false},
+	}
+	for _, tt := range tests {
+		setStatus(t, dir, tt.code)
+		err := sender.Send(context.Background(), sendmailTestEmail(t))
+		if err == nil {
+			t.Errorf("exit %d: Send succeeded", tt.code)
+			continue
+		}
+		if got := retry.IsRetryable(err); got != tt.retryable {
+			t.Errorf("exit %d: retryable = %v, want %v (%v)", tt.code, got, tt.retryable, err)
+		}
+		if !strings.Contains(err.Error(), "fake failure") {
+			t.Errorf("exit %d: error %q does not carry stderr", tt.code, err)
+		}
+	}
+}
+
+func TestSendmailInvalidEmailIsPermanent(t *testing.T) {
+	sender, dir := newTestSendmail(t)
+	email := sendmailTestEmail(t)
+	email.Headers = map[string]string{"Content-Type": "text/html"}
+
+	err := sender.Send(context.Background(), email)
+	if err == nil || retry.IsRetryable(err) {
+		t.Fatalf("Send = %v, want a permanent error", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "argv")); statErr == nil {
+		t.Error("sendmail ran for an invalid email")
+	}
+}
@@ -0,0 +1,224 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type SESConfig struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only needed for temporary credentials
+	SessionToken string
+	Region       string
+	// Hostname is used for the Message-ID, the From domain by default
+	Hostname string
+	// BaseURL overrides the regional endpoint, mainly for tests
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// SESSender delivers email through the Amazon SES v2 SendEmail API. The
+// message is sent as raw MIME from the shared builder, so attachments and
+// custom headers come out exactly as they would over SMTP.
+type SESSender struct {
+	config *SESConfig
+}
+
+type sesDestination struct {
+	ToAddresses  []string `json:"ToAddresses,omitempty"`
+	CcAddresses  []string `json:"CcAddresses,omitempty"`
+	BccAddresses []string `json:"BccAddresses,omitempty"`
+}
+
+type sesRequest struct {
+	FromEmailAddress string         `json:"FromEmailAddress"`
+	Destination      sesDestination `json:"Destination"`
+	Content          struct {
+		Raw struct {
+			Data []byte `json:"Data"`
+		} `json:"Raw"`
+	} `json:"Content"`
+}
+
+// SES error types that are worth retrying even though they are not
+// reported with a 429 or 5xx status. Throttling is the code of the
+// sending rate limit.
+var sesTemporaryErrorTypes = map[string]bool{
+	"Throttling":               true,
+	"LimitExceededException":   true,
+	"TooManyRequestsException": true,
+	"ThrottlingException":      true,
+	"ServiceUnavailable":       true,
+	"InternalFailureException": true,
+}
+
+func NewSESSender(config *SESConfig) (*SESSender, error) {
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("ses credentials are required")
+	}
+	if config.Region == "" {
+		return nil, fmt.Errorf("ses region is required")
+	}
+
+	cfg := *config
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = fmt.Sprintf("https://email.%s.amazonaws.com", cfg.Region)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = defaultHTTPClient()
+	}
+
+	return &SESSender{config: &cfg}, nil
+}
+
+func (s *SESSender) Send(ctx context.Context, email *domain.Email) error {
+	hostname := s.config.Hostname
+	if hostname == "" {
+		hostname = email.From.Domain()
+	}
+
+	message, err := BuildMessage(email, hostname)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to build message: %w", err))
+	}
+
+	var body sesRequest
+	body.FromEmailAddress = email.From.String()
+	body.Destination = sesDestination{
+		ToAddresses:  formatAddresses(email.To),
+		CcAddresses:  formatAddresses(email.Cc),
+		BccAddresses: formatAddresses(email.Bcc),
+	}
+	body.Content.Raw.Data = message
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to encode ses payload: %w", err))
+	}
+
+	url := strings.TrimRight(s.config.BaseURL, "/") + "/v2/email/outbound-emails"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to create ses request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, payload, time.Now().UTC())
+
+	_, err = doProviderRequest(s.config.HTTPClient, "ses", req, parseSESError)
+	return err
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *SESSender) sign(req *http.Request, payload []byte, now time.Time) {
+	const service = "ses"
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if s.config.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.config.Region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature,
+	))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func parseSESError(body []byte) (string, bool) {
+	var resp struct {
+		Type    string `json:"__type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return "", false
+	}
+
+	errType := resp.Type
+	if errType == "" {
+		errType = resp.Code
+	}
+	// __type may be namespaced, e.g. "com.amazonaws.ses#ThrottlingException"
+	errType = errType[strings.LastIndex(errType, "#")+1:]
+
+	if errType == "" {
+		return resp.Message, false
+	}
+	return errType + ": " + resp.Message, sesTemporaryErrorTypes[errType]
+}
+
+func (s *SESSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	return sendEach(ctx, emails, s.Send)
+}
+
+func (s *SESSender) Close() error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/parser"
+)
+
+func newTestSES(t *testing.T, baseURL string) *SESSender {
+	t.Helper()
+	sender, err := NewSESSender(&SESConfig{
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Region:          "eu-west-1",
+		BaseURL:         baseURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender
+}
+
+func TestSESRequest(t *testing.T) {
+	server, recorded := providerServer(t, http.StatusOK, `{"MessageId":"id"}`)
+	if err := newTestSES(t, server.URL).Send(context.Background(), providerTestEmail(t)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if recorded.path != "/v2/email/outbound-emails" {
+		t.Errorf("path = %q", recorded.path)
+	}
+	if auth := recorded.header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(auth, "/eu-west-1/ses/aws4_request") {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	var req sesRequest
+	if err := json.Unmarshal(recorded.body, &req); err != nil {
+		t.Fatalf("decoding body: %v\n%s", err, recorded.body)
+	}
+	dest := req.Destination
+	if strings.Join(dest.ToAddresses, ",") != `"Alice" <alice@example.org>` ||
+		strings.Join(dest.CcAddresses, ",") != "cc@example.org" ||
+		strings.Join(dest.BccAddresses, ",") != "bcc@example.org" {
+		t.Errorf("destination = %+v", dest)
+	}
+
+	// The raw message carries everything else
+	raw := req.Content.Raw.Data
+	if bytes.Contains(raw, []byte("bcc@example.org")) {
+		t.Error("Bcc address written into the raw message")
+	}
+	email, err := parser.ParseBytes(raw)
+	if err != nil {
+		t.Fatalf("parsing raw message: %v", err)
+	}
+	if len(email.Cc) != 1 || email.Cc[0].Address != "cc@example.org" {
+		t.Errorf("Cc = %v", email.Cc)
+	}
+	if len(email.ReplyTo) != 1 || email.ReplyTo[0].Address != "replies@example.com" {
+		t.Errorf("Reply-To = %v", email.ReplyTo)
+	}
+	if email.MessageID != testMessageID {
+		t.Errorf("Message-ID = %q", email.MessageID)
+	}
+	if email.Headers["X-Campaign"] != "spring" {
+		t.Errorf("headers = %v", email.Headers)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Filename != "report.pdf" ||
+		string(email.Attachments[0].Data) != "%PDF-report" {
+		t.Errorf("attachments = %+v", email.Attachments)
+	}
+	if len(email.Embedded) != 1 || email.Embedded[0].ContentID != "logo" ||
+		string(email.Embedded[0].Data) != "png-bytes" {
+		t.Errorf("embedded = %+v", email.Embedded)
+	}
+}
+
+func TestSESErrors(t *testing.T) {
+	cases := append([]errorCase{
+		{"throttling", http.StatusBadRequest, `{"__type":"Throttling","message":"Rate exceeded"}`, true},
+		{"namespaced throttling", http.StatusBadRequest, `{"__type":"com.amazonaws.ses#ThrottlingException","message":"Rate exceeded"}`, true},
+		{"limit exceeded", http.StatusBadRequest, `{"code":"LimitExceededException","message":"Daily quota"}`, true},
+		{"rejected", http.StatusBadRequest, `{"__type":"MessageRejected","message":"Email address is not verified"}`, false},
+	}, statusErrorCases...)
+	checkErrorClassification(t, cases, func(baseURL string) domain.EmailSender {
+		return newTestSES(t, baseURL)
+	})
+}
+
+func TestSESInvalidEmailIsPermanent(t *testing.T) {
+	checkInvalidEmailIsPermanent(t, func(baseURL string) domain.EmailSender {
+		return newTestSES(t, baseURL)
+	})
+}
@@ -1,15 +1,13 @@
 package infrastructure
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"fmt"
 	"go-smtp/production-ready-smtp-client/domain"
-	"mime"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/dkim"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
 	"net/smtp"
-	"strings"
-	"time"
 )
 
 type SMTPConfig struct {
@@ -18,11 +16,16 @@ type SMTPConfig struct {
 	Username string
 	Password string
 	PoolSize int
+	// FormatFlowed sends plain text bodies as format=flowed (RFC 3676)
+	FormatFlowed bool
+	// DKIM signs every message if set
+	DKIM *dkim.Signer
 }
 
 type SMTPClient struct {
-	config *SMTPConfig
-	pool   *ConnectionPool
+	config   *SMTPConfig
+	pool     *ConnectionPool
+	composer *composer.Composer
 }
 
 func NewSMTPClient(config *SMTPConfig) (*SMTPClient, error) {
@@ -32,13 +35,28 @@ func NewSMTPClient(config *SMTPConfig) (*SMTPClient, error) {
 	}
 
 	return &SMTPClient{
-		config: config,
-		pool:   pool,
+		config:   config,
+		pool:     pool,
+		// EmailService assigns Message-IDs, under MessageIDDomain if set.
+		// Only emails sent to the client directly arrive without one, and
+		// with no Hostname the composer generates theirs under the From
+		// domain.
+		composer: composer.New(composer.Options{FormatFlowed: config.FormatFlowed}),
 	}, nil
 }
 
 
 func (c *SMTPClient) Send(ctx context.Context, email *domain.Email) error {
+	// Catch invalid headers and missing attachment files before a
+	// connection is tied up. Composing into DATA would report them as a
+	// write failure, which looks retryable.
+	if err := email.ValidateHeaders(); err != nil {
+		return retry.Permanent(fmt.Errorf("invalid email: %w", err))
+	}
+	if err := checkAttachments(email); err != nil {
+		return retry.Permanent(err)
+	}
+	
 	// Get connection from pool
 	conn, err := c.pool.Get(ctx)
 	if err != nil {
@@ -46,14 +64,8 @@ func (c *SMTPClient) Send(ctx context.Context, email *domain.Email) error {
 	}
 	defer c.pool.Put(conn)
 	
-	// Build message
-	message, err := c.buildMessage(email)
-	if err != nil {
-		return fmt.Errorf("failed to build message: %w", err)
-	}
-	
 	// Send using connection
-	if err := c.sendWithConnection(conn, email, message); err != nil {
+	if err := c.sendWithConnection(conn, email); err != nil {
 		return fmt.Errorf("failed to send: %w", err)
 	}
 	
@@ -85,15 +97,24 @@ func (c *SMTPClient) SendBulk(ctx context.Context, emails []*domain.Email) error
 	return nil
 }
 
-func (c *SMTPClient) sendWithConnection(conn *smtp.Client, email *domain.Email, message []byte) error {
-	// MAIL FROM
-	if err := conn.Mail(email.From); err != nil {
+func (c *SMTPClient) sendWithConnection(conn *smtp.Client, email *domain.Email) error {
+	// A signed message is composed in memory before the transaction,
+	// since the signature header covers the body and has to come first
+	var signed []byte
+	if c.config.DKIM != nil {
+		var err error
+		if signed, err = c.sign(email); err != nil {
+			return err
+		}
+	}
+
+	// MAIL FROM and RCPT TO take the plain addresses, display names only
+	// appear in the headers
+	if err := conn.Mail(email.From.Address); err != nil {
 		return fmt.Errorf("MAIL FROM failed: %w", err)
 	}
 	
-	// RCPT TO
-	allRecipients := append(append(email.To, email.Cc...), email.Bcc...)
-	for _, addr := range allRecipients {
+	for _, addr := range email.Recipients() {
 		if err := conn.Rcpt(addr); err != nil {
 			return fmt.Errorf("RCPT TO %s failed: %w", addr, err)
 		}
@@ -105,7 +126,24 @@ func (c *SMTPClient) sendWithConnection(conn *smtp.Client, email *domain.Email,
 		return fmt.Errorf("DATA failed: %w", err)
 	}
 	
-	if _, err := w.Write(message); err != nil {
+	if signed != nil {
+		_, err = w.Write(signed)
+	} else {
+		// Compose straight into the DATA stream so attachments are encoded
+		// as they are read and never held in memory. Text goes unencoded
+		// to servers with 8BITMIME, for which Mail already sent
+		// BODY=8BITMIME.
+		eightBit, _ := conn.Extension("8BITMIME")
+		err = c.composer.With8BitMIME(eightBit).Compose(w, email)
+	}
+	if err != nil {
+		// Closing w would submit the partial message. Dropping the
+		// connection makes the server discard it; the pool replaces the
+		// dead connection on its next Get.
+		conn.Close()
+		if isPermanentBuildError(err) {
+			return retry.Permanent(fmt.Errorf("failed to build message: %w", err))
+		}
 		return fmt.Errorf("write failed: %w", err)
 	}
 	
@@ -117,119 +155,19 @@ func (c *SMTPClient) sendWithConnection(conn *smtp.Client, email *domain.Email,
 	return conn.Reset()
 }
 
-func (c *SMTPClient) buildMessage(email *domain.Email) ([]byte, error) {
-	var buf bytes.Buffer
-	
-	// Generate boundaries
-	outerBoundary := fmt.Sprintf("outer_%d", time.Now().UnixNano())
-	innerBoundary := fmt.Sprintf("inner_%d", time.Now().UnixNano()+1)
-	
-	// Headers
-	buf.WriteString(fmt.Sprintf("From: %s\r\n", email.From))
-	buf.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(email.To, ", ")))
-	
-	if len(email.Cc) > 0 {
-		buf.WriteString(fmt.Sprintf("Cc: %s\r\n", strings.Join(email.Cc, ", ")))
-	}
-	
-	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", email.Subject)))
-	buf.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
-	buf.WriteString(fmt.Sprintf("Message-ID: <%d@%s>\r\n", time.Now().UnixNano(), c.config.Host))
-	
-	// Custom headers
-	for key, value := range email.Headers {
-		buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
-	}
-	
-	// Priority
-	switch email.Priority {
-	case domain.PriorityHigh:
-		buf.WriteString("X-Priority: 1\r\n")
-		buf.WriteString("Importance: high\r\n")
-	case domain.PriorityLow:
-		buf.WriteString("X-Priority: 5\r\n")
-		buf.WriteString("Importance: low\r\n")
+// sign composes and signs email. The message stays 7bit even for 8BITMIME
+// servers, since a relay that has to convert 8bit content on the way
+// breaks the signature.
+func (c *SMTPClient) sign(email *domain.Email) ([]byte, error) {
+	message, err := c.composer.Bytes(email)
+	if err != nil {
+		return nil, retry.Permanent(fmt.Errorf("failed to build message: %w", err))
 	}
-	
-	buf.WriteString("MIME-Version: 1.0\r\n")
-	
-	// If we have attachments, use multipart/mixed
-	if len(email.Attachments) > 0 {
-		buf.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\r\n", outerBoundary))
-		buf.WriteString("\r\n")
-		
-		// Email body (multipart/alternative)
-		buf.WriteString(fmt.Sprintf("--%s\r\n", outerBoundary))
-		buf.WriteString(fmt.Sprintf("Content-Type: multipart/alternative; boundary=\"%s\"\r\n", innerBoundary))
-		buf.WriteString("\r\n")
-		
-		// Text part
-		if email.TextBody != "" {
-			buf.WriteString(fmt.Sprintf("--%s\r\n", innerBoundary))
-			buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-			buf.WriteString("\r\n")
-			buf.WriteString(email.TextBody)
-			buf.WriteString("\r\n\r\n")
-		}
-		
-		// HTML part
-		if email.HTMLBody != "" {
-			buf.WriteString(fmt.Sprintf("--%s\r\n", innerBoundary))
-			buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
-			buf.WriteString("\r\n")
-			buf.WriteString(email.HTMLBody)
-			buf.WriteString("\r\n\r\n")
-		}
-		
-		buf.WriteString(fmt.Sprintf("--%s--\r\n", innerBoundary))
-		buf.WriteString("\r\n")
-		
-		// Attachments
-		for _, att := range email.Attachments {
-			buf.WriteString(fmt.Sprintf("--%s\r\n", outerBoundary))
-			buf.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\r\n", att.ContentType, att.Filename))
-			buf.WriteString("Content-Transfer-Encoding: base64\r\n")
-			buf.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n", att.Filename))
-			buf.WriteString("\r\n")
-			
-			encoded := base64.StdEncoding.EncodeToString(att.Data)
-			for i := 0; i < len(encoded); i += 76 {
-				end := i + 76
-				if end > len(encoded) {
-					end = len(encoded)
-				}
-				buf.WriteString(encoded[i:end])
-				buf.WriteString("\r\n")
-			}
-			buf.WriteString("\r\n")
-		}
-		
-		buf.WriteString(fmt.Sprintf("--%s--\r\n", outerBoundary))
-	} else {
-		// No attachments, use multipart/alternative
-		buf.WriteString(fmt.Sprintf("Content-Type: multipart/alternative; boundary=\"%s\"\r\n", innerBoundary))
-		buf.WriteString("\r\n")
-		
-		if email.TextBody != "" {
-			buf.WriteString(fmt.Sprintf("--%s\r\n", innerBoundary))
-			buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-			buf.WriteString("\r\n")
-			buf.WriteString(email.TextBody)
-			buf.WriteString("\r\n\r\n")
-		}
-		
-		if email.HTMLBody != "" {
-			buf.WriteString(fmt.Sprintf("--%s\r\n", innerBoundary))
-			buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
-			buf.WriteString("\r\n")
-			buf.WriteString(email.HTMLBody)
-			buf.WriteString("\r\n\r\n")
-		}
-		
-		buf.WriteString(fmt.Sprintf("--%s--\r\n", innerBoundary))
+	signed, err := c.config.DKIM.Sign(message)
+	if err != nil {
+		return nil, retry.Permanent(fmt.Errorf("failed to sign message: %w", err))
 	}
-	
-	return buf.Bytes(), nil
+	return signed, nil
 }
 
 func (c *SMTPClient) Close() error {
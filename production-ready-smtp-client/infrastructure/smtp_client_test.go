@@ -0,0 +1,65 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+func TestSMTPInvalidEmailIsPermanent(t *testing.T) {
+	// An empty pool never dials, and an invalid email must be rejected
+	// before a connection is needed
+	client, err := NewSMTPClient(&SMTPConfig{Host: "127.0.0.1", Port: "1", PoolSize: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Hello").
+		TextBody("body").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	email.Headers = map[string]string{"Content-Type": "text/html"}
+
+	err = client.Send(context.Background(), email)
+	if err == nil || retry.IsRetryable(err) {
+		t.Fatalf("Send = %v, want a permanent error", err)
+	}
+}
+
+func TestSMTPConsumedStreamIsPermanent(t *testing.T) {
+	client, err := NewSMTPClient(&SMTPConfig{Host: "127.0.0.1", Port: "1", PoolSize: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Hello").
+		TextBody("body").
+		AttachStream("data.csv", "text/csv", strings.NewReader("a,b\n")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A first attempt that failed during DATA read the stream
+	if _, err := email.Attachments[0].ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Send(context.Background(), email)
+	if !errors.Is(err, domain.ErrStreamConsumed) || retry.IsRetryable(err) {
+		t.Fatalf("Send = %v, want a permanent ErrStreamConsumed", err)
+	}
+}
@@ -0,0 +1,32 @@
+package infrastructure
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// MemorySuppressionStore is a SuppressionStore kept in memory, for tests
+// and single-process deployments. Addresses are compared case-insensitively.
+type MemorySuppressionStore struct {
+	mu        sync.RWMutex
+	addresses map[string]struct{}
+}
+
+func NewMemorySuppressionStore() *MemorySuppressionStore {
+	return &MemorySuppressionStore{addresses: make(map[string]struct{})}
+}
+
+func (s *MemorySuppressionStore) Suppress(ctx context.Context, address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addresses[strings.ToLower(address)] = struct{}{}
+	return nil
+}
+
+func (s *MemorySuppressionStore) IsSuppressed(ctx context.Context, address string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.addresses[strings.ToLower(address)]
+	return ok, nil
+}
@@ -0,0 +1,258 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrMirrorBusy is reported for messages that were not mirrored because
+// MaxInFlight mirror sends were already running
+var ErrMirrorBusy = errors.New("mirror busy, message not mirrored")
+
+// MirrorResult records what happened to the mirrored copy of one message
+type MirrorResult struct {
+	EmailID    string
+	Subject    string
+	Recipients []string
+	Duration   time.Duration
+	Err        error
+}
+
+type TeeConfig struct {
+	Primary domain.EmailSender
+	Mirror  domain.EmailSender
+	// RewriteRecipient maps each recipient of the mirror copy, e.g. to a
+	// capture mailbox. Returning "" drops the recipient. Nil keeps the
+	// original recipients.
+	RewriteRecipient func(addr string) string
+	// OnMirror receives the outcome of every mirror send. By default the
+	// outcome is logged.
+	OnMirror func(MirrorResult)
+	// Timeout bounds each mirror send, 30 seconds by default
+	Timeout time.Duration
+	// MaxInFlight caps concurrent mirror sends, 10 by default. Messages
+	// arriving while the cap is reached are not mirrored.
+	MaxInFlight int
+}
+
+// TeeSender delivers through a primary sender and asynchronously mirrors
+// every message to a secondary one, e.g. a new provider under evaluation.
+// The mirror never affects the result returned to the caller.
+type TeeSender struct {
+	config   *TeeConfig
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewTeeSender(config *TeeConfig) (*TeeSender, error) {
+	if config.Primary == nil || config.Mirror == nil {
+		return nil, fmt.Errorf("primary and mirror senders are required")
+	}
+
+	cfg := *config
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 10
+	}
+	if cfg.OnMirror == nil {
+		cfg.OnMirror = logMirrorResult
+	}
+
+	return &TeeSender{
+		config:   &cfg,
+		inFlight: make(chan struct{}, cfg.MaxInFlight),
+	}, nil
+}
+
+func (s *TeeSender) Send(ctx context.Context, email *domain.Email) error {
+	primary, mirror, err := s.split(email)
+	if err != nil {
+		return err
+	}
+
+	err = s.config.Primary.Send(ctx, primary)
+
+	if mirror != nil {
+		s.mirror(mirror)
+	}
+
+	return err
+}
+
+func (s *TeeSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	var failures []error
+	primaries := make([]*domain.Email, 0, len(emails))
+	var mirrors []*domain.Email
+	for _, email := range emails {
+		primary, mirror, err := s.split(email)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		primaries = append(primaries, primary)
+		if mirror != nil {
+			mirrors = append(mirrors, mirror)
+		}
+	}
+
+	err := s.config.Primary.SendBulk(ctx, primaries)
+
+	for _, mirror := range mirrors {
+		s.mirror(mirror)
+	}
+
+	if len(failures) > 0 {
+		return errors.Join(fmt.Errorf("failed to prepare %d emails: %w", len(failures), failures[0]), err)
+	}
+	return err
+}
+
+// Close waits for in-flight mirror sends before closing both senders
+func (s *TeeSender) Close() error {
+	s.wg.Wait()
+
+	primaryErr := s.config.Primary.Close()
+	if err := s.config.Mirror.Close(); err != nil {
+		log.Printf("Failed to close mirror sender: %v", err)
+	}
+	return primaryErr
+}
+
+// split returns the email for the primary sender and the copy to mirror,
+// nil if rewriting left it without recipients. The copy is made before the
+// primary send, so the mirror sees the message as the caller built it, not
+// as the primary or the caller later modify it. Streamed attachments can
+// only be read once, so for a mirrored email they are read into memory
+// first and both senders get the same bytes.
+func (s *TeeSender) split(email *domain.Email) (primary, mirror *domain.Email, err error) {
+	mirror = s.mirrorCopy(email)
+	if mirror == nil || !hasStreams(email) {
+		return email, mirror, nil
+	}
+
+	primary = email.Clone()
+	if err := bufferStreams(primary); err != nil {
+		return nil, nil, retry.Permanent(fmt.Errorf("failed to read attachments: %w", err))
+	}
+	return primary, s.mirrorCopy(primary), nil
+}
+
+// mirrorCopy returns the copy of email to mirror, or nil if rewriting
+// left it without recipients
+func (s *TeeSender) mirrorCopy(email *domain.Email) *domain.Email {
+	mirror := email.Clone()
+	if s.config.RewriteRecipient == nil {
+		return mirror
+	}
+
+	mirror.To = rewriteAddresses(mirror.To, s.config.RewriteRecipient)
+	mirror.Cc = rewriteAddresses(mirror.Cc, s.config.RewriteRecipient)
+	mirror.Bcc = rewriteAddresses(mirror.Bcc, s.config.RewriteRecipient)
+
+	if len(mirror.To)+len(mirror.Cc)+len(mirror.Bcc) == 0 {
+		return nil
+	}
+	return mirror
+}
+
+// hasStreams reports whether email or an email attached to it has an
+// attachment that is read from a Reader
+func hasStreams(email *domain.Email) bool {
+	for _, att := range append(append([]domain.Attachment(nil), email.Attachments...), email.Embedded...) {
+		if att.Data == nil && att.Reader != nil {
+			return true
+		}
+		if att.Message != nil && hasStreams(att.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferStreams reads every streamed attachment of email and its attached
+// emails into Data. It modifies email, so callers apply it to a clone.
+func bufferStreams(email *domain.Email) error {
+	for _, list := range [][]domain.Attachment{email.Attachments, email.Embedded} {
+		for i, att := range list {
+			if att.Message != nil {
+				if err := bufferStreams(att.Message); err != nil {
+					return err
+				}
+			}
+			if att.Data != nil || att.Reader == nil {
+				continue
+			}
+			data, err := att.ReadAll()
+			if err != nil {
+				return fmt.Errorf("attachment %s: %w", att.Filename, err)
+			}
+			// An empty stream still has to read as present but empty
+			if data == nil {
+				data = []byte{}
+			}
+			list[i].Data = data
+			list[i].Reader = nil
+		}
+	}
+	return nil
+}
+
+func (s *TeeSender) mirror(email *domain.Email) {
+	result := MirrorResult{
+		EmailID:    email.ID,
+		Subject:    email.Subject,
+		Recipients: email.Recipients(),
+	}
+
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		result.Err = ErrMirrorBusy
+		s.config.OnMirror(result)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.inFlight }()
+
+		// The caller's context may be cancelled as soon as Send returns, so
+		// the mirror runs on its own deadline
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+		defer cancel()
+
+		start := time.Now()
+		result.Err = s.config.Mirror.Send(ctx, email)
+		result.Duration = time.Since(start)
+		s.config.OnMirror(result)
+	}()
+}
+
+// rewriteAddresses rewrites the plain address of each recipient, keeping
+// its display name
+func rewriteAddresses(addrs []domain.Address, rewrite func(string) string) []domain.Address {
+	var out []domain.Address
+	for _, addr := range addrs {
+		if rewritten := rewrite(addr.Address); rewritten != "" {
+			out = append(out, domain.Address{Name: addr.Name, Address: rewritten})
+		}
+	}
+	return out
+}
+
+func logMirrorResult(result MirrorResult) {
+	if result.Err != nil {
+		log.Printf("Mirror send to %v failed: %v", result.Recipients, result.Err)
+		return
+	}
+	log.Printf("Mirrored email to %v in %v", result.Recipients, result.Duration)
+}
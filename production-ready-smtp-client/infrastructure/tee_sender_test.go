@@ -0,0 +1,95 @@
+package infrastructure
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+// readingSender reads the attachments of every email like a transport
+// does and keeps their contents
+type readingSender struct {
+	mu          sync.Mutex
+	attachments map[string]string
+}
+
+func (s *readingSender) Send(ctx context.Context, email *domain.Email) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attachments == nil {
+		s.attachments = make(map[string]string)
+	}
+	for _, att := range email.Attachments {
+		data, err := att.ReadAll()
+		if err != nil {
+			return err
+		}
+		s.attachments[att.Filename] = string(data)
+	}
+	return nil
+}
+
+func (s *readingSender) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	for _, email := range emails {
+		if err := s.Send(ctx, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *readingSender) Close() error { return nil }
+
+func TestTeeMirrorsStreamedAttachments(t *testing.T) {
+	for _, bulk := range []bool{false, true} {
+		primary, mirror := &readingSender{}, &readingSender{}
+		var results []MirrorResult
+		tee, err := NewTeeSender(&TeeConfig{
+			Primary:  primary,
+			Mirror:   mirror,
+			OnMirror: func(result MirrorResult) { results = append(results, result) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		email, err := domain.NewEmailBuilder().
+			From("from@example.com").
+			To("to@example.org").
+			Subject("Report").
+			TextBody("attached").
+			AttachStream("report.csv", "text/csv", strings.NewReader("a,b\n1,2\n")).
+			Attach("notes.txt", "text/plain", []byte("notes")).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bulk {
+			err = tee.SendBulk(context.Background(), []*domain.Email{email})
+		} else {
+			err = tee.Send(context.Background(), email)
+		}
+		if err != nil {
+			t.Fatalf("bulk %v: send: %v", bulk, err)
+		}
+		if err := tee.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 || results[0].Err != nil {
+			t.Fatalf("bulk %v: mirror results = %+v", bulk, results)
+		}
+		for name, sender := range map[string]*readingSender{"primary": primary, "mirror": mirror} {
+			if got := sender.attachments["report.csv"]; got != "a,b\n1,2\n" {
+				t.Errorf("bulk %v: %s got report.csv = %q", bulk, name, got)
+			}
+			if got := sender.attachments["notes.txt"]; got != "notes" {
+				t.Errorf("bulk %v: %s got notes.txt = %q", bulk, name, got)
+			}
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"go-smtp/production-ready-smtp-client/config"
 	"go-smtp/production-ready-smtp-client/domain"
 	"go-smtp/production-ready-smtp-client/infrastructure"
+	"go-smtp/production-ready-smtp-client/pkg/dkim"
 	"log"
+	"os"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -25,50 +27,115 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Create SMTP client
-	smtpConfig := &infrastructure.SMTPConfig{
-		Host:     cfg.SMTP.Host,
-		Port:     cfg.SMTP.Port,
-		Username: cfg.SMTP.Username,
-		Password: cfg.SMTP.Password,
-		PoolSize: cfg.SMTP.PoolSize,
-	}
-
-	smtpClient, err := infrastructure.NewSMTPClient(smtpConfig)
+	// Create the configured transport
+	sender, err := newSender(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create SMTP client: %v", err)
+		log.Fatalf("Failed to create %s sender: %v", cfg.Transport, err)
 	}
-	defer smtpClient.Close()
 
 	// Create email service
-	emailService := application.NewEmailService(smtpClient)
+	emailService := application.NewEmailService(sender)
+	emailService.SetMessageIDDomain(cfg.MessageIDDomain)
 	defer emailService.Close()
 
 	fmt.Println("🚀 Production-Ready SMTP Client Started")
-	fmt.Printf("📧 SMTP Server: %s:%s\n", cfg.SMTP.Host, cfg.SMTP.Port)
-	fmt.Printf("🔄 Connection Pool Size: %d\n\n", cfg.SMTP.PoolSize)
+	switch cfg.Transport {
+	case config.TransportSMTP:
+		fmt.Printf("📧 SMTP Server: %s:%s\n", cfg.SMTP.Host, cfg.SMTP.Port)
+		fmt.Printf("🔄 Connection Pool Size: %d\n\n", cfg.SMTP.PoolSize)
+	case config.TransportFile, config.TransportMaildir:
+		fmt.Printf("📁 Writing %s output to %s\n\n", cfg.Transport, cfg.File.Dir)
+	case config.TransportSendmail:
+		fmt.Printf("📮 Piping messages to %s\n\n", cfg.Sendmail.Path)
+	}
+
+	// Offline transports don't need SMTP credentials, so there may be no
+	// configured sender address
+	from := cfg.SMTP.Username
+	if from == "" {
+		from = "dev@example.com"
+	}
 
 	// Example 1: Send simple email
 	fmt.Println("📤 Example 1: Sending simple email...")
-	if err := sendSimpleEmail(emailService, cfg.SMTP.Username); err != nil {
+	if err := sendSimpleEmail(emailService, from); err != nil {
 		log.Printf("❌ Failed: %v", err)
 	}
 
 	// Example 2: Send email with attachments
 	fmt.Println("\n📤 Example 2: Sending email with attachments...")
-	if err := sendEmailWithAttachments(emailService, cfg.SMTP.Username); err != nil {
+	if err := sendEmailWithAttachments(emailService, from); err != nil {
 		log.Printf("❌ Failed: %v", err)
 	}
 
 	// Example 3: Send bulk emails
 	fmt.Println("\n📤 Example 3: Sending bulk emails...")
-	if err := sendBulkEmails(emailService, cfg.SMTP.Username); err != nil {
+	if err := sendBulkEmails(emailService, from); err != nil {
 		log.Printf("❌ Failed: %v", err)
 	}
 
 	fmt.Println("\n✅ All examples completed!")
 }
 
+func newSender(cfg *config.Config) (domain.EmailSender, error) {
+	signer, err := newDKIMSigner(cfg.DKIM)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Transport {
+	case config.TransportFile:
+		return infrastructure.NewFileSender(&infrastructure.FileSenderConfig{
+			Dir:    cfg.File.Dir,
+			Format: infrastructure.FormatEML,
+		})
+	case config.TransportMaildir:
+		return infrastructure.NewFileSender(&infrastructure.FileSenderConfig{
+			Dir:    cfg.File.Dir,
+			Format: infrastructure.FormatMaildir,
+		})
+	case config.TransportSendmail:
+		return infrastructure.NewSendmailSender(&infrastructure.SendmailConfig{
+			Path: cfg.Sendmail.Path,
+			DKIM: signer,
+		})
+	default:
+		return infrastructure.NewSMTPClient(&infrastructure.SMTPConfig{
+			Host:         cfg.SMTP.Host,
+			Port:         cfg.SMTP.Port,
+			Username:     cfg.SMTP.Username,
+			Password:     cfg.SMTP.Password,
+			PoolSize:     cfg.SMTP.PoolSize,
+			FormatFlowed: cfg.SMTP.FormatFlowed,
+			DKIM:         signer,
+		})
+	}
+}
+
+// newDKIMSigner returns nil if signing is not configured
+func newDKIMSigner(cfg config.DKIMConfig) (*dkim.Signer, error) {
+	if cfg.KeyFile == "" {
+		return nil, nil
+	}
+
+	pem, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DKIM key: %w", err)
+	}
+	key, err := dkim.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DKIM key: %w", err)
+	}
+
+	return dkim.NewSigner(&dkim.SignerConfig{
+		Keys: []dkim.Key{{
+			Domain:     cfg.Domain,
+			Selector:   cfg.Selector,
+			PrivateKey: key,
+		}},
+	})
+}
+
 
 func sendSimpleEmail(service *application.EmailService, from string) error {
 	email, err := domain.NewEmailBuilder().
@@ -96,7 +163,13 @@ func sendSimpleEmail(service *application.EmailService, from string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 	
-	return service.SendEmail(ctx, email)
+	if err := service.SendEmail(ctx, email); err != nil {
+		return err
+	}
+
+	// The Message-ID is kept for threading replies and matching bounces
+	fmt.Printf("✉️  Message-ID: <%s>\n", email.MessageID)
+	return nil
 }
 
 func sendEmailWithAttachments(service *application.EmailService, from string) error {
@@ -0,0 +1,364 @@
+// Package composer renders domain.Email values as RFC 5322 messages with
+// RFC 2045-2049 MIME structure.
+package composer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/htmlmail"
+	"io"
+	mathrand "math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// Hostname is used as the right-hand side of generated Message-IDs,
+	// the From domain by default. Emails that already have a MessageID
+	// keep it; the others are given the generated one.
+	Hostname string
+	// EightBitMIME sends UTF-8 text parts unencoded. Only set it when the
+	// server announces 8BITMIME, since others may corrupt 8-bit bytes.
+	EightBitMIME bool
+	// FormatFlowed sends plain text bodies as format=flowed (RFC 3676), so
+	// long paragraphs reflow to the reader's window instead of showing
+	// as one long line or hard-wrapped text
+	FormatFlowed bool
+
+	// Now is the clock for the Date header and calendar DTSTAMPs,
+	// time.Now by default
+	Now func() time.Time
+	// Random is the source of MIME boundaries, crypto/rand by default
+	Random io.Reader
+	// MessageID generates the Message-ID of emails that have none,
+	// domain.NewMessageID by default
+	MessageID func(domainName string) string
+}
+
+// Deterministic returns options under which the output depends only on
+// the email, for comparing messages with golden files: a fixed clock, a
+// seeded random source and sequential Message-IDs. Each call starts a new
+// sequence, so the composer should be created once per test.
+func Deterministic() Options {
+	var sequence int
+	return Options{
+		Now: func() time.Time {
+			return time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+		},
+		Random: mathrand.NewChaCha8([32]byte{}),
+		MessageID: func(domainName string) string {
+			sequence++
+			return fmt.Sprintf("%d.deterministic@%s", sequence, domainName)
+		},
+	}
+}
+
+// Composer turns emails into MIME messages. The layout is
+//
+//	multipart/mixed               (only with attachments)
+//	├── multipart/alternative     (only with several bodies)
+//	│   ├── text/plain
+//	│   ├── multipart/related     (only with embedded parts)
+//	│   │   ├── text/html
+//	│   │   └── embedded parts...
+//	│   └── text/calendar         (only with a calendar event)
+//	├── attachments...            (message/rfc822 for attached emails)
+//	└── invite.ics                (only with a calendar event)
+//
+// with every level that would have a single child collapsed into it.
+type Composer struct {
+	hostname     string
+	eightBit     bool
+	formatFlowed bool
+	now          func() time.Time
+	random       io.Reader
+	messageID    func(domainName string) string
+}
+
+func New(opts Options) *Composer {
+	c := &Composer{
+		hostname:     opts.Hostname,
+		eightBit:     opts.EightBitMIME,
+		formatFlowed: opts.FormatFlowed,
+		now:          opts.Now,
+		random:       opts.Random,
+		messageID:    opts.MessageID,
+	}
+	if c.now == nil {
+		c.now = time.Now
+	}
+	if c.random == nil {
+		c.random = rand.Reader
+	}
+	if c.messageID == nil {
+		c.messageID = domain.NewMessageID
+	}
+	return c
+}
+
+// Bytes composes email into memory
+func (c *Composer) Bytes(email *domain.Email) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Compose(&buf, email); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Compose writes email to w as a complete message. An email without a
+// MessageID is given the one written, so replies and bounces can be
+// matched to it and a retry sends the same ID.
+func (c *Composer) Compose(w io.Writer, email *domain.Email) error {
+	if err := email.ValidateHeaders(); err != nil {
+		return err
+	}
+
+	body, err := c.buildBody(email)
+	if err != nil {
+		return err
+	}
+
+	hw := &headerWriter{w: w}
+	c.writeMessageHeaders(hw, email)
+	hw.field("MIME-Version", "1.0")
+	body.writeHeader(hw)
+	if hw.err != nil {
+		return hw.err
+	}
+
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+	if err := body.writeBody(w); err != nil {
+		return err
+	}
+	if !body.endsWithNewline() {
+		_, err := io.WriteString(w, "\r\n")
+		return err
+	}
+	return nil
+}
+
+func (c *Composer) writeMessageHeaders(hw *headerWriter, email *domain.Email) {
+	hw.field("From", email.From.String())
+	hw.field("To", domain.FormatAddressList(email.To))
+	if len(email.Cc) > 0 {
+		hw.field("Cc", domain.FormatAddressList(email.Cc))
+	}
+	if len(email.ReplyTo) > 0 {
+		hw.field("Reply-To", domain.FormatAddressList(email.ReplyTo))
+	}
+	if email.Sender.Address != "" {
+		hw.field("Sender", email.Sender.String())
+	}
+	hw.field("Subject", encodeUnstructured("Subject", email.Subject))
+	hw.field("Date", c.now().Format(time.RFC1123Z))
+
+	hw.field("Message-ID", FormatMessageIDs(email.EnsureMessageIDWith(c.hostname, c.messageID)))
+	if email.InReplyTo != "" {
+		hw.field("In-Reply-To", FormatMessageIDs(email.InReplyTo))
+	}
+	if len(email.References) > 0 {
+		hw.field("References", FormatMessageIDs(email.References...))
+	}
+
+	keys := make([]string, 0, len(email.Headers))
+	for key := range email.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// Custom headers are treated as unstructured text
+		hw.field(key, encodeUnstructured(key, email.Headers[key]))
+	}
+
+	if xPriority, importance := PriorityHeaders(email.Priority); xPriority != "" {
+		hw.field("X-Priority", xPriority)
+		hw.field("Importance", importance)
+	}
+}
+
+// buildBody assembles the MIME tree for email
+func (c *Composer) buildBody(email *domain.Email) (*part, error) {
+	var alternatives []*part
+	if text := TextBody(email); text != "" {
+		alternatives = append(alternatives, c.plainTextPart(text))
+	}
+	if email.HTMLBody != "" {
+		related := []*part{newTextPart("text/html", email.HTMLBody, c.eightBit)}
+		for _, embedded := range email.Embedded {
+			related = append(related, newEmbeddedPart(embedded))
+		}
+		html := c.multipart("related", related...)
+		if len(related) > 1 {
+			// RFC 2387 requires the type of the root part
+			html.params["type"] = "text/html"
+		}
+		alternatives = append(alternatives, html)
+	}
+	var ics []byte
+	if email.Calendar != nil {
+		// Clients show the invitation from the text/calendar alternative;
+		// the attachment is for those, like Outlook on some platforms,
+		// that only import .ics attachments
+		ics = []byte(email.Calendar.ICS(c.now()))
+		calendar := newTextPart("text/calendar", string(ics), c.eightBit)
+		calendar.params["method"] = string(email.Calendar.Method)
+		alternatives = append(alternatives, calendar)
+	}
+	if len(alternatives) == 0 {
+		return nil, fmt.Errorf("email has no body")
+	}
+
+	body := c.multipart("alternative", alternatives...)
+	if len(email.Attachments) == 0 && ics == nil {
+		return body, nil
+	}
+
+	parts := []*part{body}
+	for _, att := range email.Attachments {
+		if !isMessage(att) {
+			parts = append(parts, newAttachmentPart(att))
+			continue
+		}
+		message, err := c.messagePart(att)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, message)
+	}
+	if ics != nil {
+		parts = append(parts, newAttachmentPart(CalendarAttachment(ics)))
+	}
+	return c.multipart("mixed", parts...), nil
+}
+
+// messagePart returns the message/rfc822 part for an attached email.
+// RFC 2046 5.2.1 only allows the identity encodings for it, so parts of a
+// raw message with long lines or 8-bit content the server can't take are
+// encoded inside the message instead, see encodeMessage. Composed emails
+// never need this, their parts are already encoded for the server.
+func (c *Composer) messagePart(att domain.Attachment) (*part, error) {
+	var raw []byte
+	var err error
+	if att.Message != nil {
+		raw, err = c.Bytes(att.Message)
+	} else {
+		raw, err = att.ReadAll()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attached message %s: %w", att.Filename, err)
+	}
+
+	p := newAttachmentPart(att)
+	p.mediaType = domain.MessageContentType
+	p.open = nil
+	p.content, p.encoding, err = encodeMessage(normalizeNewlines(raw), c.eightBit)
+	if err != nil {
+		return nil, fmt.Errorf("attached message %s: %w", att.Filename, err)
+	}
+	return p, nil
+}
+
+// isMessage reports whether att is an attached email
+func isMessage(att domain.Attachment) bool {
+	if att.Message != nil {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(att.ContentType, ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), domain.MessageContentType)
+}
+
+// CalendarAttachment returns the .ics attachment sent along with a
+// calendar event rendered as ics
+func CalendarAttachment(ics []byte) domain.Attachment {
+	return domain.Attachment{
+		Filename:    "invite.ics",
+		ContentType: "application/ics",
+		Data:        ics,
+	}
+}
+
+// plainTextPart returns the text/plain body part, format=flowed if
+// configured
+func (c *Composer) plainTextPart(text string) *part {
+	if !c.formatFlowed {
+		return newTextPart("text/plain", text, c.eightBit)
+	}
+	p := newTextPart("text/plain", formatFlowed(text), c.eightBit)
+	p.params["format"] = "flowed"
+	p.params["delsp"] = "no"
+	return p
+}
+
+// With8BitMIME returns a copy of the composer with the EightBitMIME option
+// set to enabled, for transports that learn whether the server supports
+// 8BITMIME per connection
+func (c *Composer) With8BitMIME(enabled bool) *Composer {
+	clone := *c
+	clone.eightBit = enabled
+	return &clone
+}
+
+// multipart wraps parts in a multipart/subtype container, or returns the
+// only part unwrapped
+func (c *Composer) multipart(subtype string, parts ...*part) *part {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return newMultipart(subtype, c.boundary(), parts)
+}
+
+// boundary returns a random boundary. It cannot occur in base64 or
+// quoted-printable output because it contains "=_", and 7bit bodies
+// can't guess it.
+func (c *Composer) boundary() string {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(c.random, buf); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(fmt.Sprintf("composer: failed to read random bytes: %v", err))
+	}
+	return "=_" + hex.EncodeToString(buf)
+}
+
+// FormatMessageIDs formats message IDs for a Message-ID, In-Reply-To or
+// References header
+func FormatMessageIDs(ids ...string) string {
+	formatted := make([]string, len(ids))
+	for i, id := range ids {
+		formatted[i] = "<" + id + ">"
+	}
+	return strings.Join(formatted, " ")
+}
+
+// TextBody returns the plain text body of email. HTML-only emails get one
+// generated from the HTML, since mail without a text alternative is more
+// likely to be taken for spam. Invitations without a body get a
+// description of the event.
+func TextBody(email *domain.Email) string {
+	switch {
+	case email.TextBody != "":
+		return email.TextBody
+	case email.HTMLBody != "":
+		return htmlmail.ToText(email.HTMLBody)
+	case email.Calendar != nil:
+		return email.Calendar.PlainText()
+	}
+	return ""
+}
+
+// PriorityHeaders returns the X-Priority and Importance values for p, or
+// empty strings when no priority headers should be sent
+func PriorityHeaders(p domain.Priority) (xPriority, importance string) {
+	switch p {
+	case domain.PriorityHigh:
+		return "1", "high"
+	case domain.PriorityLow:
+		return "5", "low"
+	}
+	return "", ""
+}
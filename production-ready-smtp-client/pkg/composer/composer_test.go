@@ -0,0 +1,139 @@
+package composer
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+func TestComposeStreamTwice(t *testing.T) {
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Data").
+		TextBody("body").
+		AttachStream("data.csv", "text/csv", strings.NewReader("a,b\n1,2\n")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(Deterministic())
+	raw, err := c.Bytes(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "YSxiCjEsMgo=") {
+		t.Errorf("attachment content missing:\n%s", raw)
+	}
+
+	// A retry must not send an empty attachment
+	if _, err := c.Bytes(email); !errors.Is(err, domain.ErrStreamConsumed) {
+		t.Errorf("second compose = %v, want ErrStreamConsumed", err)
+	}
+}
+
+func TestComposeCalendarMethod(t *testing.T) {
+	start := time.Date(2024, time.February, 1, 9, 30, 0, 0, time.UTC)
+	event := &domain.CalendarEvent{
+		UID:       "planning-2024@example.com",
+		Method:    domain.MethodRequest,
+		Summary:   "Planning",
+		Start:     start,
+		End:       start.Add(time.Hour),
+		Organizer: domain.Address{Address: "from@example.com"},
+		Attendees: []domain.Attendee{{Address: domain.Address{Address: "to@example.org"}}},
+	}
+
+	tests := []struct {
+		name  string
+		event *domain.CalendarEvent
+		want  string
+	}{
+		{"request", event, "Content-Type: text/calendar; charset=UTF-8; method=REQUEST\r\n"},
+		{"cancellation", event.Cancellation(), "Content-Type: text/calendar; charset=UTF-8; method=CANCEL\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := domain.NewEmailBuilder().
+				From("from@example.com").
+				To("to@example.org").
+				Subject("Planning").
+				Invite(tt.event).
+				Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := New(Deterministic()).Bytes(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(raw), tt.want) {
+				t.Errorf("missing %q in\n%s", tt.want, raw)
+			}
+			// The METHOD property must agree with the parameter
+			method := "METHOD:" + string(tt.event.Method) + "\r\n"
+			if !strings.Contains(string(raw), method) {
+				t.Errorf("missing %q in\n%s", method, raw)
+			}
+		})
+	}
+}
+
+func TestComposeAssignsMessageID(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		messageID string
+		want      *regexp.Regexp
+	}{
+		{"from domain", Options{}, "", regexp.MustCompile(`^[0-9a-z.]+@example\.com$`)},
+		{"hostname", Options{Hostname: "mail.example.net"}, "", regexp.MustCompile(`^[0-9a-z.]+@mail\.example\.net$`)},
+		{"existing kept", Options{Hostname: "mail.example.net"}, "existing@example.org", regexp.MustCompile(`^existing@example\.org$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := domain.NewEmailBuilder().
+				From("from@example.com").
+				To("to@example.org").
+				Subject("Hello").
+				TextBody("body").
+				Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			email.MessageID = tt.messageID
+
+			c := New(tt.opts)
+			raw, err := c.Bytes(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want.MatchString(email.MessageID) {
+				t.Errorf("MessageID = %q, want match for %s", email.MessageID, tt.want)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := msg.Header.Get("Message-ID"), "<"+email.MessageID+">"; got != want {
+				t.Errorf("Message-ID header = %q, want %q", got, want)
+			}
+
+			// Composing again, as a retry does, sends the same ID
+			first := email.MessageID
+			if _, err := c.Bytes(email); err != nil {
+				t.Fatal(err)
+			}
+			if email.MessageID != first {
+				t.Errorf("MessageID changed from %q to %q", first, email.MessageID)
+			}
+		})
+	}
+}
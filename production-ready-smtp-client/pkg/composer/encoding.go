@@ -0,0 +1,140 @@
+package composer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"unicode/utf8"
+)
+
+// Content-Transfer-Encoding values, RFC 2045 section 6
+const (
+	Encoding7Bit            = "7bit"
+	Encoding8Bit            = "8bit"
+	EncodingQuotedPrintable = "quoted-printable"
+	EncodingBase64          = "base64"
+)
+
+// maxLineLength is the limit on line length from RFC 5322 section 2.1.1,
+// excluding the CRLF
+const maxLineLength = 998
+
+// base64LineLength is the line length RFC 2045 section 6.8 requires for
+// base64 output
+const base64LineLength = 76
+
+// chooseTextEncoding picks the lightest transfer encoding that carries
+// text through any SMTP server unchanged: 7bit for short-lined ASCII,
+// quoted-printable for mostly ASCII text and base64 for everything else.
+// With eightBit, for servers that announce 8BITMIME, short-lined UTF-8
+// text is sent as is.
+func chooseTextEncoding(text []byte, eightBit bool) string {
+	nonASCII := 0
+	hasNUL := false
+	lineLength := 0
+	longLines := false
+	for _, b := range text {
+		switch {
+		case b == '\n':
+			lineLength = 0
+			continue
+		case b == '\r':
+			continue
+		case b == 0:
+			hasNUL = true
+			nonASCII++
+		case b >= 0x80:
+			nonASCII++
+		}
+		lineLength++
+		if lineLength > maxLineLength {
+			longLines = true
+		}
+	}
+
+	switch {
+	case nonASCII == 0 && !longLines:
+		return Encoding7Bit
+	case eightBit && !longLines && !hasNUL && utf8.Valid(text):
+		return Encoding8Bit
+	case nonASCII*3 < len(text):
+		// quoted-printable triples every non-ASCII byte, so it only wins
+		// while those bytes are a minority
+		return EncodingQuotedPrintable
+	default:
+		return EncodingBase64
+	}
+}
+
+// normalizeNewlines converts bare LF and bare CR line breaks to CRLF
+func normalizeNewlines(text []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(text) + len(text)/40)
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\r':
+			buf.WriteString("\r\n")
+			if i+1 < len(text) && text[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+			buf.WriteString("\r\n")
+		default:
+			buf.WriteByte(text[i])
+		}
+	}
+	return buf.Bytes()
+}
+
+// newEncoder returns a writer that encodes everything written to it with
+// encoding onto w. The caller must Close it to flush the final bytes.
+func newEncoder(w io.Writer, encoding string) io.WriteCloser {
+	switch encoding {
+	case EncodingBase64:
+		return base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: w, limit: base64LineLength})
+	case EncodingQuotedPrintable:
+		return quotedprintable.NewWriter(w)
+	default:
+		return nopCloser{w}
+	}
+}
+
+// lineWrapper inserts a CRLF after every limit bytes written to it
+type lineWrapper struct {
+	w      io.Writer
+	limit  int
+	column int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if l.column == l.limit {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.column = 0
+		}
+
+		chunk := p
+		if room := l.limit - l.column; len(chunk) > room {
+			chunk = chunk[:room]
+		}
+
+		n, err := l.w.Write(chunk)
+		written += n
+		l.column += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
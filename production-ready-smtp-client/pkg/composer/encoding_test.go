@@ -0,0 +1,116 @@
+package composer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+func TestChooseTextEncoding(t *testing.T) {
+	mostlyASCII := "Grüße, " + strings.Repeat("plain ascii text ", 5)
+	mostlyUTF8 := "日本語のテキスト"
+	longLine := strings.Repeat("a", maxLineLength+1)
+	tests := []struct {
+		name     string
+		text     string
+		sevenBit string
+		eightBit string
+	}{
+		{"ASCII", "Hello\r\nWorld", Encoding7Bit, Encoding7Bit},
+		{"empty", "", Encoding7Bit, Encoding7Bit},
+		{"line at the limit", strings.Repeat("a", maxLineLength) + "\nb", Encoding7Bit, Encoding7Bit},
+		{"mostly ASCII", mostlyASCII, EncodingQuotedPrintable, Encoding8Bit},
+		{"mostly non-ASCII", mostlyUTF8, EncodingBase64, Encoding8Bit},
+		{"long ASCII line", longLine, EncodingQuotedPrintable, EncodingQuotedPrintable},
+		{"long UTF-8 line", strings.Repeat("ü", maxLineLength), EncodingBase64, EncodingBase64},
+		{"NUL", "a\x00b", EncodingBase64, EncodingBase64},
+		{"invalid UTF-8", "caf\xe9 " + strings.Repeat("x", 10), EncodingQuotedPrintable, EncodingQuotedPrintable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chooseTextEncoding([]byte(tt.text), false); got != tt.sevenBit {
+				t.Errorf("without 8BITMIME = %s, want %s", got, tt.sevenBit)
+			}
+			if got := chooseTextEncoding([]byte(tt.text), true); got != tt.eightBit {
+				t.Errorf("with 8BITMIME = %s, want %s", got, tt.eightBit)
+			}
+		})
+	}
+}
+
+func TestComposeTextEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		eightBit bool
+		want     string
+	}{
+		{"ASCII", "Hello", false, Encoding7Bit},
+		{"UTF-8 with 8BITMIME", "Grüße", true, Encoding8Bit},
+		{"UTF-8 without 8BITMIME", "Grüße aus Berlin", false, EncodingQuotedPrintable},
+		{"CJK without 8BITMIME", "日本語のテキスト", false, EncodingBase64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := domain.NewEmailBuilder().
+				From("from@example.com").
+				To("to@example.org").
+				Subject("Text").
+				TextBody(tt.body).
+				Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := New(Deterministic()).With8BitMIME(tt.eightBit).Bytes(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, body, _ := strings.Cut(string(raw), "\r\n\r\n")
+			if !strings.Contains(header+"\r\n", "\r\nContent-Transfer-Encoding: "+tt.want+"\r\n") {
+				t.Fatalf("want Content-Transfer-Encoding %s:\n%s", tt.want, header)
+			}
+			if tt.want != Encoding8Bit && strings.IndexFunc(body, func(r rune) bool { return r >= 0x80 }) >= 0 {
+				t.Errorf("%s body has 8-bit bytes: %q", tt.want, body)
+			}
+			if got := decodeBody(t, tt.want, body); got != tt.body {
+				t.Errorf("decoded body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func decodeBody(t *testing.T, encoding, body string) string {
+	t.Helper()
+	var r io.Reader = strings.NewReader(body)
+	switch encoding {
+	case EncodingQuotedPrintable:
+		r = quotedprintable.NewReader(r)
+	case EncodingBase64:
+		r = base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.ReplaceAll(body, "\r\n", "")))
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(string(data), "\r\n")
+}
+
+func TestLineWrapper(t *testing.T) {
+	var buf bytes.Buffer
+	w := newEncoder(&buf, EncodingBase64)
+	io.WriteString(w, strings.Repeat("x", 200))
+	w.Close()
+
+	lines := strings.Split(buf.String(), "\r\n")
+	for i, line := range lines {
+		if len(line) > base64LineLength || i < len(lines)-1 && len(line) != base64LineLength {
+			t.Errorf("line %d is %d long", i, len(line))
+		}
+	}
+}
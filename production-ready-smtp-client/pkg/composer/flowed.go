@@ -0,0 +1,98 @@
+package composer
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// flowedWidth is the line length format=flowed text is wrapped at, as
+// RFC 3676 section 4.2 recommends
+const flowedWidth = 78
+
+// formatFlowed encodes text as format=flowed with DelSp=no (RFC 3676).
+// Long lines are wrapped at spaces, each soft break ending in the space it
+// was made at, so clients that understand the format rejoin and reflow the
+// paragraphs while others show tidy short lines. Trailing spaces of hard
+// lines are removed since they would mark a soft break, except in the
+// "-- " signature separator.
+func formatFlowed(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	var b strings.Builder
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		if line == "-- " {
+			b.WriteString(line)
+			continue
+		}
+
+		// Quoted lines keep their depth on every line they are wrapped
+		// into. They are written as "> text", the space after the quote
+		// marks being stuffing that readers remove.
+		quote := line[:len(line)-len(strings.TrimLeft(line, ">"))]
+		content := strings.TrimRight(line[len(quote):], " ")
+		if quote != "" {
+			content = strings.TrimPrefix(content, " ")
+			quote += " "
+		}
+
+		for first := true; first || content != ""; first = false {
+			segment, rest := wrapFlowed(content, flowedWidth-len(quote))
+			if !first {
+				b.WriteString(" \r\n")
+			}
+			if segment == "" {
+				// A trailing space would make it a soft break
+				b.WriteString(strings.TrimSuffix(quote, " "))
+				break
+			}
+			b.WriteString(quote)
+			b.WriteString(stuffFlowed(quote, segment))
+			content = rest
+		}
+	}
+	return b.String()
+}
+
+// wrapFlowed splits s at a space so that the first segment plus the soft
+// break space fits in width characters. The break is made at the last
+// space of a run, so the rest starts with a word. Words longer than width
+// are not broken.
+func wrapFlowed(s string, width int) (string, string) {
+	if utf8.RuneCountInString(s) <= width {
+		return s, ""
+	}
+
+	cut := -1
+	count := 0
+	for i, r := range s {
+		endOfRun := r == ' ' && (i+1 == len(s) || s[i+1] != ' ')
+		if endOfRun && strings.TrimLeft(s[:i], " ") != "" {
+			if count+1 > width {
+				if cut < 0 {
+					cut = i
+				}
+				break
+			}
+			cut = i
+		}
+		count++
+	}
+	if cut < 0 {
+		return s, ""
+	}
+	return s[:cut], s[cut+1:]
+}
+
+// stuffFlowed space-stuffs an unquoted line that starts with a space, a
+// quote marker or "From " (RFC 3676 section 4.4). Quoted lines are always
+// stuffed after their quote marks.
+func stuffFlowed(quote, s string) string {
+	if quote == "" && (strings.HasPrefix(s, " ") || strings.HasPrefix(s, ">") || strings.HasPrefix(s, "From ")) {
+		return " " + s
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+package composer
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+func TestFormatFlowed(t *testing.T) {
+	long := strings.Repeat("word ", 20) + "end"
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"short", "Hello", "Hello"},
+		{"line endings", "one\ntwo\r\nthree\rfour", "one\r\ntwo\r\nthree\r\nfour"},
+		{"trailing spaces removed", "hard line   \nnext", "hard line\r\nnext"},
+		{"signature separator kept", "body\n-- \nsig", "body\r\n-- \r\nsig"},
+		{
+			"wrapped at a space",
+			long,
+			strings.Repeat("word ", 15) + "\r\n" + strings.Repeat("word ", 5) + "end",
+		},
+		{
+			"long word not broken",
+			strings.Repeat("x", 100) + " y",
+			strings.Repeat("x", 100) + " \r\ny",
+		},
+		{
+			"break after a run of spaces",
+			strings.Repeat("a", 70) + "     " + strings.Repeat("b", 10),
+			strings.Repeat("a", 70) + "     \r\n" + strings.Repeat("b", 10),
+		},
+		{"stuffed space", " indented", "  indented"},
+		{"stuffed From", "From here", " From here"},
+		{"marker read as a quote", ">quoted", "> quoted"},
+		{"marker inside a quote", "> >x", "> >x"},
+		{"From mid-line", "Sent From here", "Sent From here"},
+		{"quote", "> quoted", "> quoted"},
+		{"nested quote", ">>deep  ", ">> deep"},
+		{"empty quote line", ">", ">"},
+		{
+			"quote wrapped with its depth",
+			"> " + long,
+			"> " + strings.Repeat("word ", 15) + "\r\n> " + strings.Repeat("word ", 5) + "end",
+		},
+		{"empty lines", "a\n\nb", "a\r\n\r\nb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatFlowed(tt.text); got != tt.want {
+				t.Errorf("formatFlowed =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatFlowedLineLength(t *testing.T) {
+	text := strings.Repeat("Grüße aus der schönen Stadt, ", 20) + "\n> " + strings.Repeat("quoted reply text ", 15)
+	for _, line := range strings.Split(formatFlowed(text), "\r\n") {
+		if n := utf8.RuneCountInString(line); n > flowedWidth {
+			t.Errorf("line of %d characters: %q", n, line)
+		}
+	}
+}
+
+// TestFormatFlowedRoundTrip decodes the output the way RFC 3676 section
+// 4.2 describes and expects the original paragraphs back
+func TestFormatFlowedRoundTrip(t *testing.T) {
+	texts := []string{
+		strings.Repeat("The quick brown fox jumps over the lazy dog. ", 10) + "Done.",
+		"Short line\n\n" + strings.Repeat("second paragraph ", 12) + "end",
+		"From the start " + strings.Repeat("and more words ", 10) + "end",
+		"> " + strings.Repeat("quoted text that goes on ", 8) + "end",
+	}
+	for _, text := range texts {
+		if got := unflow(formatFlowed(text)); got != text {
+			t.Errorf("round trip =\n%q\nwant\n%q", got, text)
+		}
+	}
+}
+
+// unflow joins soft line breaks and removes space-stuffing, with DelSp=no
+func unflow(flowed string) string {
+	var lines []string
+	var paragraph strings.Builder
+	open := false
+	for _, line := range strings.Split(flowed, "\r\n") {
+		quote := line[:len(line)-len(strings.TrimLeft(line, ">"))]
+		content := strings.TrimPrefix(line[len(quote):], " ")
+		if !open && quote != "" {
+			paragraph.WriteString(quote + " ")
+		}
+		paragraph.WriteString(content)
+		open = strings.HasSuffix(content, " ")
+		if !open {
+			lines = append(lines, paragraph.String())
+			paragraph.Reset()
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestComposeFormatFlowed(t *testing.T) {
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Flowed").
+		TextBody(strings.Repeat("word ", 20) + "end").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Deterministic()
+	opts.FormatFlowed = true
+	raw, err := New(opts).Bytes(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "Content-Type: text/plain; charset=UTF-8; delsp=no; format=flowed\r\n") {
+		t.Errorf("no format=flowed Content-Type:\n%s", raw)
+	}
+	if !strings.Contains(string(raw), strings.Repeat("word ", 15)+"\r\n") {
+		t.Errorf("body not wrapped with a soft break:\n%s", raw)
+	}
+}
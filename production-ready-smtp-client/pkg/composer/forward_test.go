@@ -0,0 +1,119 @@
+package composer_test
+
+import (
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/parser"
+)
+
+// TestForwardRoundTrip composes forwards and parses them back, once with
+// a composed original and once with its raw bytes, with and without
+// 8BITMIME
+func TestForwardRoundTrip(t *testing.T) {
+	original, err := domain.NewEmailBuilder().
+		From("Kunde <kunde@example.net>").
+		To("support@example.com").
+		Subject("Rechnung für März").
+		TextBody("Grüße,\nthe invoice total is wrong.").
+		HTMLBody("<p>Grüße,</p><p>the invoice total is wrong.</p>").
+		Attach("rechnung.pdf", "application/pdf", []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original.MessageID = "original@example.net"
+
+	rawOriginal, err := composer.New(composer.Options{EightBitMIME: true}).Bytes(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments := map[string]func(b *domain.EmailBuilder) *domain.EmailBuilder{
+		"email": func(b *domain.EmailBuilder) *domain.EmailBuilder {
+			return b.AttachMessage(original)
+		},
+		"raw": func(b *domain.EmailBuilder) *domain.EmailBuilder {
+			return b.AttachRawMessage("original.eml", rawOriginal)
+		},
+	}
+	for name, attach := range attachments {
+		for _, eightBit := range []bool{false, true} {
+			forward, err := attach(domain.NewEmailBuilder()).
+				From("agent@example.com").
+				To("billing@example.com").
+				Subject("Fwd: Rechnung für März").
+				TextBody("See below.").
+				Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := composer.New(composer.Deterministic()).With8BitMIME(eightBit).Bytes(forward)
+			if err != nil {
+				t.Fatalf("%s 8bit=%v: %v", name, eightBit, err)
+			}
+			if !eightBit && strings.IndexFunc(string(raw), func(r rune) bool { return r >= 0x80 }) >= 0 {
+				t.Errorf("%s: message has 8-bit bytes without 8BITMIME", name)
+			}
+
+			parsed, err := parser.ParseBytes(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parsed.Attachments) != 1 || parsed.Attachments[0].ContentType != domain.MessageContentType {
+				t.Fatalf("%s 8bit=%v: attachments = %+v", name, eightBit, parsed.Attachments)
+			}
+			if strings.Contains(string(raw), "Content-Transfer-Encoding: base64\r\nContent-Type: message/rfc822") {
+				t.Errorf("%s 8bit=%v: message/rfc822 part is base64 encoded", name, eightBit)
+			}
+
+			inner, err := parser.ParseBytes(parsed.Attachments[0].Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inner.Subject != original.Subject || inner.MessageID != original.MessageID ||
+				inner.From.Address != "kunde@example.net" {
+				t.Errorf("%s 8bit=%v: inner header = %q %q %v", name, eightBit, inner.Subject, inner.MessageID, inner.From)
+			}
+			if inner.TextBody != "Grüße,\nthe invoice total is wrong." || !strings.Contains(inner.HTMLBody, "<p>Grüße,</p>") {
+				t.Errorf("%s 8bit=%v: inner bodies = %q %q", name, eightBit, inner.TextBody, inner.HTMLBody)
+			}
+			if len(inner.Attachments) != 1 || string(inner.Attachments[0].Data) != "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n" {
+				t.Errorf("%s 8bit=%v: inner attachments = %+v", name, eightBit, inner.Attachments)
+			}
+		}
+	}
+}
+
+// TestForwardRawHeadersKept checks that the header of a raw message is
+// sent byte for byte, folding and field order included
+func TestForwardRawHeadersKept(t *testing.T) {
+	header := "Received: from mx.example.net by mail.example.com;\r\n" +
+		"\tTue, 2 Jan 2024 10:00:00 +0000\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.net; s=sel;\r\n" +
+		"  bh=abc; b=def\r\n" +
+		"subject: lower-case name\r\n" +
+		"From: kunde@example.net\r\n"
+	raw := header + "\r\nPlain body\r\n"
+
+	forward, err := domain.NewEmailBuilder().
+		From("agent@example.com").
+		To("billing@example.com").
+		Subject("Fwd").
+		TextBody("See below.").
+		AttachRawMessage("original.eml", []byte(raw)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := composer.New(composer.Deterministic()).Bytes(forward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "Content-Type: message/rfc822; name=original.eml\r\n\r\n"+raw) {
+		t.Errorf("raw message not kept:\n%s", out)
+	}
+}
@@ -0,0 +1,109 @@
+package composer_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/emailtest"
+)
+
+// The golden files are composed with composer.Deterministic. After an
+// intended change to the output, regenerate them with
+// UPDATE_GOLDEN=1 go test ./pkg/composer
+func TestComposeGolden(t *testing.T) {
+	start := time.Date(2024, time.February, 1, 9, 30, 0, 0, time.UTC)
+	pngData := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01")
+
+	tests := []struct {
+		name  string
+		build func(b *domain.EmailBuilder) *domain.EmailBuilder
+	}{
+		{
+			name: "plain",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				return b.TextBody("Hello Alice,\n\nThe report is ready.\n")
+			},
+		},
+		{
+			name: "alternative",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				return b.TextBody("Hello Alice,\n\nThe report is ready.\n").
+					HTMLBody("<p>Hello Alice,</p><p>The report is <b>ready</b>.</p>")
+			},
+		},
+		{
+			name: "mixed",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				return b.TextBody("The report and its data are attached.\n").
+					HTMLBody("<p>The report and its data are attached.</p>").
+					Attach("report.pdf", "application/pdf", []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")).
+					Attach("data.csv", "text/csv", []byte("month,total\njanuary,12\n"))
+			},
+		},
+		{
+			name: "related",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				return b.TextBody("Our logo is below.\n").
+					HTMLBody(`<p>Our logo:</p><img src="cid:logo" alt="Logo">`).
+					Embed("logo", "logo.png", pngData)
+			},
+		},
+		{
+			name: "calendar",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				return b.TextBody("You are invited to the planning meeting.\n").
+					Invite(&domain.CalendarEvent{
+						UID:         "planning-2024@example.com",
+						Method:      domain.MethodRequest,
+						Summary:     "Planning",
+						Description: "Quarterly planning",
+						Location:    "Room 4",
+						Start:       start,
+						End:         start.Add(time.Hour),
+						Organizer:   domain.Address{Name: "Sender", Address: "sender@example.com"},
+						Attendees: []domain.Attendee{
+							{Address: domain.Address{Name: "Alice", Address: "alice@example.org"}, Role: domain.RoleRequired, RSVP: true},
+						},
+					})
+			},
+		},
+		{
+			name: "digest",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				original := &domain.Email{
+					From:      domain.Address{Name: "Customer", Address: "customer@example.net"},
+					To:        []domain.Address{{Address: "support@example.com"}},
+					Subject:   "Broken invoice",
+					TextBody:  "Invoice 42 shows the wrong total.\n",
+					MessageID: "original@example.net",
+					Priority:  domain.PriorityNormal,
+				}
+				return b.TextBody("Two messages from this week.\n").
+					AttachMessage(original).
+					AttachRawMessage("reply.eml", []byte("From: support@example.com\r\n"+
+						"Subject: Re: Broken invoice\r\n"+
+						"Message-ID: <reply@example.com>\r\n"+
+						"Content-Type: text/plain; charset=UTF-8\r\n"+
+						"Content-Transfer-Encoding: 8bit\r\n"+
+						"\r\n"+
+						"Grüße, the corrected invoice follows.\r\n"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := domain.NewEmailBuilder().
+				From("Sender <sender@example.com>").
+				To("Alice <alice@example.org>").
+				Subject("Quarterly report")
+			email, err := tt.build(b).Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			emailtest.AssertGolden(t, filepath.Join("testdata", tt.name+".golden"), email)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package composer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// foldLength is the line length RFC 5322 2.1.1 asks header fields to
+// be folded to. Lines without whitespace to fold at may run longer.
+const foldLength = 78
+
+// encodedWordLength is the longest encoded word RFC 2047 2 allows
+const encodedWordLength = 75
+
+// ErrLineTooLong is returned for a header field that can't be folded into
+// lines of at most 998 characters
+var ErrLineTooLong = errors.New("header line too long")
+
+// headerWriter writes header fields to w, remembering the first error so
+// a sequence of fields can be written without checking each one
+type headerWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (hw *headerWriter) field(name, value string) {
+	if hw.err != nil {
+		return
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		// Values are validated before composing; this guards against a
+		// line break slipping in through formatting
+		hw.err = fmt.Errorf("line break in %s header", name)
+		return
+	}
+	folded := fold(name + ": " + value)
+	for _, line := range strings.Split(folded, "\r\n") {
+		if len(line) > maxLineLength {
+			hw.err = fmt.Errorf("%s: %w", name, ErrLineTooLong)
+			return
+		}
+	}
+	_, hw.err = io.WriteString(hw.w, folded+"\r\n")
+}
+
+// encodeUnstructured prepares an unstructured value such as Subject. Text
+// that isn't ASCII becomes RFC 2047 encoded words, and so does text with a
+// word too long for a line, since a run of encoded words can be folded
+// where the text itself has no whitespace to fold at.
+func encodeUnstructured(name, value string) string {
+	if encoded := mime.QEncoding.Encode("UTF-8", value); encoded != value {
+		return encoded
+	}
+	for _, word := range strings.Fields(value) {
+		if len(name)+len(": ")+len(word) > maxLineLength {
+			return qEncodeWords(value)
+		}
+	}
+	return value
+}
+
+// qEncodeWords Q-encodes ASCII text as a sequence of encoded words
+// separated by spaces, which decoders drop between encoded words. Spaces
+// in the text are encoded as "_".
+func qEncodeWords(value string) string {
+	const prefix, suffix = "=?UTF-8?q?", "?="
+	const hex = "0123456789ABCDEF"
+
+	var words []string
+	var word strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		var encoded string
+		switch {
+		case c == ' ':
+			encoded = "_"
+		case c > ' ' && c < 0x7f && c != '=' && c != '?' && c != '_':
+			encoded = string(c)
+		default:
+			encoded = string([]byte{'=', hex[c>>4], hex[c&0xF]})
+		}
+		if len(prefix)+word.Len()+len(encoded)+len(suffix) > encodedWordLength {
+			words = append(words, prefix+word.String()+suffix)
+			word.Reset()
+		}
+		word.WriteString(encoded)
+	}
+	if word.Len() > 0 {
+		words = append(words, prefix+word.String()+suffix)
+	}
+	return strings.Join(words, " ")
+}
+
+// fold breaks a header line before whitespace so that no line is longer
+// than foldLength where possible. Unfolding, which removes the CRLFs,
+// gives back the original line.
+func fold(line string) string {
+	if len(line) <= foldLength {
+		return line
+	}
+
+	var b strings.Builder
+	for len(line) > foldLength {
+		cut := foldPoint(line)
+		if cut < 0 {
+			break
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// foldPoint returns the index of the last whitespace in line at which it
+// can be folded within foldLength, or the first one after it if there
+// is none. Whitespace that only follows other whitespace is skipped, since
+// folding there would leave a line with nothing on it.
+func foldPoint(line string) int {
+	last := -1
+	for i := 1; i < len(line); i++ {
+		if (line[i] != ' ' && line[i] != '\t') || strings.TrimSpace(line[:i]) == "" {
+			continue
+		}
+		if i > foldLength {
+			if last < 0 {
+				return i
+			}
+			break
+		}
+		last = i
+	}
+	return last
+}
@@ -0,0 +1,86 @@
+package composer
+
+import (
+	"errors"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+func headerTestEmail(t *testing.T) *domain.Email {
+	t.Helper()
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Hello").
+		TextBody("body").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return email
+}
+
+func TestLongHeaderValuesAreFolded(t *testing.T) {
+	token := strings.Repeat("abcdefghij", 120) // 1200 characters, no whitespace
+	tests := []struct {
+		name   string
+		modify func(e *domain.Email)
+		header string
+		want   string
+	}{
+		{"custom", func(e *domain.Email) { e.Headers = map[string]string{"X-Token": token} }, "X-Token", token},
+		{"custom with spaces", func(e *domain.Email) {
+			e.Headers = map[string]string{"X-Token": "a_b=c? " + token + " end"}
+		}, "X-Token", "a_b=c? " + token + " end"},
+		{"subject", func(e *domain.Email) { e.Subject = token }, "Subject", token},
+		{"non-ASCII", func(e *domain.Email) { e.Subject = strings.Repeat("ü", 600) }, "Subject", strings.Repeat("ü", 600)},
+		// Values that fit are left readable
+		{"long URL", func(e *domain.Email) {
+			e.Headers = map[string]string{"X-Url": "https://example.com/" + strings.Repeat("p", 900)}
+		}, "X-Url", "https://example.com/" + strings.Repeat("p", 900)},
+	}
+
+	for _, tt := range tests {
+		email := headerTestEmail(t)
+		tt.modify(email)
+		raw, err := New(Options{}).Bytes(email)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			if len(line) > maxLineLength {
+				t.Errorf("%s: line of %d characters", tt.name, len(line))
+			}
+		}
+
+		msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get(tt.header))
+		if err != nil {
+			t.Fatalf("%s: decoding %s: %v", tt.name, tt.header, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: %s round-trips as %q", tt.name, tt.header, got)
+		}
+		if tt.name == "long URL" && strings.Contains(msg.Header.Get(tt.header), "=?") {
+			t.Errorf("%s: value was encoded", tt.name)
+		}
+	}
+}
+
+func TestUnfoldableHeaderIsRejected(t *testing.T) {
+	email := headerTestEmail(t)
+	email.References = []string{strings.Repeat("x", 1100) + "@example.com"}
+
+	_, err := New(Options{}).Bytes(email)
+	if !errors.Is(err, ErrLineTooLong) {
+		t.Fatalf("err = %v, want ErrLineTooLong", err)
+	}
+}
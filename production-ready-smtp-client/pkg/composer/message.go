@@ -0,0 +1,239 @@
+package composer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// ErrNeeds8BitMIME is returned for an attached message with 8-bit bytes in
+// a header, which no transfer encoding can carry to a server without
+// 8BITMIME
+var ErrNeeds8BitMIME = errors.New("attached message needs 8BITMIME")
+
+// maxMessageDepth bounds the nesting of multiparts and messages walked by
+// encodeMessage
+const maxMessageDepth = 20
+
+// encodeMessage prepares an attached message with CRLF line endings for a
+// message/rfc822 part, which RFC 2046 section 5.2.1 restricts to the 7bit
+// and 8bit encodings. The message is returned as it is if it fits one of
+// them. Otherwise every leaf part that can't be sent as is gets base64 or
+// quoted-printable content and a matching Content-Transfer-Encoding field;
+// all other bytes, headers included, are kept. The second result is the
+// encoding of the message/rfc822 part itself.
+func encodeMessage(raw []byte, eightBit bool) ([]byte, string, error) {
+	encoded, err := encodeEntity(raw, eightBit, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	encoding := identityEncoding(encoded, eightBit)
+	if encoding == "" {
+		return nil, "", ErrNeeds8BitMIME
+	}
+	return encoded, encoding, nil
+}
+
+// identityEncoding returns 7bit or 8bit if content can be sent unencoded,
+// or "" if it can't
+func identityEncoding(content []byte, eightBit bool) string {
+	nonASCII := false
+	lineLength := 0
+	for _, b := range content {
+		switch {
+		case b == '\n':
+			lineLength = 0
+			continue
+		case b == '\r':
+			continue
+		case b == 0:
+			return ""
+		case b >= 0x80:
+			nonASCII = true
+		}
+		lineLength++
+		if lineLength > maxLineLength {
+			return ""
+		}
+	}
+
+	switch {
+	case !nonASCII:
+		return Encoding7Bit
+	case eightBit:
+		return Encoding8Bit
+	}
+	return ""
+}
+
+// encodeEntity encodes the parts of a message or body part, see
+// encodeMessage
+func encodeEntity(entity []byte, eightBit bool, depth int) ([]byte, error) {
+	if identityEncoding(entity, eightBit) != "" {
+		return entity, nil
+	}
+	if depth > maxMessageDepth {
+		return nil, fmt.Errorf("attached message nested too deeply")
+	}
+
+	header, body := splitEntity(entity)
+	for _, line := range bytes.Split(header, []byte("\r\n")) {
+		if len(line) > maxLineLength {
+			return nil, fmt.Errorf("attached message: %w", ErrLineTooLong)
+		}
+		if identityEncoding(line, eightBit) == "" {
+			return nil, ErrNeeds8BitMIME
+		}
+	}
+
+	r := io.MultiReader(bytes.NewReader(header), strings.NewReader("\r\n"))
+	fields, err := textproto.NewReader(bufio.NewReader(r)).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("malformed attached message header: %w", err)
+	}
+	mediaType, params, _ := mime.ParseMediaType(fields.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		body, err = encodeMultipart(body, params["boundary"], eightBit, depth)
+		if err != nil {
+			return nil, err
+		}
+	case mediaType == "message/rfc822":
+		body, err = encodeEntity(body, eightBit, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	case identityEncoding(body, eightBit) == "":
+		switch encoding := strings.ToLower(strings.TrimSpace(fields.Get("Content-Transfer-Encoding"))); encoding {
+		case "", Encoding7Bit, Encoding8Bit, "binary":
+		default:
+			return nil, fmt.Errorf("malformed %s part in attached message", encoding)
+		}
+
+		encoding := EncodingBase64
+		if strings.HasPrefix(mediaType, "text/") {
+			encoding = chooseTextEncoding(body, false)
+		}
+		var buf bytes.Buffer
+		enc := newEncoder(&buf, encoding)
+		if _, err := enc.Write(body); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		header = setField(header, "Content-Transfer-Encoding", encoding)
+		body = buf.Bytes()
+	}
+
+	var out bytes.Buffer
+	out.Write(header)
+	out.WriteString("\r\n")
+	out.Write(body)
+	return out.Bytes(), nil
+}
+
+// splitEntity splits a message or body part into its header, including
+// the CRLF ending the last field, and its body
+func splitEntity(entity []byte) (header, body []byte) {
+	if bytes.HasPrefix(entity, []byte("\r\n")) {
+		return nil, entity[2:]
+	}
+	end := bytes.Index(entity, []byte("\r\n\r\n"))
+	if end < 0 {
+		return entity, nil
+	}
+	return entity[:end+2], entity[end+4:]
+}
+
+// encodeMultipart encodes each body part of a multipart body, keeping the
+// delimiters, the preamble and the epilogue as they are
+func encodeMultipart(body []byte, boundary string, eightBit bool, depth int) ([]byte, error) {
+	delimiter := []byte("--" + boundary)
+
+	// Delimiters are only recognized at the start of a line and may be
+	// followed by "--" and transport padding
+	var starts []int
+	for i := 0; i < len(body); {
+		if rest := body[i:]; bytes.HasPrefix(rest, delimiter) {
+			if len(rest) == len(delimiter) || bytes.IndexByte([]byte("-\r \t"), rest[len(delimiter)]) >= 0 {
+				starts = append(starts, i)
+			}
+		}
+		next := bytes.IndexByte(body[i:], '\n')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	if len(starts) == 0 {
+		return body, nil
+	}
+	var out bytes.Buffer
+	out.Write(body[:starts[0]])
+
+	for k, start := range starts {
+		lineEnd := bytes.IndexByte(body[start:], '\n')
+		if lineEnd < 0 {
+			out.Write(body[start:])
+			break
+		}
+		lineEnd += start + 1
+		out.Write(body[start:lineEnd])
+
+		if bytes.HasPrefix(body[start+len(delimiter):], []byte("--")) {
+			// The close delimiter, followed by the epilogue
+			out.Write(body[lineEnd:])
+			break
+		}
+
+		// The CRLF before the next delimiter belongs to the delimiter
+		end, next := len(body), len(body)
+		if k+1 < len(starts) {
+			next = starts[k+1]
+			end = max(next-2, lineEnd)
+		}
+		part, err := encodeEntity(body[lineEnd:end], eightBit, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(part)
+		out.Write(body[end:next])
+	}
+	return out.Bytes(), nil
+}
+
+// setField replaces every field named key in header, with its folded
+// continuation lines, by a single "key: value" field at the end
+func setField(header []byte, key, value string) []byte {
+	var out bytes.Buffer
+	skipping := false
+	for _, line := range bytes.SplitAfter(header, []byte("\r\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if !skipping {
+				out.Write(line)
+			}
+			continue
+		}
+		name, _, _ := bytes.Cut(line, []byte(":"))
+		skipping = strings.EqualFold(strings.TrimSpace(string(name)), key)
+		if !skipping {
+			out.Write(line)
+		}
+	}
+	out.WriteString(key + ": " + value + "\r\n")
+	return out.Bytes()
+}
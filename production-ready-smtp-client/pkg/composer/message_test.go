@@ -0,0 +1,159 @@
+package composer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+const (
+	asciiMessage = "From: a@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+	eightBitBody = "From: a@example.com\r\nSubject: Hi\r\nContent-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n\r\nGrüße aus Köln\r\n"
+	eightBitHeader = "From: a@example.com\r\nSubject: Grüße\r\n\r\nHello\r\n"
+)
+
+func TestEncodeMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		eightBit bool
+		want     string
+		encoding string
+		err      error
+	}{
+		{"ASCII", asciiMessage, false, asciiMessage, Encoding7Bit, nil},
+		{"8-bit body with 8BITMIME", eightBitBody, true, eightBitBody, Encoding8Bit, nil},
+		{
+			"8-bit body without 8BITMIME",
+			eightBitBody,
+			false,
+			"From: a@example.com\r\nSubject: Hi\r\nContent-Type: text/plain; charset=UTF-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n\r\nGr=C3=BC=C3=9Fe aus K=C3=B6ln\r\n",
+			Encoding7Bit,
+			nil,
+		},
+		{
+			"folded encoding field",
+			"Content-Transfer-Encoding:\r\n 8bit\r\nSubject: Hi\r\n\r\n\xff\xfe\x00\x01",
+			false,
+			"Subject: Hi\r\nContent-Transfer-Encoding: base64\r\n\r\n//4AAQ==",
+			Encoding7Bit,
+			nil,
+		},
+		{
+			"long line",
+			"Subject: Hi\r\n\r\n" + strings.Repeat("a", 1200),
+			false,
+			"Subject: Hi\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+				strings.TrimSuffix(qpEncode(strings.Repeat("a", 1200)), "\r\n"),
+			Encoding7Bit,
+			nil,
+		},
+		{"8-bit header with 8BITMIME", eightBitHeader, true, eightBitHeader, Encoding8Bit, nil},
+		{"8-bit header without 8BITMIME", eightBitHeader, false, "", "", ErrNeeds8BitMIME},
+		{"long header line", "Subject: " + strings.Repeat("a", 1000) + "\r\n\r\nbody\r\n", false, "", "", ErrLineTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, encoding, err := encodeMessage([]byte(tt.raw), tt.eightBit)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want || encoding != tt.encoding {
+				t.Errorf("encodeMessage = %s\n%q\nwant %s\n%q", encoding, got, tt.encoding, tt.want)
+			}
+		})
+	}
+}
+
+func qpEncode(s string) string {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	io.WriteString(w, s)
+	w.Close()
+	return buf.String()
+}
+
+func TestEncodeMessageMultipart(t *testing.T) {
+	raw := "From: a@example.com\r\n" +
+		"Subject: Report\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"This is the preamble.\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"Grüße from the team\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"plain ASCII part\r\n" +
+		"--b1\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"Subject: Inner\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Transfer-Encoding: binary\r\n" +
+		"\r\n" +
+		"\x00\x01\x02\xff\r\n" +
+		"--b1--\r\n" +
+		"Epilogue.\r\n"
+
+	got, encoding, err := encodeMessage([]byte(raw), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding != Encoding7Bit {
+		t.Errorf("encoding = %s, want 7bit", encoding)
+	}
+
+	// Everything but the two 8-bit parts is unchanged
+	for _, kept := range []string{
+		"From: a@example.com\r\nSubject: Report\r\nContent-Type: multipart/mixed; boundary=\"b1\"\r\n\r\nThis is the preamble.\r\n--b1\r\n",
+		"--b1\r\nContent-Type: text/plain\r\n\r\nplain ASCII part\r\n--b1\r\n",
+		"--b1\r\nContent-Type: message/rfc822\r\n\r\nSubject: Inner\r\nContent-Type: application/octet-stream\r\n",
+		"--b1--\r\nEpilogue.\r\n",
+	} {
+		if !strings.Contains(string(got), kept) {
+			t.Errorf("output lost %q:\n%s", kept, got)
+		}
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(msg.Body, "b1")
+	var bodies []string
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Transfer-Encoding")+"|"+string(data))
+	}
+	want := []string{
+		"quoted-printable|Gr=C3=BC=C3=9Fe from the team",
+		"|plain ASCII part",
+		"|Subject: Inner\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\nAAEC/w==",
+	}
+	if strings.Join(bodies, "\n") != strings.Join(want, "\n") {
+		t.Errorf("parts =\n%q\nwant\n%q", bodies, want)
+	}
+}
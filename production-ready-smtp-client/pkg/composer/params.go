@@ -0,0 +1,121 @@
+package composer
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// paramChunkLength is the longest value written in one RFC 2231
+// continuation, so that every parameter fits on a folded header line
+const paramChunkLength = 60
+
+// formatMediaType formats a Content-Type or Content-Disposition value like
+// mime.FormatMediaType, except that long and non-ASCII parameter values
+// are split into RFC 2231 continuations, which mime.FormatMediaType does
+// not do. The name parameter of Content-Type is an exception: clients that
+// predate RFC 2231, Outlook among them, only read it RFC 2047 encoded.
+func formatMediaType(mediaType string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(mediaType)
+	for _, key := range keys {
+		value := params[key]
+		switch {
+		case isASCIIPrintable(value) && len(value) <= paramChunkLength:
+			b.WriteString("; " + key + "=" + quoteParam(value))
+		case key == "name" && !isASCIIPrintable(value):
+			b.WriteString("; " + key + "=" + quoteParam(mime.BEncoding.Encode("UTF-8", value)))
+		default:
+			writeContinuations(&b, key, value)
+		}
+	}
+	return b.String()
+}
+
+// writeContinuations writes value as key*0, key*1, ... Each chunk holds
+// whole characters so that decoders that decode each chunk separately
+// still get valid UTF-8.
+func writeContinuations(b *strings.Builder, key, value string) {
+	extended := !isASCIIPrintable(value)
+	for i, chunk := range splitParam(value, extended) {
+		name := key + "*" + strconv.Itoa(i)
+		switch {
+		case !extended:
+			b.WriteString("; " + name + "=" + quoteParam(chunk))
+		case i == 0:
+			b.WriteString("; " + name + "*=UTF-8''" + chunk)
+		default:
+			b.WriteString("; " + name + "*=" + chunk)
+		}
+	}
+}
+
+// splitParam splits value into chunks of at most paramChunkLength
+// characters, percent-encoding them if extended
+func splitParam(value string, extended bool) []string {
+	var chunks []string
+	var chunk strings.Builder
+	for _, r := range value {
+		encoded := string(r)
+		if extended {
+			encoded = percentEncode(encoded)
+		}
+		if chunk.Len() > 0 && chunk.Len()+len(encoded) > paramChunkLength {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+		}
+		chunk.WriteString(encoded)
+	}
+	return append(chunks, chunk.String())
+}
+
+// percentEncode encodes every octet of s that is not an attribute-char of
+// RFC 2231
+func percentEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttributeChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xF])
+	}
+	return b.String()
+}
+
+func isAttributeChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// quoteParam returns value as a token, or as a quoted-string if it has
+// characters a token can't
+func quoteParam(value string) string {
+	if value != "" && !strings.ContainsAny(value, "()<>@,;:\\\"/[]?= \t") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func isASCIIPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package composer
+
+import (
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFormatMediaType(t *testing.T) {
+	longASCII := strings.Repeat("quarterly-report-", 5) + "final.pdf"
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   string
+	}{
+		{"token", map[string]string{"filename": "report.pdf"}, `attachment; filename=report.pdf`},
+		{"quoted", map[string]string{"filename": `my "best" report.pdf`}, `attachment; filename="my \"best\" report.pdf"`},
+		{"empty", map[string]string{"filename": ""}, `attachment; filename=""`},
+		{"sorted", map[string]string{"size": "10", "filename": "a.txt"}, `attachment; filename=a.txt; size=10`},
+		{
+			"long ASCII",
+			map[string]string{"filename": longASCII},
+			`attachment; filename*0=` + longASCII[:60] + `; filename*1=` + longASCII[60:],
+		},
+		{
+			"non-ASCII",
+			map[string]string{"filename": "Größe.pdf"},
+			`attachment; filename*0*=UTF-8''Gr%C3%B6%C3%9Fe.pdf`,
+		},
+		{
+			"non-ASCII name",
+			map[string]string{"name": "Größe.pdf"},
+			`attachment; name="=?UTF-8?b?R3LDtsOfZS5wZGY=?="`,
+		},
+		{"long ASCII name", map[string]string{"name": longASCII}, `attachment; name*0=` + longASCII[:60] + `; name*1=` + longASCII[60:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatMediaType("attachment", tt.params); got != tt.want {
+				t.Errorf("formatMediaType =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMediaTypeRoundTrip(t *testing.T) {
+	names := []string{
+		"report.pdf",
+		"Quarterly report; final (v2).pdf",
+		strings.Repeat("a", 200) + ".txt",
+		"Größe.pdf",
+		"日本語のファイル名.txt",
+		strings.Repeat("ü", 100) + ".csv",
+		"mixed " + strings.Repeat("日本", 40) + " & 100%.xlsx",
+	}
+	for _, name := range names {
+		value := formatMediaType("attachment", map[string]string{"filename": name})
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err != nil {
+			t.Errorf("%q: ParseMediaType(%q): %v", name, value, err)
+			continue
+		}
+		if mediaType != "attachment" || params["filename"] != name {
+			t.Errorf("%q: parsed %q %q", name, mediaType, params["filename"])
+		}
+	}
+}
+
+func TestFormatMediaTypeChunks(t *testing.T) {
+	name := strings.Repeat("日本", 40) + ".txt"
+	value := formatMediaType("attachment", map[string]string{"filename": name})
+
+	chunks := regexp.MustCompile(`filename\*\d+\*=(?:UTF-8'')?([^;]*)`).FindAllStringSubmatch(value, -1)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d continuations: %s", len(chunks), value)
+	}
+	for i, chunk := range chunks {
+		if len(chunk[1]) > paramChunkLength {
+			t.Errorf("chunk %d is %d long", i, len(chunk[1]))
+		}
+		// Every chunk must decode on its own
+		decoded, err := url.PathUnescape(chunk[1])
+		if err != nil || !utf8.ValidString(decoded) {
+			t.Errorf("chunk %d %q does not decode to whole characters", i, chunk[1])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package composer
+
+import (
+	"bytes"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// part is one node of the MIME tree. Leaf parts carry content, multipart
+// parts carry children.
+type part struct {
+	mediaType   string
+	params      map[string]string
+	encoding    string
+	disposition string
+	filename    string
+	contentID   string
+
+	content []byte
+	// open streams the content instead, so large attachments never have
+	// to be held in memory
+	open func() (io.ReadCloser, error)
+
+	boundary string
+	children []*part
+}
+
+// newTextPart returns a UTF-8 text part. eightBit allows the 8bit
+// encoding, see chooseTextEncoding.
+func newTextPart(mediaType, text string, eightBit bool) *part {
+	content := normalizeNewlines([]byte(text))
+	return &part{
+		mediaType: mediaType,
+		params:    map[string]string{"charset": "UTF-8"},
+		encoding:  chooseTextEncoding(content, eightBit),
+		content:   content,
+	}
+}
+
+func newAttachmentPart(att domain.Attachment) *part {
+	mediaType := att.ContentType
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+
+	return &part{
+		mediaType:   mediaType,
+		params:      map[string]string{"name": att.Filename},
+		encoding:    EncodingBase64,
+		disposition: "attachment",
+		filename:    att.Filename,
+		open:        att.Open,
+	}
+}
+
+func newEmbeddedPart(att domain.Attachment) *part {
+	p := newAttachmentPart(att)
+	p.disposition = "inline"
+	p.contentID = att.ContentID
+	return p
+}
+
+func newMultipart(subtype, boundary string, children []*part) *part {
+	return &part{
+		mediaType: "multipart/" + subtype,
+		params:    map[string]string{"boundary": boundary},
+		boundary:  boundary,
+		children:  children,
+	}
+}
+
+// header returns the content headers of the part
+func (p *part) header() textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", formatMediaType(p.mediaType, p.params))
+	if p.encoding != "" {
+		header.Set("Content-Transfer-Encoding", p.encoding)
+	}
+	if p.disposition != "" {
+		var params map[string]string
+		if p.filename != "" {
+			params = map[string]string{"filename": p.filename}
+		}
+		header.Set("Content-Disposition", formatMediaType(p.disposition, params))
+	}
+	if p.contentID != "" {
+		// Assigned directly to keep the conventional spelling, which
+		// textproto would canonicalize to Content-Id
+		header["Content-ID"] = []string{"<" + p.contentID + ">"}
+	}
+	return header
+}
+
+// writeHeader writes the content headers of a top-level part, in the
+// conventional order rather than the sorted order of multipart.Writer
+func (p *part) writeHeader(hw *headerWriter) {
+	header := p.header()
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-ID"} {
+		if values := header[key]; len(values) > 0 {
+			hw.field(key, values[0])
+		}
+	}
+}
+
+// endsWithNewline reports whether the encoded content ends in a CRLF
+func (p *part) endsWithNewline() bool {
+	if p.children != nil {
+		// multipart.Writer ends the closing delimiter with CRLF
+		return true
+	}
+	return p.encoding != EncodingBase64 && bytes.HasSuffix(p.content, []byte("\r\n"))
+}
+
+// writeBody writes the encoded content of the part, or its children
+// separated by its boundary
+func (p *part) writeBody(w io.Writer) error {
+	if p.children == nil {
+		enc := newEncoder(w, p.encoding)
+		if err := p.writeContent(enc); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(p.boundary); err != nil {
+		return err
+	}
+
+	for _, child := range p.children {
+		// multipart.Writer writes header values as they are, so they are
+		// folded here like those of the message header
+		header := child.header()
+		for key, values := range header {
+			for i, value := range values {
+				values[i] = strings.TrimPrefix(fold(key+": "+value), key+": ")
+			}
+		}
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if err := child.writeBody(pw); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+func (p *part) writeContent(w io.Writer) error {
+	if p.open == nil {
+		_, err := w.Write(p.content)
+		return err
+	}
+
+	r, err := p.open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", p.filename, err)
+	}
+	defer r.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to read %s: %w", p.filename, err)
+	}
+	return nil
+}
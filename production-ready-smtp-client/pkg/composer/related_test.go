@@ -0,0 +1,235 @@
+package composer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+// entity is a parsed MIME entity with its content decoded
+type entity struct {
+	header    textproto.MIMEHeader
+	mediaType string
+	params    map[string]string
+	content   []byte
+	children  []*entity
+}
+
+func parseEntity(t *testing.T, header textproto.MIMEHeader, body io.Reader) *entity {
+	t.Helper()
+	e := &entity{header: header}
+	var err error
+	e.mediaType, e.params, err = mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type %q: %v", header.Get("Content-Type"), err)
+	}
+
+	if strings.HasPrefix(e.mediaType, "multipart/") {
+		mr := multipart.NewReader(body, e.params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return e
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.children = append(e.children, parseEntity(t, p.Header, p))
+		}
+	}
+
+	switch header.Get("Content-Transfer-Encoding") {
+	case EncodingBase64:
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case EncodingQuotedPrintable:
+		body = quotedprintable.NewReader(body)
+	}
+	if e.content, err = io.ReadAll(body); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func composeEntity(t *testing.T, email *domain.Email) *entity {
+	t.Helper()
+	raw, err := New(Deterministic()).Bytes(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseEntity(t, textproto.MIMEHeader(msg.Header), msg.Body)
+}
+
+// find returns the entities of mediaType in e, depth first
+func (e *entity) find(mediaType string) []*entity {
+	var found []*entity
+	if e.mediaType == mediaType {
+		found = append(found, e)
+	}
+	for _, child := range e.children {
+		found = append(found, child.find(mediaType)...)
+	}
+	return found
+}
+
+func (e *entity) countContentIDs() int {
+	n := 0
+	if e.header.Get("Content-ID") != "" {
+		n++
+	}
+	for _, child := range e.children {
+		n += child.countContentIDs()
+	}
+	return n
+}
+
+var cidReference = regexp.MustCompile(`cid:([^"'\s>)]+)`)
+
+func TestEmbedRelatedLayout(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01")
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+	tests := []struct {
+		name  string
+		build func(b *domain.EmailBuilder) *domain.EmailBuilder
+		// root is the type of the top-level entity
+		root string
+	}{
+		{
+			name: "html only",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				return b.HTMLBody(`<img src="cid:logo@example.com"><img src='cid:banner'>`).
+					Embed("logo@example.com", "logo.png", png).
+					Embed("banner", "banner.gif", gif)
+			},
+			root: "multipart/related",
+		},
+		{
+			name: "with text and attachment",
+			build: func(b *domain.EmailBuilder) *domain.EmailBuilder {
+				return b.TextBody("See the logo.\n").
+					HTMLBody(`<p style="background: url(cid:banner)"><img src="cid:logo@example.com"></p>`).
+					Embed("logo@example.com", "logo.png", png).
+					Embed("banner", "banner.gif", gif).
+					Attach("report.pdf", "application/pdf", []byte("%PDF-1.4\n"))
+			},
+			root: "multipart/mixed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := tt.build(domain.NewEmailBuilder().
+				From("from@example.com").
+				To("to@example.org").
+				Subject("Logo")).
+				Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := composeEntity(t, email)
+			if root.mediaType != tt.root {
+				t.Errorf("root is %s, want %s", root.mediaType, tt.root)
+			}
+
+			related := root.find("multipart/related")
+			if len(related) != 1 {
+				t.Fatalf("%d multipart/related entities, want 1", len(related))
+			}
+			rel := related[0]
+			if rel.params["type"] != "text/html" {
+				t.Errorf("related type parameter = %q, want text/html", rel.params["type"])
+			}
+			if len(rel.children) != 3 {
+				t.Fatalf("related has %d parts, want 3", len(rel.children))
+			}
+
+			// RFC 2387: the root, the HTML, comes first
+			html := rel.children[0]
+			if html.mediaType != "text/html" {
+				t.Fatalf("first related part is %s, want text/html", html.mediaType)
+			}
+
+			contentIDs := make(map[string]*entity)
+			for _, part := range rel.children[1:] {
+				id := part.header.Get("Content-ID")
+				if !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, ">") {
+					t.Errorf("Content-ID %q is not in angle brackets", id)
+				}
+				contentIDs[strings.Trim(id, "<>")] = part
+
+				disposition, params, err := mime.ParseMediaType(part.header.Get("Content-Disposition"))
+				if err != nil || disposition != "inline" {
+					t.Errorf("Content-Disposition = %q, want inline", part.header.Get("Content-Disposition"))
+				}
+				if params["filename"] == "" || part.params["name"] != params["filename"] {
+					t.Errorf("filename = %q, name = %q", params["filename"], part.params["name"])
+				}
+			}
+
+			// Every cid: reference resolves to a part of the same
+			// multipart/related, and every part is referenced
+			refs := cidReference.FindAllStringSubmatch(string(html.content), -1)
+			if len(refs) != len(contentIDs) {
+				t.Errorf("%d cid references for %d embedded parts", len(refs), len(contentIDs))
+			}
+			for _, ref := range refs {
+				if _, ok := contentIDs[ref[1]]; !ok {
+					t.Errorf("cid:%s has no part with Content-ID <%s>", ref[1], ref[1])
+				}
+			}
+
+			for id, want := range map[string]struct {
+				mediaType string
+				content   []byte
+			}{
+				"logo@example.com": {"image/png", png},
+				"banner":           {"image/gif", gif},
+			} {
+				part := contentIDs[id]
+				if part == nil {
+					t.Errorf("no part with Content-ID <%s>", id)
+					continue
+				}
+				if part.mediaType != want.mediaType {
+					t.Errorf("<%s> is %s, want %s", id, part.mediaType, want.mediaType)
+				}
+				if !bytes.Equal(part.content, want.content) {
+					t.Errorf("<%s> content = %q, want %q", id, part.content, want.content)
+				}
+			}
+
+			// Embedded parts appear only inside the multipart/related
+			if n := root.countContentIDs(); n != len(contentIDs) {
+				t.Errorf("%d parts with a Content-ID, want %d", n, len(contentIDs))
+			}
+		})
+	}
+}
+
+func TestNoRelatedWithoutEmbeds(t *testing.T) {
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Logo").
+		HTMLBody("<p>No images</p>").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if related := composeEntity(t, email).find("multipart/related"); len(related) != 0 {
+		t.Errorf("%d multipart/related entities without embedded parts", len(related))
+	}
+}
@@ -0,0 +1,128 @@
+package dkim
+
+import (
+	"bytes"
+	"strings"
+)
+
+// field is one header field as it appears in the message, with its
+// folding and the final CRLF
+type field struct {
+	name string
+	raw  string
+}
+
+// value returns the unfolded value of the field
+func (f field) value() string {
+	value := f.raw[strings.IndexByte(f.raw, ':')+1:]
+	return strings.TrimSpace(strings.ReplaceAll(value, "\r\n", ""))
+}
+
+// splitMessage splits a message with CRLF line endings at the empty line
+// that ends the header. The header keeps the CRLF of its last field.
+func splitMessage(message []byte) (header, body []byte) {
+	if bytes.HasPrefix(message, []byte("\r\n")) {
+		return nil, message[2:]
+	}
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		return message[:i+2], message[i+4:]
+	}
+	return message, nil
+}
+
+// parseHeader splits a header into fields, continuation lines included
+func parseHeader(header []byte) []field {
+	var fields []field
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, field{name: strings.TrimSpace(name), raw: line})
+	}
+	return fields
+}
+
+// canonicalHeaders returns the canonical form of the signed header
+// fields. Each name of signed selects the last instance of that header
+// not selected yet, so that repeated names are signed bottom up; names
+// without a remaining instance contribute nothing.
+func canonicalHeaders(fields []field, signed []string, c Canonicalization) []byte {
+	used := make([]bool, len(fields))
+	var b bytes.Buffer
+	for _, name := range signed {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fields[i].name, name) {
+				continue
+			}
+			used[i] = true
+			b.WriteString(canonicalHeader(strings.TrimSuffix(fields[i].raw, "\r\n"), c))
+			b.WriteString("\r\n")
+			break
+		}
+	}
+	return b.Bytes()
+}
+
+// canonicalHeader canonicalizes one header field without its final CRLF
+func canonicalHeader(raw string, c Canonicalization) string {
+	if c == Simple {
+		return raw
+	}
+	// Relaxed (RFC 6376 3.4.2): lowercase name, unfolded value with runs
+	// of whitespace reduced to one space and none around the colon
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(compressSpace(value))
+}
+
+// canonicalBody canonicalizes a body with CRLF line endings
+func canonicalBody(body []byte, c Canonicalization) []byte {
+	if c == Relaxed {
+		// RFC 6376 3.4.4: whitespace at line ends is removed and runs of
+		// it reduced to one space
+		lines := strings.Split(string(body), "\r\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(compressSpace(line), " ")
+		}
+		body = []byte(strings.Join(lines, "\r\n"))
+	}
+
+	// Both forms drop empty lines at the end and end in a single CRLF.
+	// An empty body is a single CRLF in simple and nothing in relaxed.
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) == 0 {
+		if c == Simple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+	return append(body, "\r\n"...)
+}
+
+// compressSpace replaces every run of spaces and tabs with one space
+func compressSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(s[i])
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
@@ -0,0 +1,342 @@
+// Package dkim signs composed messages with DKIM (RFC 6376) and verifies
+// the signatures. Both rsa-sha256 and ed25519-sha256 (RFC 8463) are
+// supported, with simple or relaxed canonicalization.
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Canonicalization is how a message is normalized before hashing.
+// Relaxed survives the whitespace and header folding changes that relays
+// make; simple tolerates almost none.
+type Canonicalization string
+
+const (
+	Simple  Canonicalization = "simple"
+	Relaxed Canonicalization = "relaxed"
+)
+
+// minRSABits is the smallest RSA key RFC 8301 allows
+const minRSABits = 1024
+
+// foldWidth is the line length DKIM-Signature headers are folded at
+const foldWidth = 78
+
+// DefaultHeaders are the headers signed when SignerConfig.Headers is
+// empty: those that a receiver shows or acts on, including the one-click
+// unsubscribe headers, which RFC 8058 requires to be signed
+var DefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc",
+	"Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// Key signs the mail of one domain
+type Key struct {
+	// Domain is the d= tag. It signs mail from the domain and from its
+	// subdomains.
+	Domain string
+	// Selector is the s= tag, under which the public key is published at
+	// <selector>._domainkey.<domain>
+	Selector string
+	// PrivateKey is an *rsa.PrivateKey or an ed25519.PrivateKey, see
+	// ParsePrivateKey
+	PrivateKey crypto.Signer
+}
+
+type SignerConfig struct {
+	// Keys are chosen by the From domain. Every key of the most specific
+	// matching domain signs, so a domain with an RSA and an Ed25519 key
+	// gets both signatures, as RFC 8463 recommends.
+	Keys []Key
+	// Headers are signed in every instance they occur in,
+	// DefaultHeaders if empty. From is always signed.
+	Headers []string
+	// HeaderCanonicalization and BodyCanonicalization are Relaxed by
+	// default
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+	// Expiration sets the x= tag that far after signing, none if zero
+	Expiration time.Duration
+	// Now is the clock for the t= tag, time.Now by default
+	Now func() time.Time
+}
+
+// Signer adds DKIM-Signature headers to composed messages
+type Signer struct {
+	config *SignerConfig
+}
+
+func NewSigner(config *SignerConfig) (*Signer, error) {
+	cfg := *config
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+	cfg.Keys = append([]Key(nil), cfg.Keys...)
+	for i, key := range cfg.Keys {
+		if key.Domain == "" || key.Selector == "" {
+			return nil, errors.New("keys need a domain and a selector")
+		}
+		if _, err := algorithm(key.PrivateKey); err != nil {
+			return nil, fmt.Errorf("key for %s: %w", key.Domain, err)
+		}
+		cfg.Keys[i].Domain = strings.ToLower(strings.TrimSuffix(key.Domain, "."))
+	}
+
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = DefaultHeaders
+	}
+	hasFrom := false
+	for _, name := range cfg.Headers {
+		if strings.EqualFold(name, "From") {
+			hasFrom = true
+		}
+		if strings.ContainsAny(name, ":; \t") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	if !hasFrom {
+		cfg.Headers = append([]string{"From"}, cfg.Headers...)
+	}
+
+	for _, c := range []*Canonicalization{&cfg.HeaderCanonicalization, &cfg.BodyCanonicalization} {
+		switch *c {
+		case "":
+			*c = Relaxed
+		case Simple, Relaxed:
+		default:
+			return nil, fmt.Errorf("unknown canonicalization %q", *c)
+		}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	return &Signer{config: &cfg}, nil
+}
+
+// Sign returns message with a DKIM-Signature header added for each key of
+// its From domain. Messages from a domain without keys are returned as
+// they are. message must not be changed after signing: relays that
+// convert 8bit content to quoted-printable break the signature, so it is
+// best composed without 8BITMIME.
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	message = normalizeLineEndings(message)
+	header, body := splitMessage(message)
+	fields := parseHeader(header)
+
+	keys, err := s.keysFor(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return message, nil
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body, s.config.BodyCanonicalization))
+	signed := s.signedHeaders(fields)
+
+	var signatures []byte
+	for _, key := range keys {
+		signature, err := s.signature(key, fields, signed, bodyHash[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign for %s: %w", key.Domain, err)
+		}
+		signatures = append(signatures, signature...)
+	}
+	return append(signatures, message...), nil
+}
+
+// keysFor returns the keys of the most specific domain that the From
+// domain is or is a subdomain of
+func (s *Signer) keysFor(fields []field) ([]Key, error) {
+	from := ""
+	for _, f := range fields {
+		if strings.EqualFold(f.name, "From") {
+			from = f.value()
+			break
+		}
+	}
+	if from == "" {
+		return nil, errors.New("message has no From header")
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %w", err)
+	}
+
+	domain := strings.ToLower(addr.Address[strings.LastIndexByte(addr.Address, '@')+1:])
+	for {
+		var keys []Key
+		for _, key := range s.config.Keys {
+			if key.Domain == domain {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			return keys, nil
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return nil, nil
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// signedHeaders returns the h= list: each configured header once for
+// every instance of it in the message
+func (s *Signer) signedHeaders(fields []field) []string {
+	var names []string
+	for _, name := range s.config.Headers {
+		for _, f := range fields {
+			if strings.EqualFold(f.name, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// signature returns the complete DKIM-Signature header for key
+func (s *Signer) signature(key Key, fields []field, signed []string, bodyHash []byte) ([]byte, error) {
+	alg, _ := algorithm(key.PrivateKey)
+	now := s.config.Now()
+
+	tags := []string{
+		"v=1",
+		"a=" + alg,
+		"c=" + string(s.config.HeaderCanonicalization) + "/" + string(s.config.BodyCanonicalization),
+		"d=" + key.Domain,
+		"s=" + key.Selector,
+		"t=" + strconv.FormatInt(now.Unix(), 10),
+	}
+	if s.config.Expiration > 0 {
+		tags = append(tags, "x="+strconv.FormatInt(now.Add(s.config.Expiration).Unix(), 10))
+	}
+	tags = append(tags,
+		"h="+strings.Join(signed, ":"),
+		"bh="+base64.StdEncoding.EncodeToString(bodyHash),
+	)
+
+	// The header is hashed with an empty b= and the signature then
+	// appended after it, as verifiers remove it again
+	unsigned := foldTags(tags)
+	data := canonicalHeaders(fields, signed, s.config.HeaderCanonicalization)
+	data = append(data, canonicalHeader(unsigned, s.config.HeaderCanonicalization)...)
+	hash := sha256.Sum256(data)
+
+	var sig []byte
+	var err error
+	switch key.PrivateKey.(type) {
+	case ed25519.PrivateKey:
+		// RFC 8463 signs the SHA-256 hash with pure Ed25519
+		sig, err = key.PrivateKey.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	default:
+		sig, err = key.PrivateKey.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(unsigned + foldValue(base64.StdEncoding.EncodeToString(sig), lastLineLength(unsigned)) + "\r\n"), nil
+}
+
+// algorithm returns the a= tag for key
+func algorithm(key crypto.Signer) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return "", fmt.Errorf("RSA keys need at least %d bits", minRSABits)
+		}
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	case nil:
+		return "", errors.New("no private key")
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// foldTags writes the DKIM-Signature header up to and including the
+// empty "b=", folding between tags. The h= list is folded at its colons.
+func foldTags(tags []string) string {
+	var b strings.Builder
+	b.WriteString("DKIM-Signature:")
+	line := b.Len()
+	write := func(s string, space bool) {
+		switch {
+		case line+1+len(s) > foldWidth:
+			b.WriteString("\r\n ")
+			line = 1
+		case space:
+			b.WriteString(" ")
+			line++
+		}
+		b.WriteString(s)
+		line += len(s)
+	}
+
+	for _, tag := range append(tags, "b=") {
+		if tag != "b=" {
+			tag += ";"
+		}
+		if !strings.HasPrefix(tag, "h=") {
+			write(tag, true)
+			continue
+		}
+		names := strings.Split(tag, ":")
+		for i, name := range names {
+			if i < len(names)-1 {
+				name += ":"
+			}
+			write(name, i == 0)
+		}
+	}
+	return b.String()
+}
+
+// foldValue splits a base64 value into lines that fit after a line of
+// length used
+func foldValue(value string, used int) string {
+	var b strings.Builder
+	for value != "" {
+		n := min(len(value), max(foldWidth-used, 1))
+		b.WriteString(value[:n])
+		value = value[n:]
+		if value != "" {
+			b.WriteString("\r\n ")
+			used = 1
+		}
+	}
+	return b.String()
+}
+
+func lastLineLength(s string) int {
+	return len(s) - strings.LastIndex(s, "\n") - 1
+}
+
+// normalizeLineEndings turns bare LFs into CRLFs, the line ending DKIM
+// hashes
+func normalizeLineEndings(message []byte) []byte {
+	if !bytes.Contains(bytes.ReplaceAll(message, []byte("\r\n"), nil), []byte("\n")) {
+		return message
+	}
+	message = bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(message, []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,303 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: alice@example.org\r\n" +
+	"Subject: Quarterly  report\r\n" +
+	"Date: Tue, 02 Jan 2024 15:04:05 +0000\r\n" +
+	"Message-ID: <1.abc@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"Hello Alice,\r\n" +
+	"\r\n" +
+	"The report is ready.\r\n"
+
+var (
+	rsaKeyOnce sync.Once
+	rsaKey     *rsa.PrivateKey
+)
+
+// testRSAKey generates one key for the whole package, as 2048-bit keys
+// take a while
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	rsaKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsaKey = key
+	})
+	if rsaKey == nil {
+		t.Fatal("no RSA key")
+	}
+	return rsaKey
+}
+
+func testEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// keyLookup is a KeyLookup over the keys given, published and parsed
+// again the way DNS records are
+func keyLookup(t *testing.T, keys ...Key) KeyLookup {
+	t.Helper()
+	records := make(map[string]string)
+	for _, key := range keys {
+		record, err := Record(key.PrivateKey.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[key.Selector+"._domainkey."+key.Domain] = record
+	}
+	return func(domain, selector string) (crypto.PublicKey, error) {
+		record, ok := records[selector+"._domainkey."+domain]
+		if !ok {
+			return nil, fmt.Errorf("no record for %s._domainkey.%s", selector, domain)
+		}
+		return ParseRecord(record)
+	}
+}
+
+func sign(t *testing.T, config *SignerConfig, message string) string {
+	t.Helper()
+	signer, err := NewSigner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestSignVerify(t *testing.T) {
+	keys := map[string]Key{
+		"rsa":     {Domain: "example.com", Selector: "rsa", PrivateKey: testRSAKey(t)},
+		"ed25519": {Domain: "example.com", Selector: "ed", PrivateKey: testEd25519Key(t)},
+	}
+	canonicalizations := []Canonicalization{Simple, Relaxed}
+
+	for keyName, key := range keys {
+		for _, headerC := range canonicalizations {
+			for _, bodyC := range canonicalizations {
+				name := fmt.Sprintf("%s %s/%s", keyName, headerC, bodyC)
+				t.Run(name, func(t *testing.T) {
+					signed := sign(t, &SignerConfig{
+						Keys:                   []Key{key},
+						HeaderCanonicalization: headerC,
+						BodyCanonicalization:   bodyC,
+					}, testMessage)
+					if !strings.HasSuffix(signed, testMessage) {
+						t.Fatal("signing changed the message")
+					}
+
+					results := Verify([]byte(signed), keyLookup(t, key))
+					if len(results) != 1 {
+						t.Fatalf("got %d verifications", len(results))
+					}
+					if r := results[0]; r.Err != nil || r.Domain != "example.com" || r.Selector != key.Selector {
+						t.Errorf("verification = %+v", r)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	key := Key{Domain: "example.com", Selector: "rsa", PrivateKey: testRSAKey(t)}
+
+	tests := []struct {
+		name    string
+		tamper  func(string) string
+		relaxed error
+		simple  error
+	}{
+		{
+			name:    "body changed",
+			tamper:  func(m string) string { return strings.Replace(m, "is ready", "is late", 1) },
+			relaxed: ErrBodyHashMismatch,
+			simple:  ErrBodyHashMismatch,
+		},
+		{
+			name:    "signed header changed",
+			tamper:  func(m string) string { return strings.Replace(m, "Quarterly  report", "Urgent", 1) },
+			relaxed: ErrSignatureMismatch,
+			simple:  ErrSignatureMismatch,
+		},
+		{
+			// Verifiers take the last instance of a signed header
+			name:    "signed header added below",
+			tamper:  func(m string) string { return addHeader(m, "Subject: Urgent") },
+			relaxed: ErrSignatureMismatch,
+			simple:  ErrSignatureMismatch,
+		},
+		{
+			// Only the headers present when signing are covered
+			name:    "header absent when signing added",
+			tamper:  func(m string) string { return addHeader(m, "Cc: eve@example.net") },
+			relaxed: nil,
+			simple:  nil,
+		},
+		{
+			name:    "unsigned header added",
+			tamper:  func(m string) string { return "X-Spam-Score: 0\r\n" + m },
+			relaxed: nil,
+			simple:  nil,
+		},
+		{
+			name: "header refolded",
+			tamper: func(m string) string {
+				return strings.Replace(m, "Subject: Quarterly  report", "Subject: Quarterly\r\n  report", 1)
+			},
+			relaxed: nil,
+			simple:  ErrSignatureMismatch,
+		},
+		{
+			name:    "trailing whitespace added to body",
+			tamper:  func(m string) string { return strings.Replace(m, "Hello Alice,", "Hello Alice,  ", 1) },
+			relaxed: nil,
+			simple:  ErrBodyHashMismatch,
+		},
+		{
+			name:    "empty lines added at end of body",
+			tamper:  func(m string) string { return m + "\r\n\r\n" },
+			relaxed: nil,
+			simple:  nil,
+		},
+	}
+
+	for _, c := range []Canonicalization{Relaxed, Simple} {
+		signed := sign(t, &SignerConfig{
+			Keys:                   []Key{key},
+			HeaderCanonicalization: c,
+			BodyCanonicalization:   c,
+		}, testMessage)
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s %s", c, tt.name), func(t *testing.T) {
+				want := tt.relaxed
+				if c == Simple {
+					want = tt.simple
+				}
+				results := Verify([]byte(tt.tamper(signed)), keyLookup(t, key))
+				if len(results) != 1 {
+					t.Fatalf("got %d verifications", len(results))
+				}
+				if !errors.Is(results[0].Err, want) {
+					t.Errorf("err = %v, want %v", results[0].Err, want)
+				}
+			})
+		}
+	}
+}
+
+// addHeader adds a header field at the end of the header of message
+func addHeader(message, field string) string {
+	end := strings.Index(message, "\r\n\r\n")
+	return message[:end] + "\r\n" + field + message[end:]
+}
+
+func TestVerifyWrongKey(t *testing.T) {
+	key := Key{Domain: "example.com", Selector: "ed", PrivateKey: testEd25519Key(t)}
+	other := Key{Domain: "example.com", Selector: "ed", PrivateKey: testEd25519Key(t)}
+
+	signed := sign(t, &SignerConfig{Keys: []Key{key}}, testMessage)
+	results := Verify([]byte(signed), keyLookup(t, other))
+	if len(results) != 1 || !errors.Is(results[0].Err, ErrSignatureMismatch) {
+		t.Errorf("verifications = %+v", results)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	key := Key{Domain: "example.com", Selector: "ed", PrivateKey: testEd25519Key(t)}
+	signed := sign(t, &SignerConfig{
+		Keys:       []Key{key},
+		Expiration: time.Hour,
+		Now:        func() time.Time { return time.Now().Add(-2 * time.Hour) },
+	}, testMessage)
+
+	results := Verify([]byte(signed), keyLookup(t, key))
+	if len(results) != 1 || !errors.Is(results[0].Err, ErrSignatureExpired) {
+		t.Errorf("verifications = %+v", results)
+	}
+}
+
+func TestSignChoosesMostSpecificDomain(t *testing.T) {
+	parent := Key{Domain: "example.com", Selector: "parent", PrivateKey: testEd25519Key(t)}
+	child := Key{Domain: "mail.example.com", Selector: "child", PrivateKey: testEd25519Key(t)}
+	childRSA := Key{Domain: "mail.example.com", Selector: "child-rsa", PrivateKey: testRSAKey(t)}
+	config := &SignerConfig{Keys: []Key{parent, child, childRSA}}
+	lookup := keyLookup(t, parent, child, childRSA)
+
+	tests := []struct {
+		from      string
+		selectors []string
+	}{
+		{"sender@example.com", []string{"parent"}},
+		{"sender@EXAMPLE.com", []string{"parent"}},
+		{"sender@news.example.com", []string{"parent"}},
+		{"sender@mail.example.com", []string{"child", "child-rsa"}},
+		{"sender@news.mail.example.com", []string{"child", "child-rsa"}},
+		{"sender@example.org", nil},
+		{"sender@notexample.com", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			message := strings.Replace(testMessage, "sender@example.com", tt.from, 1)
+			signed := sign(t, config, message)
+			if tt.selectors == nil {
+				if signed != message {
+					t.Error("message from a domain without keys was signed")
+				}
+				return
+			}
+
+			results := Verify([]byte(signed), lookup)
+			var selectors []string
+			for _, r := range results {
+				if r.Err != nil {
+					t.Errorf("%s: %v", r.Selector, r.Err)
+				}
+				selectors = append(selectors, r.Selector)
+			}
+			if strings.Join(selectors, ",") != strings.Join(tt.selectors, ",") {
+				t.Errorf("signed with %v, want %v", selectors, tt.selectors)
+			}
+		})
+	}
+}
+
+func TestSignNormalizesLineEndings(t *testing.T) {
+	key := Key{Domain: "example.com", Selector: "ed", PrivateKey: testEd25519Key(t)}
+	lf := strings.ReplaceAll(testMessage, "\r\n", "\n")
+
+	signed := sign(t, &SignerConfig{Keys: []Key{key}}, lf)
+	if bytes.Contains(bytes.ReplaceAll([]byte(signed), []byte("\r\n"), nil), []byte("\n")) {
+		t.Error("signed message has bare LFs")
+	}
+	results := Verify([]byte(signed), keyLookup(t, key))
+	if len(results) != 1 || results[0].Err != nil {
+		t.Errorf("verifications = %+v", results)
+	}
+}
@@ -0,0 +1,125 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var ErrKeyRevoked = errors.New("key revoked")
+
+// ParsePrivateKey parses a PEM encoded RSA key in PKCS #1 or PKCS #8 form,
+// or an Ed25519 key in PKCS #8 form, as written by openssl genpkey
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case ed25519.PrivateKey:
+			return key, nil
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// Record returns the DNS TXT record that publishes pub, to be put at
+// <selector>._domainkey.<domain>
+func Record(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		// RFC 8463 publishes the raw key rather than a DER structure
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// ParseRecord parses the public key out of a DKIM DNS TXT record
+func ParseRecord(record string) (crypto.PublicKey, error) {
+	tags, err := parseTags(record)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, fmt.Errorf("unsupported record version %q", v)
+	}
+	p, ok := tags["p"]
+	if !ok {
+		return nil, errors.New("record has no p= tag")
+	}
+	if p == "" {
+		return nil, ErrKeyRevoked
+	}
+	data, err := base64.StdEncoding.DecodeString(stripSpace(p))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	switch k := tags["k"]; k {
+	case "", "rsa":
+		// Records hold a SubjectPublicKeyInfo, though some publish the
+		// bare RSAPublicKey
+		if pub, err := x509.ParsePKIXPublicKey(data); err == nil {
+			if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+				return rsaKey, nil
+			}
+			return nil, errors.New("k=rsa record with a non-RSA key")
+		}
+		pub, err := x509.ParsePKCS1PublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA public key: %w", err)
+		}
+		return pub, nil
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(data), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k)
+	}
+}
+
+// LookupDNS is a KeyLookup that fetches the key record from DNS
+func LookupDNS(domain, selector string) (crypto.PublicKey, error) {
+	txts, err := net.LookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		return nil, err
+	}
+	// Other TXT records may share the name
+	err = errors.New("no key record found")
+	for _, txt := range txts {
+		var pub crypto.PublicKey
+		if pub, err = ParseRecord(txt); err == nil {
+			return pub, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,214 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBodyHashMismatch  = errors.New("body hash does not match")
+	ErrSignatureMismatch = errors.New("signature does not match")
+	ErrSignatureExpired  = errors.New("signature expired")
+)
+
+// KeyLookup returns the public key published for a domain and selector.
+// LookupDNS queries DNS; tests can return a key they hold.
+type KeyLookup func(domain, selector string) (crypto.PublicKey, error)
+
+// Verification is the outcome of checking one DKIM-Signature header
+type Verification struct {
+	Domain   string
+	Selector string
+	// Err is nil if the signature is valid
+	Err error
+}
+
+// Verify checks every DKIM-Signature header of message, in the order they
+// appear. A message without signatures gives no verifications.
+func Verify(message []byte, lookup KeyLookup) []Verification {
+	message = normalizeLineEndings(message)
+	header, body := splitMessage(message)
+	fields := parseHeader(header)
+
+	var results []Verification
+	for _, f := range fields {
+		if strings.EqualFold(f.name, "DKIM-Signature") {
+			results = append(results, verifyOne(f, fields, body, lookup))
+		}
+	}
+	return results
+}
+
+func verifyOne(signature field, fields []field, body []byte, lookup KeyLookup) Verification {
+	tags, err := parseTags(signature.value())
+	if err != nil {
+		return Verification{Err: err}
+	}
+	result := Verification{Domain: tags["d"], Selector: tags["s"]}
+	result.Err = verifyTags(tags, signature, fields, body, lookup)
+	return result
+}
+
+func verifyTags(tags map[string]string, signature field, fields []field, body []byte, lookup KeyLookup) error {
+	for _, tag := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[tag]; !ok {
+			return fmt.Errorf("missing %s= tag", tag)
+		}
+	}
+	if tags["v"] != "1" {
+		return fmt.Errorf("unsupported version %q", tags["v"])
+	}
+
+	headerC, bodyC, err := parseCanonicalization(tags["c"])
+	if err != nil {
+		return err
+	}
+
+	var signed []string
+	hasFrom := false
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.TrimSpace(name)
+		signed = append(signed, name)
+		if strings.EqualFold(name, "From") {
+			hasFrom = true
+		}
+	}
+	if !hasFrom {
+		return errors.New("From header is not signed")
+	}
+
+	if x, ok := tags["x"]; ok {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid x= tag: %w", err)
+		}
+		if time.Now().Unix() > expires {
+			return ErrSignatureExpired
+		}
+	}
+
+	canonical := canonicalBody(body, bodyC)
+	if l, ok := tags["l"]; ok {
+		length, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || length < 0 || length > int64(len(canonical)) {
+			return fmt.Errorf("invalid l= tag %q", l)
+		}
+		canonical = canonical[:length]
+	}
+	bodyHash := sha256.Sum256(canonical)
+	wantBodyHash, err := base64.StdEncoding.DecodeString(stripSpace(tags["bh"]))
+	if err != nil {
+		return fmt.Errorf("invalid bh= tag: %w", err)
+	}
+	if string(bodyHash[:]) != string(wantBodyHash) {
+		return ErrBodyHashMismatch
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(stripSpace(tags["b"]))
+	if err != nil {
+		return fmt.Errorf("invalid b= tag: %w", err)
+	}
+	pub, err := lookup(tags["d"], tags["s"])
+	if err != nil {
+		return fmt.Errorf("key lookup failed: %w", err)
+	}
+
+	data := canonicalHeaders(fields, signed, headerC)
+	data = append(data, canonicalHeader(withoutSignature(signature.raw), headerC)...)
+	hash := sha256.Sum256(data)
+
+	switch tags["a"] {
+	case "rsa-sha256":
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("rsa-sha256 signature with a %T key", pub)
+		}
+		if key.N.BitLen() < minRSABits {
+			return fmt.Errorf("RSA key shorter than %d bits", minRSABits)
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) != nil {
+			return ErrSignatureMismatch
+		}
+	case "ed25519-sha256":
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("ed25519-sha256 signature with a %T key", pub)
+		}
+		if !ed25519.Verify(key, hash[:], sig) {
+			return ErrSignatureMismatch
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", tags["a"])
+	}
+	return nil
+}
+
+// parseCanonicalization parses a c= tag, which defaults to simple/simple
+// and to simple for a missing body part
+func parseCanonicalization(c string) (header, body Canonicalization, err error) {
+	if c == "" {
+		return Simple, Simple, nil
+	}
+	h, b, found := strings.Cut(c, "/")
+	if !found {
+		b = string(Simple)
+	}
+	for _, value := range []string{h, b} {
+		if value != string(Simple) && value != string(Relaxed) {
+			return "", "", fmt.Errorf("unknown canonicalization %q", value)
+		}
+	}
+	return Canonicalization(h), Canonicalization(b), nil
+}
+
+// withoutSignature returns a DKIM-Signature field with the value of its
+// b= tag removed and without the final CRLF, the form it was signed in
+func withoutSignature(raw string) string {
+	raw = strings.TrimSuffix(raw, "\r\n")
+	colon := strings.IndexByte(raw, ':')
+	segments := strings.Split(raw[colon+1:], ";")
+	for i, segment := range segments {
+		name, _, found := strings.Cut(segment, "=")
+		if found && strings.TrimSpace(name) == "b" {
+			segments[i] = segment[:strings.IndexByte(segment, '=')+1]
+		}
+	}
+	return raw[:colon+1] + strings.Join(segments, ";")
+}
+
+// parseTags parses a tag=value list (RFC 6376 3.2)
+func parseTags(list string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(list, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(spec, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid tag %q", strings.TrimSpace(spec))
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := tags[name]; ok {
+			return nil, fmt.Errorf("duplicate %s= tag", name)
+		}
+		tags[name] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
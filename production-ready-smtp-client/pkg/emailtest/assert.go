@@ -0,0 +1,185 @@
+package emailtest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Matcher checks one property of a recorded message
+type Matcher struct {
+	description string
+	match       func(Message) bool
+}
+
+func (m Matcher) String() string {
+	return m.description
+}
+
+// SentTo matches messages with addr among the To, Cc or Bcc recipients
+func SentTo(addr string) Matcher {
+	return Matcher{
+		description: fmt.Sprintf("sent to %s", addr),
+		match: func(m Message) bool {
+			for _, rcpt := range m.Email.Recipients() {
+				if strings.EqualFold(rcpt, addr) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// From matches messages sent from addr
+func From(addr string) Matcher {
+	return Matcher{
+		description: fmt.Sprintf("from %s", addr),
+		match: func(m Message) bool {
+			return strings.EqualFold(m.Email.From.Address, addr)
+		},
+	}
+}
+
+// Subject matches messages whose subject is exactly subject
+func Subject(subject string) Matcher {
+	return Matcher{
+		description: fmt.Sprintf("with subject %q", subject),
+		match: func(m Message) bool {
+			return m.Email.Subject == subject
+		},
+	}
+}
+
+// SubjectMatches matches messages whose subject matches the regular
+// expression pattern
+func SubjectMatches(pattern string) Matcher {
+	re := regexp.MustCompile(pattern)
+	return Matcher{
+		description: fmt.Sprintf("with subject matching %q", pattern),
+		match: func(m Message) bool {
+			return re.MatchString(m.Email.Subject)
+		},
+	}
+}
+
+// BodyContains matches messages whose text or HTML body contains s
+func BodyContains(s string) Matcher {
+	return Matcher{
+		description: fmt.Sprintf("with body containing %q", s),
+		match: func(m Message) bool {
+			return strings.Contains(m.Email.TextBody, s) || strings.Contains(m.Email.HTMLBody, s)
+		},
+	}
+}
+
+// WithAttachment matches messages carrying an attachment named filename
+func WithAttachment(filename string) Matcher {
+	return Matcher{
+		description: fmt.Sprintf("with attachment %q", filename),
+		match: func(m Message) bool {
+			for _, att := range m.Email.Attachments {
+				if att.Filename == filename {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// WithHeader matches messages carrying the custom header key set to value
+func WithHeader(key, value string) Matcher {
+	return Matcher{
+		description: fmt.Sprintf("with header %s: %s", key, value),
+		match: func(m Message) bool {
+			for k, v := range m.Email.Headers {
+				if strings.EqualFold(k, key) && v == value {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// Find returns the recorded messages that satisfy every matcher
+func (r *Recorder) Find(matchers ...Matcher) []Message {
+	var found []Message
+	for _, msg := range r.Messages() {
+		if matchesAll(msg, matchers) {
+			found = append(found, msg)
+		}
+	}
+	return found
+}
+
+// AssertSent fails t unless at least one recorded message satisfies every
+// matcher, and returns the first such message
+func (r *Recorder) AssertSent(t testing.TB, matchers ...Matcher) Message {
+	t.Helper()
+
+	found := r.Find(matchers...)
+	if len(found) == 0 {
+		t.Errorf("no message %s; recorded:\n%s", describe(matchers), r.summary())
+		return Message{}
+	}
+	return found[0]
+}
+
+// AssertNotSent fails t if any recorded message satisfies every matcher
+func (r *Recorder) AssertNotSent(t testing.TB, matchers ...Matcher) {
+	t.Helper()
+
+	if found := r.Find(matchers...); len(found) > 0 {
+		t.Errorf("unexpected message %s; recorded:\n%s", describe(matchers), r.summary())
+	}
+}
+
+// AssertCount fails t unless exactly n messages were recorded
+func (r *Recorder) AssertCount(t testing.TB, n int) {
+	t.Helper()
+
+	if got := len(r.Messages()); got != n {
+		t.Errorf("expected %d messages, recorded %d:\n%s", n, got, r.summary())
+	}
+}
+
+func matchesAll(msg Message, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.match(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func describe(matchers []Matcher) string {
+	if len(matchers) == 0 {
+		return "at all"
+	}
+	parts := make([]string, len(matchers))
+	for i, m := range matchers {
+		parts[i] = m.description
+	}
+	return strings.Join(parts, " and ")
+}
+
+func (r *Recorder) summary() string {
+	messages := r.Messages()
+	if len(messages) == 0 {
+		return "  (none)"
+	}
+
+	var b strings.Builder
+	for i, msg := range messages {
+		var attachments []string
+		for _, att := range msg.Email.Attachments {
+			attachments = append(attachments, att.Filename)
+		}
+		fmt.Fprintf(&b, "  %d. from %s to %v subject %q attachments %v\n",
+			i+1, msg.Email.From, msg.Email.To, msg.Email.Subject, attachments)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,132 @@
+package emailtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+// fakeTB records the failures reported by the assertions under test
+type fakeTB struct {
+	testing.TB
+	errors []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func matcherTestRecorder(t *testing.T) *Recorder {
+	t.Helper()
+	email, err := domain.NewEmailBuilder().
+		From("News <news@example.com>").
+		To("alice@example.org").
+		Bcc("audit@example.com").
+		Subject("Weekly report 42").
+		TextBody("The numbers are in.").
+		HTMLBody("<p>See the <b>chart</b></p>").
+		Header("X-Campaign", "weekly").
+		Attach("report.pdf", "application/pdf", []byte("%PDF-1.4")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewRecorder()
+	if err := recorder.Send(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+	return recorder
+}
+
+func TestMatchers(t *testing.T) {
+	recorder := matcherTestRecorder(t)
+	tests := []struct {
+		matcher Matcher
+		want    bool
+	}{
+		{SentTo("alice@example.org"), true},
+		{SentTo("ALICE@example.org"), true},
+		{SentTo("audit@example.com"), true},
+		{SentTo("bob@example.org"), false},
+		{From("news@EXAMPLE.com"), true},
+		{From("alice@example.org"), false},
+		{Subject("Weekly report 42"), true},
+		{Subject("Weekly report"), false},
+		{SubjectMatches(`^Weekly report \d+$`), true},
+		{SubjectMatches(`^Monthly`), false},
+		{BodyContains("numbers are in"), true},
+		{BodyContains("<b>chart</b>"), true},
+		{BodyContains("missing"), false},
+		{WithAttachment("report.pdf"), true},
+		{WithAttachment("other.pdf"), false},
+		{WithHeader("x-campaign", "weekly"), true},
+		{WithHeader("X-Campaign", "daily"), false},
+	}
+	for _, tt := range tests {
+		if got := len(recorder.Find(tt.matcher)) == 1; got != tt.want {
+			t.Errorf("%s: matched = %v, want %v", tt.matcher, got, tt.want)
+		}
+	}
+}
+
+func TestFindMatchesAll(t *testing.T) {
+	recorder := matcherTestRecorder(t)
+	if found := recorder.Find(); len(found) != 1 {
+		t.Errorf("Find() = %d messages, want all", len(found))
+	}
+	if found := recorder.Find(SentTo("alice@example.org"), Subject("Weekly report 42")); len(found) != 1 {
+		t.Errorf("Find with matching matchers = %d messages", len(found))
+	}
+	if found := recorder.Find(SentTo("alice@example.org"), Subject("Other")); len(found) != 0 {
+		t.Errorf("Find with one failing matcher = %d messages", len(found))
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	recorder := matcherTestRecorder(t)
+	tests := []struct {
+		name   string
+		assert func(tb testing.TB)
+		fails  bool
+	}{
+		{"sent", func(tb testing.TB) { recorder.AssertSent(tb, SentTo("alice@example.org")) }, false},
+		{"not sent", func(tb testing.TB) { recorder.AssertSent(tb, SentTo("bob@example.org")) }, true},
+		{"absent", func(tb testing.TB) { recorder.AssertNotSent(tb, SentTo("bob@example.org")) }, false},
+		{"present", func(tb testing.TB) { recorder.AssertNotSent(tb, SentTo("alice@example.org")) }, true},
+		{"count", func(tb testing.TB) { recorder.AssertCount(tb, 1) }, false},
+		{"wrong count", func(tb testing.TB) { recorder.AssertCount(tb, 2) }, true},
+	}
+	for _, tt := range tests {
+		tb := &fakeTB{}
+		tt.assert(tb)
+		if failed := len(tb.errors) > 0; failed != tt.fails {
+			t.Errorf("%s: failed = %v, want %v: %v", tt.name, failed, tt.fails, tb.errors)
+		}
+	}
+}
+
+func TestAssertSentReturnsMessage(t *testing.T) {
+	recorder := matcherTestRecorder(t)
+	msg := recorder.AssertSent(t, WithAttachment("report.pdf"))
+	if msg.Email == nil || msg.Email.Subject != "Weekly report 42" {
+		t.Errorf("AssertSent = %+v", msg)
+	}
+}
+
+func TestAssertSentDescribesFailure(t *testing.T) {
+	recorder := matcherTestRecorder(t)
+	tb := &fakeTB{}
+	recorder.AssertSent(tb, SentTo("bob@example.org"), Subject("Hi"))
+	if len(tb.errors) != 1 {
+		t.Fatalf("errors = %v", tb.errors)
+	}
+	want := `no message sent to bob@example.org and with subject "Hi"; recorded:
+  1. from "News" <news@example.com> to [alice@example.org] subject "Weekly report 42" attachments [report.pdf]`
+	if tb.errors[0] != want {
+		t.Errorf("error =\n%s\nwant\n%s", tb.errors[0], want)
+	}
+}
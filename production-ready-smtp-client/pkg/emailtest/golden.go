@@ -0,0 +1,73 @@
+package emailtest
+
+import (
+	"bytes"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// UpdateGoldenEnv names the environment variable that makes the golden
+// assertions write the golden files instead of comparing with them, as in
+// UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// AssertGolden composes email with composer.Deterministic options and
+// compares the message with the golden file at path
+func AssertGolden(t testing.TB, path string, email *domain.Email) {
+	t.Helper()
+
+	raw, err := composer.New(composer.Deterministic()).Bytes(email)
+	if err != nil {
+		t.Fatalf("failed to compose message: %v", err)
+	}
+	AssertGoldenBytes(t, path, raw)
+}
+
+// AssertGoldenBytes compares a rendered message, such as Message.Raw of a
+// deterministic Recorder, with the golden file at path. The comparison is
+// byte for byte, so golden files must be kept out of line ending
+// conversion, e.g. with "*.eml -text" in .gitattributes.
+func AssertGoldenBytes(t testing.TB, path string, raw []byte) {
+	t.Helper()
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			t.Fatalf("failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with %s=1 to create it): %v", UpdateGoldenEnv, err)
+	}
+	if !bytes.Equal(raw, want) {
+		t.Errorf("message differs from %s (run with %s=1 to update it)\n%s", path, UpdateGoldenEnv, firstDifference(want, raw))
+	}
+}
+
+// firstDifference describes the first line where got differs from want
+func firstDifference(want, got []byte) string {
+	wantLines := bytes.SplitAfter(want, []byte("\n"))
+	gotLines := bytes.SplitAfter(got, []byte("\n"))
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g []byte
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if !bytes.Equal(w, g) {
+			return fmt.Sprintf("line %d:\n  want %q\n  got  %q", i+1, w, g)
+		}
+	}
+	return ""
+}
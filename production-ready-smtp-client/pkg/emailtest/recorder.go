@@ -0,0 +1,173 @@
+// Package emailtest provides a recording domain.EmailSender and assertion
+// helpers for tests of code that sends email.
+package emailtest
+
+import (
+	"context"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/infrastructure"
+	"go-smtp/production-ready-smtp-client/pkg/composer"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// Message is an email captured by a Recorder
+type Message struct {
+	// Email is a copy of the email taken when it was sent, so later
+	// changes by the caller, such as status updates, don't show up here
+	Email *domain.Email
+	// Raw is the MIME message the SMTP transports would have sent
+	Raw    []byte
+	SentAt time.Time
+}
+
+// failure is a scripted failure for the next attempts
+type failure struct {
+	remaining int
+	code      int
+	message   string
+}
+
+// Recorder is an in-memory domain.EmailSender that records every message
+// it accepts instead of delivering it
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+	attempts int
+	failures []failure
+	closed   bool
+	// composer renders Raw, infrastructure.BuildMessage if nil
+	composer *composer.Composer
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Deterministic makes Raw reproducible, see composer.Deterministic, so
+// recorded messages can be compared with golden files
+func (r *Recorder) Deterministic() *Recorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opts := composer.Deterministic()
+	opts.Hostname = "emailtest.invalid"
+	r.composer = composer.New(opts)
+	return r
+}
+
+// FailNext makes the next n attempts fail with the given SMTP reply code,
+// e.g. 421 for a retryable failure or 550 for a permanent one. Calls queue
+// up, so FailNext(2, 421) followed by FailNext(1, 550) fails three times.
+func (r *Recorder) FailNext(n int, code int) *Recorder {
+	return r.FailNextWith(n, code, "scripted failure")
+}
+
+// FailNextWith is FailNext with a custom reply text
+func (r *Recorder) FailNextWith(n int, code int, message string) *Recorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n > 0 {
+		r.failures = append(r.failures, failure{remaining: n, code: code, message: message})
+	}
+	return r
+}
+
+func (r *Recorder) Send(ctx context.Context, email *domain.Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return fmt.Errorf("sender is closed")
+	}
+
+	r.attempts++
+
+	if len(r.failures) > 0 {
+		f := &r.failures[0]
+		f.remaining--
+		err := &textproto.Error{Code: f.code, Msg: f.message}
+		if f.remaining == 0 {
+			r.failures = r.failures[1:]
+		}
+		return err
+	}
+
+	var raw []byte
+	var err error
+	if r.composer != nil {
+		raw, err = r.composer.Bytes(email)
+	} else {
+		raw, err = infrastructure.BuildMessage(email, "emailtest.invalid")
+	}
+	if err != nil {
+		// Nothing is written anywhere, so a failure can only come from
+		// the email itself and retrying it can't help
+		return retry.Permanent(fmt.Errorf("failed to build message: %w", err))
+	}
+
+	r.messages = append(r.messages, Message{
+		Email:  email.Clone(),
+		Raw:    raw,
+		SentAt: time.Now(),
+	})
+	return nil
+}
+
+func (r *Recorder) SendBulk(ctx context.Context, emails []*domain.Email) error {
+	var failures []error
+	for _, email := range emails {
+		if err := r.Send(ctx, email); err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to send %d emails: %v", len(failures), failures[0])
+	}
+
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	return nil
+}
+
+// Messages returns the messages accepted so far, oldest first
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
+
+// Attempts returns how many times Send was called, including failures
+func (r *Recorder) Attempts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.attempts
+}
+
+// Reset forgets all recorded messages, attempts and scripted failures
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+	r.attempts = 0
+	r.failures = nil
+	r.closed = false
+}
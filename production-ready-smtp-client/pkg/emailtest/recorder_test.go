@@ -0,0 +1,177 @@
+package emailtest
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+	"go-smtp/production-ready-smtp-client/pkg/retry"
+)
+
+func testEmail(t *testing.T) *domain.Email {
+	t.Helper()
+	email, err := domain.NewEmailBuilder().
+		From("from@example.com").
+		To("to@example.org").
+		Subject("Hello").
+		TextBody("body").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return email
+}
+
+func TestRecorderBuildErrorIsPermanent(t *testing.T) {
+	for _, recorder := range []*Recorder{NewRecorder(), NewRecorder().Deterministic()} {
+		email := testEmail(t)
+		email.Headers = map[string]string{"X-Campaign": "v\r\nBcc: x@evil.com"}
+
+		err := recorder.Send(context.Background(), email)
+		if err == nil || retry.IsRetryable(err) {
+			t.Errorf("Send = %v, want a permanent error", err)
+		}
+		if len(recorder.Messages()) != 0 {
+			t.Error("invalid email was recorded")
+		}
+	}
+}
+
+func TestRecorderRecords(t *testing.T) {
+	recorder := NewRecorder()
+	email := testEmail(t)
+	if err := recorder.Send(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+	email.Subject = "Changed"
+	email.To[0].Address = "changed@example.org"
+
+	messages := recorder.Messages()
+	if len(messages) != 1 || recorder.Attempts() != 1 {
+		t.Fatalf("%d messages, %d attempts", len(messages), recorder.Attempts())
+	}
+	msg := messages[0]
+	if msg.Email.Subject != "Hello" || msg.Email.To[0].Address != "to@example.org" {
+		t.Errorf("recorded email follows the caller's changes: %+v", msg.Email)
+	}
+	if !strings.Contains(string(msg.Raw), "\r\nSubject: Hello\r\n") || msg.SentAt.IsZero() {
+		t.Errorf("Raw = %q, SentAt = %v", msg.Raw, msg.SentAt)
+	}
+}
+
+func TestRecorderDeterministic(t *testing.T) {
+	var raws []string
+	for i := 0; i < 2; i++ {
+		recorder := NewRecorder().Deterministic()
+		if err := recorder.Send(context.Background(), testEmail(t)); err != nil {
+			t.Fatal(err)
+		}
+		raws = append(raws, string(recorder.Messages()[0].Raw))
+	}
+	if raws[0] != raws[1] {
+		t.Errorf("messages differ:\n%s\n%s", raws[0], raws[1])
+	}
+	if !strings.Contains(raws[0], "Date: Tue, 02 Jan 2024 15:04:05 +0000") {
+		t.Errorf("no fixed date:\n%s", raws[0])
+	}
+}
+
+func TestRecorderMessageID(t *testing.T) {
+	recorder := NewRecorder().Deterministic()
+	email := testEmail(t)
+	if err := recorder.Send(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := recorder.Messages()[0]
+	if email.MessageID == "" || msg.Email.MessageID != email.MessageID {
+		t.Fatalf("MessageID = %q, recorded %q", email.MessageID, msg.Email.MessageID)
+	}
+	if header := "\r\nMessage-ID: <" + email.MessageID + ">\r\n"; !strings.Contains(string(msg.Raw), header) {
+		t.Errorf("missing %q in\n%s", header, msg.Raw)
+	}
+}
+
+func TestRecorderFailNext(t *testing.T) {
+	recorder := NewRecorder().FailNext(2, 421).FailNextWith(1, 550, "no such user")
+	want := []struct {
+		code      int
+		message   string
+		retryable bool
+	}{
+		{421, "scripted failure", true},
+		{421, "scripted failure", true},
+		{550, "no such user", false},
+		{0, "", false},
+	}
+	for i, w := range want {
+		err := recorder.Send(context.Background(), testEmail(t))
+		if w.code == 0 {
+			if err != nil {
+				t.Errorf("attempt %d: %v", i+1, err)
+			}
+			continue
+		}
+		var reply *textproto.Error
+		if !errors.As(err, &reply) || reply.Code != w.code || reply.Msg != w.message {
+			t.Errorf("attempt %d: err = %v, want %d %s", i+1, err, w.code, w.message)
+			continue
+		}
+		if got := retry.IsRetryable(err); got != w.retryable {
+			t.Errorf("attempt %d: retryable = %v, want %v", i+1, got, w.retryable)
+		}
+	}
+	recorder.AssertCount(t, 1)
+	if recorder.Attempts() != 4 {
+		t.Errorf("Attempts = %d, want 4", recorder.Attempts())
+	}
+}
+
+func TestRecorderFailNextIgnoresZero(t *testing.T) {
+	recorder := NewRecorder().FailNext(0, 421)
+	if err := recorder.Send(context.Background(), testEmail(t)); err != nil {
+		t.Errorf("Send = %v", err)
+	}
+}
+
+func TestRecorderSendBulk(t *testing.T) {
+	recorder := NewRecorder().FailNext(1, 550)
+	emails := []*domain.Email{testEmail(t), testEmail(t), testEmail(t)}
+	if err := recorder.SendBulk(context.Background(), emails); err == nil || !strings.Contains(err.Error(), "1 emails") {
+		t.Errorf("SendBulk = %v, want one failure", err)
+	}
+	recorder.AssertCount(t, 2)
+}
+
+func TestRecorderCloseAndReset(t *testing.T) {
+	recorder := NewRecorder().FailNext(5, 421)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Send(context.Background(), testEmail(t)); err == nil {
+		t.Error("closed recorder accepted a message")
+	}
+
+	recorder.Reset()
+	if err := recorder.Send(context.Background(), testEmail(t)); err != nil {
+		t.Errorf("Send after Reset = %v", err)
+	}
+	if recorder.Attempts() != 1 {
+		t.Errorf("Attempts = %d, want 1", recorder.Attempts())
+	}
+}
+
+func TestRecorderCancelledContext(t *testing.T) {
+	recorder := NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := recorder.Send(ctx, testEmail(t)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Send = %v, want context.Canceled", err)
+	}
+	if recorder.Attempts() != 0 {
+		t.Errorf("Attempts = %d, want 0", recorder.Attempts())
+	}
+}
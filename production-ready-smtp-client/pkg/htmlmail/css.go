@@ -0,0 +1,226 @@
+package htmlmail
+
+import (
+	"strings"
+)
+
+// declaration is one "property: value" pair of a rule or style attribute
+type declaration struct {
+	property  string // lower-cased
+	value     string
+	important bool
+}
+
+// cssRule is a top-level rule of a stylesheet. At-rules such as @media
+// and @font-face are kept as raw text since they can't be inlined.
+type cssRule struct {
+	selectors    []string
+	declarations []declaration
+	raw          string
+}
+
+// parseStylesheet splits a stylesheet into its top-level rules
+func parseStylesheet(css string) []cssRule {
+	css = stripComments(css)
+
+	var rules []cssRule
+	i := 0
+	for i < len(css) {
+		for i < len(css) && isCSSSpace(css[i]) {
+			i++
+		}
+		if i >= len(css) {
+			break
+		}
+
+		if css[i] == '@' {
+			end := atRuleEnd(css, i)
+			rules = append(rules, cssRule{raw: strings.TrimSpace(css[i:end])})
+			i = end
+			continue
+		}
+
+		open := indexUnquoted(css[i:], '{')
+		if open < 0 {
+			break
+		}
+		open += i
+		end := matchingBrace(css, open)
+
+		selectors := splitTopLevel(css[i:open], ',')
+		for j := range selectors {
+			selectors[j] = strings.Join(strings.Fields(selectors[j]), " ")
+		}
+		body := css[open+1 : end]
+		body = strings.TrimSuffix(body, "}")
+		rules = append(rules, cssRule{
+			selectors:    selectors,
+			declarations: parseDeclarations(body),
+		})
+		i = end
+	}
+	return rules
+}
+
+// parseDeclarations parses the body of a rule or a style attribute
+func parseDeclarations(block string) []declaration {
+	var decls []declaration
+	for _, part := range splitTopLevel(block, ';') {
+		colon := strings.IndexByte(part, ':')
+		if colon < 0 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(part[:colon]))
+		value := strings.TrimSpace(part[colon+1:])
+		if property == "" || value == "" {
+			continue
+		}
+
+		d := declaration{property: property, value: value}
+		if bang := strings.LastIndexByte(value, '!'); bang >= 0 &&
+			strings.EqualFold(strings.TrimSpace(value[bang+1:]), "important") {
+			d.important = true
+			d.value = strings.TrimSpace(value[:bang])
+		}
+		decls = append(decls, d)
+	}
+	return decls
+}
+
+func (r cssRule) String() string {
+	if r.raw != "" {
+		return r.raw
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Join(r.selectors, ", "))
+	b.WriteString(" { ")
+	for _, d := range r.declarations {
+		b.WriteString(d.String())
+		b.WriteString("; ")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func (d declaration) String() string {
+	if d.important {
+		return d.property + ": " + d.value + " !important"
+	}
+	return d.property + ": " + d.value
+}
+
+// atRuleEnd returns the index just past the at-rule starting at css[i],
+// which ends either at a semicolon or with its block
+func atRuleEnd(css string, i int) int {
+	for j := i; j < len(css); j++ {
+		switch css[j] {
+		case ';':
+			return j + 1
+		case '{':
+			return matchingBrace(css, j)
+		case '"', '\'':
+			j = skipString(css, j) - 1
+		}
+	}
+	return len(css)
+}
+
+// matchingBrace returns the index just past the brace closing the one at
+// css[open]
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for j := open; j < len(css); j++ {
+		switch css[j] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		case '"', '\'':
+			j = skipString(css, j) - 1
+		}
+	}
+	return len(css)
+}
+
+// skipString returns the index just past the string starting at s[i]
+func skipString(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// splitTopLevel splits s at sep where it is outside of strings,
+// parentheses and brackets, so that url(data:...;base64,...) and
+// attribute selectors stay whole
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for j := 0; j < len(s); j++ {
+		switch c := s[j]; {
+		case c == '"' || c == '\'':
+			j = skipString(s, j) - 1
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:j])
+			start = j + 1
+		}
+	}
+	parts = append(parts, s[start:])
+
+	kept := parts[:0]
+	for _, part := range parts {
+		if strings.TrimSpace(part) != "" {
+			kept = append(kept, part)
+		}
+	}
+	return kept
+}
+
+// indexUnquoted is strings.IndexByte skipping over strings
+func indexUnquoted(s string, c byte) int {
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case c:
+			return j
+		case '"', '\'':
+			j = skipString(s, j) - 1
+		}
+	}
+	return -1
+}
+
+func stripComments(css string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			b.WriteString(css)
+			return b.String()
+		}
+		b.WriteString(css[:start])
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return b.String()
+		}
+		css = css[start+2+end+2:]
+	}
+}
+
+func isCSSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
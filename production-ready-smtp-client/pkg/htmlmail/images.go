@@ -0,0 +1,272 @@
+// Package htmlmail prepares HTML bodies for email clients, which support a
+// much smaller part of HTML and CSS than browsers do.
+package htmlmail
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"html"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxImageSize is used when ImageOptions.MaxSize is zero
+const DefaultMaxImageSize = 2 << 20
+
+var (
+	// ErrImageTooLarge is returned for an image above the size limit
+	ErrImageTooLarge = errors.New("image exceeds size limit")
+	// ErrOutsideBaseDir is returned for an image path that leads out of
+	// ImageOptions.BaseDir
+	ErrOutsideBaseDir = errors.New("image path outside base directory")
+)
+
+type ImageOptions struct {
+	// BaseDir is the directory relative image paths are resolved in.
+	// Paths cannot escape it. If empty, only data: URIs are converted.
+	BaseDir string
+	// MaxSize limits the decoded size of each image in bytes
+	MaxSize int64
+}
+
+// EmbedImages rewrites every <img> in email.HTMLBody whose src is a data:
+// URI or a relative file path into a cid: reference, adding the image to
+// email.Embedded. Identical images are embedded once. Images that are
+// already cid: references or remote URLs are left alone.
+func EmbedImages(email *domain.Email, opts ImageOptions) error {
+	if email.HTMLBody == "" {
+		return nil
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxImageSize
+	}
+
+	existing := make(map[string]bool, len(email.Embedded))
+	for _, part := range email.Embedded {
+		existing[part.ContentID] = true
+	}
+
+	var edits []edit
+	for _, tok := range tokenize(email.HTMLBody) {
+		if tok.typ != startTagToken || tok.name != "img" {
+			continue
+		}
+
+		for _, attr := range tok.attrs {
+			if attr.name != "src" || attr.start < 0 {
+				continue
+			}
+
+			image, err := loadImage(attr.value, opts)
+			if err != nil {
+				return fmt.Errorf("image %s: %w", abbreviate(attr.value), err)
+			}
+			if image == nil {
+				continue
+			}
+
+			if !existing[image.ContentID] {
+				existing[image.ContentID] = true
+				email.Embedded = append(email.Embedded, *image)
+			}
+
+			edits = append(edits, edit{
+				start: attr.start,
+				end:   attr.end,
+				text:  `"cid:` + html.EscapeString(image.ContentID) + `"`,
+			})
+		}
+	}
+
+	email.HTMLBody = applyEdits(email.HTMLBody, edits)
+	return nil
+}
+
+// loadImage returns the embedded part for src, or nil if src is not
+// something to embed
+func loadImage(src string, opts ImageOptions) (*domain.Attachment, error) {
+	src = strings.TrimSpace(src)
+
+	var data []byte
+	var contentType, filename string
+
+	switch {
+	case hasPrefixFold(src, "data:"):
+		var err error
+		data, contentType, err = decodeDataURI(src, opts.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+
+	case isRelativePath(src) && opts.BaseDir != "":
+		file, err := resolvePath(opts.BaseDir, src)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() > opts.MaxSize {
+			return nil, fmt.Errorf("%w: %d > %d bytes", ErrImageTooLarge, info.Size(), opts.MaxSize)
+		}
+
+		data, err = os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		filename = filepath.Base(file)
+
+	default:
+		return nil, nil
+	}
+
+	if detected := domain.DetectContentType(filename, data); contentType == "" || strings.HasPrefix(detected, "image/") {
+		contentType = detected
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("not an image: %s", contentType)
+	}
+
+	// Naming the part after its content makes identical images share one
+	// part, within a message and across messages
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:12])
+	if filename == "" {
+		filename = "image-" + id[:8] + extensionFor(contentType)
+	}
+
+	return &domain.Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Data:        data,
+		ContentID:   id + "@inline",
+	}, nil
+}
+
+// decodeDataURI decodes an RFC 2397 data: URI
+func decodeDataURI(uri string, maxSize int64) ([]byte, string, error) {
+	comma := strings.IndexByte(uri, ',')
+	if comma < 0 {
+		return nil, "", fmt.Errorf("malformed data URI")
+	}
+
+	meta := uri[len("data:"):comma]
+	payload := uri[comma+1:]
+
+	isBase64 := false
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		isBase64 = true
+		meta = meta[:len(meta)-len(";base64")]
+	}
+
+	contentType := ""
+	if meta != "" {
+		if mediaType, _, err := mime.ParseMediaType(meta); err == nil {
+			contentType = mediaType
+		}
+	}
+
+	// Reject oversized payloads before decoding them
+	if isBase64 && int64(base64.StdEncoding.DecodedLen(len(payload))) > maxSize+3 {
+		return nil, "", fmt.Errorf("%w: over %d bytes", ErrImageTooLarge, maxSize)
+	}
+
+	var data []byte
+	var err error
+	if isBase64 {
+		// Data URIs in HTML are often wrapped or URL-encoded
+		payload, err = url.PathUnescape(payload)
+		if err != nil {
+			return nil, "", fmt.Errorf("malformed data URI: %w", err)
+		}
+		payload = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, payload)
+		data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+	} else {
+		var decoded string
+		decoded, err = url.PathUnescape(payload)
+		data = []byte(decoded)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("malformed data URI: %w", err)
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("%w: %d > %d bytes", ErrImageTooLarge, len(data), maxSize)
+	}
+	return data, contentType, nil
+}
+
+// isRelativePath reports whether src refers to a local file rather than a
+// URL with a scheme, a protocol-relative URL or a fragment
+func isRelativePath(src string) bool {
+	if src == "" || strings.HasPrefix(src, "//") || strings.HasPrefix(src, "#") {
+		return false
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == ""
+}
+
+// resolvePath maps src onto a file inside baseDir. A leading slash or ".."
+// segments can't reach outside of it, and neither can symlinks: the path
+// is checked again with them resolved.
+func resolvePath(baseDir, src string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	base, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return "", err
+	}
+	file, err := filepath.EvalSymlinks(filepath.Join(base, filepath.FromSlash(path.Clean("/"+u.Path))))
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(base, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrOutsideBaseDir
+	}
+	return file, nil
+}
+
+func extensionFor(contentType string) string {
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// abbreviate shortens data URIs for error messages
+func abbreviate(src string) string {
+	if len(src) > 40 {
+		return src[:37] + "..."
+	}
+	return src
+}
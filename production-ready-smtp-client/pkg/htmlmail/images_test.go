@@ -0,0 +1,204 @@
+package htmlmail
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-smtp/production-ready-smtp-client/domain"
+)
+
+// pngHeader is enough of a PNG file for content type detection
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestEmbedImagesPaths(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "templates")
+	for _, dir := range []string{base, filepath.Join(base, "img")} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(base, "img", "logo.png"), pngHeader)
+	write(filepath.Join(root, "secret.png"), pngHeader)
+	link := func(target, name string) {
+		t.Helper()
+		if err := os.Symlink(target, filepath.Join(base, name)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	link(filepath.Join(base, "img", "logo.png"), "alias.png")
+	link(filepath.Join(root, "secret.png"), "escape.png")
+	link(root, "up")
+
+	tests := []struct {
+		src     string
+		wantErr error
+	}{
+		{"img/logo.png", nil},
+		{"/img/logo.png", nil},
+		{"../img/logo.png", nil}, // ".." can't climb above the base
+		{"alias.png", nil},       // a symlink within the base is fine
+		{"escape.png", ErrOutsideBaseDir},
+		{"up/secret.png", ErrOutsideBaseDir},
+	}
+	for _, tt := range tests {
+		email := &domain.Email{HTMLBody: `<img src="` + tt.src + `">`}
+		err := EmbedImages(email, ImageOptions{BaseDir: base})
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: err = %v, want %v", tt.src, err, tt.wantErr)
+			}
+			if len(email.Embedded) != 0 {
+				t.Errorf("%s: embedded %d images", tt.src, len(email.Embedded))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if len(email.Embedded) != 1 || string(email.Embedded[0].Data) != string(pngHeader) {
+			t.Errorf("%s: embedded = %+v", tt.src, email.Embedded)
+		}
+	}
+}
+
+func TestEmbedImagesDataURI(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(pngHeader)
+	wrapped := encoded[:10] + "\n  " + encoded[10:]
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"/>`
+
+	tests := []struct {
+		name        string
+		src         string
+		data        []byte
+		contentType string
+	}{
+		{"base64", "data:image/png;base64," + encoded, pngHeader, "image/png"},
+		{"uppercase", "DATA:image/png;BASE64," + encoded, pngHeader, "image/png"},
+		{"wrapped", "data:image/png;base64," + wrapped, pngHeader, "image/png"},
+		{"URL-encoded", "data:image/png;base64," + url.PathEscape(encoded), pngHeader, "image/png"},
+		{"unpadded", "data:image/png;base64," + strings.TrimRight(encoded, "="), pngHeader, "image/png"},
+		{"content wins over declared type", "data:image/gif;base64," + encoded, pngHeader, "image/png"},
+		{"no media type", "data:;base64," + encoded, pngHeader, "image/png"},
+		{"percent-encoded", "data:image/svg+xml," + url.PathEscape(svg), []byte(svg), "image/svg+xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &domain.Email{HTMLBody: `<p><img alt="x" src="` + tt.src + `"></p>`}
+			if err := EmbedImages(email, ImageOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if len(email.Embedded) != 1 {
+				t.Fatalf("embedded %d images", len(email.Embedded))
+			}
+			image := email.Embedded[0]
+			if string(image.Data) != string(tt.data) || image.ContentType != tt.contentType {
+				t.Errorf("embedded %q as %s, want %q as %s", image.Data, image.ContentType, tt.data, tt.contentType)
+			}
+			if want := `<p><img alt="x" src="cid:` + image.ContentID + `"></p>`; email.HTMLBody != want {
+				t.Errorf("HTMLBody = %s, want %s", email.HTMLBody, want)
+			}
+			if !strings.HasSuffix(image.ContentID, "@inline") || !strings.HasPrefix(image.Filename, "image-") {
+				t.Errorf("ContentID %q, Filename %q", image.ContentID, image.Filename)
+			}
+		})
+	}
+}
+
+func TestEmbedImagesInvalidDataURI(t *testing.T) {
+	for _, src := range []string{
+		"data:image/png;base64",
+		"data:image/png;base64,!!!not base64!!!",
+		"data:text/plain,hello",
+	} {
+		email := &domain.Email{HTMLBody: `<img src="` + src + `">`}
+		if err := EmbedImages(email, ImageOptions{}); err == nil {
+			t.Errorf("%s: EmbedImages succeeded", src)
+		}
+	}
+}
+
+func TestEmbedImagesLeavesOtherSources(t *testing.T) {
+	body := `<img src="https://example.com/a.png"><img src="//cdn.example.com/b.png"><img src="cid:c@example.com"><img src="d.png">`
+	email := &domain.Email{HTMLBody: body}
+	// Without a BaseDir relative paths are not read either
+	if err := EmbedImages(email, ImageOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if email.HTMLBody != body || len(email.Embedded) != 0 {
+		t.Errorf("HTMLBody = %s, embedded %d", email.HTMLBody, len(email.Embedded))
+	}
+}
+
+func TestEmbedImagesDeduplicates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "logo.png"), pngHeader, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngHeader)
+	other := "data:image/png;base64," + base64.StdEncoding.EncodeToString(append(pngHeader, 0))
+
+	email := &domain.Email{
+		HTMLBody: `<img src="` + uri + `"><img src="logo.png"><img src="` + uri + `"><img src="` + other + `">`,
+	}
+	if err := EmbedImages(email, ImageOptions{BaseDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.Embedded) != 2 {
+		t.Fatalf("embedded %d images, want 2", len(email.Embedded))
+	}
+	first, second := email.Embedded[0].ContentID, email.Embedded[1].ContentID
+	want := `<img src="cid:` + first + `"><img src="cid:` + first + `"><img src="cid:` + first + `"><img src="cid:` + second + `">`
+	if email.HTMLBody != want {
+		t.Errorf("HTMLBody =\n%s\nwant\n%s", email.HTMLBody, want)
+	}
+
+	// Running again, e.g. on a retry, adds nothing
+	if err := EmbedImages(email, ImageOptions{BaseDir: dir}); err != nil || len(email.Embedded) != 2 {
+		t.Errorf("second run: %v, embedded %d", err, len(email.Embedded))
+	}
+}
+
+func TestEmbedImagesMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	image := append(append([]byte(nil), pngHeader...), make([]byte, 100)...)
+	if err := os.WriteFile(filepath.Join(dir, "big.png"), image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(image)
+	size := int64(len(image))
+
+	tests := []struct {
+		name    string
+		src     string
+		maxSize int64
+		wantErr error
+	}{
+		{"data URI at the limit", uri, size, nil},
+		{"data URI over the limit", uri, size - 1, ErrImageTooLarge},
+		{"data URI far over the limit", uri, 10, ErrImageTooLarge},
+		{"file at the limit", "big.png", size, nil},
+		{"file over the limit", "big.png", size - 1, ErrImageTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &domain.Email{HTMLBody: `<img src="` + tt.src + `">`}
+			err := EmbedImages(email, ImageOptions{BaseDir: dir, MaxSize: tt.maxSize})
+			if tt.wantErr == nil && err != nil || !errors.Is(err, tt.wantErr) {
+				t.Errorf("EmbedImages = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package htmlmail
+
+import (
+	"sort"
+	"strings"
+)
+
+// InlineCSS moves the rules of the document's <style> blocks into the
+// style attributes of the elements they match, since Gmail and Outlook
+// drop <style> blocks. The cascade is kept: a property set by several
+// rules takes the value of the most specific one, later rules win ties,
+// an existing style attribute beats the stylesheet unless the stylesheet
+// says !important.
+//
+// Rules that can't be expressed inline stay in their <style> block: @media
+// queries and other at-rules, and selectors with pseudo-classes such as
+// :hover. Blocks left empty are removed. <style media="..."> blocks only
+// apply to some media and are left as they are.
+func InlineCSS(src string) string {
+	tokens := tokenize(src)
+
+	var rules []inlineRule
+	var edits []edit
+	order := 0
+
+	for i, tok := range tokens {
+		if tok.typ != startTagToken || tok.name != "style" {
+			continue
+		}
+		if media, ok := tok.attr("media"); ok && !strings.EqualFold(strings.TrimSpace(media), "all") {
+			continue
+		}
+
+		var css token
+		if i+1 < len(tokens) && tokens[i+1].typ == textToken {
+			css = tokens[i+1]
+		}
+		var kept []string
+		for _, rule := range parseStylesheet(css.text) {
+			if rule.raw != "" {
+				kept = append(kept, rule.raw)
+				continue
+			}
+
+			var notInlined []string
+			for _, text := range rule.selectors {
+				sel, ok := parseSelector(text)
+				if !ok {
+					notInlined = append(notInlined, text)
+					continue
+				}
+				rules = append(rules, inlineRule{
+					selector:     sel,
+					specificity:  sel.specificity(),
+					order:        order,
+					declarations: rule.declarations,
+				})
+			}
+			order++
+
+			if len(notInlined) > 0 {
+				kept = append(kept, cssRule{selectors: notInlined, declarations: rule.declarations}.String())
+			}
+		}
+
+		edits = append(edits, styleBlockEdit(src, tokens, i, kept))
+	}
+
+	if len(rules) == 0 {
+		return src
+	}
+
+	for _, el := range buildTree(tokens) {
+		var applied []appliedDeclaration
+		for _, rule := range rules {
+			if !rule.selector.matches(el) {
+				continue
+			}
+			for j, d := range rule.declarations {
+				applied = append(applied, appliedDeclaration{
+					declaration: d,
+					specificity: rule.specificity,
+					order:       rule.order,
+					index:       j,
+				})
+			}
+		}
+		if len(applied) == 0 {
+			continue
+		}
+
+		style, styleAttr := el.tok.attr("style")
+		for j, d := range parseDeclarations(style) {
+			applied = append(applied, appliedDeclaration{declaration: d, inline: true, order: order, index: j})
+		}
+
+		if e, ok := styleEdit(src, el.tok, cascade(applied), styleAttr); ok {
+			edits = append(edits, e)
+		}
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	return applyEdits(src, edits)
+}
+
+type inlineRule struct {
+	selector     selector
+	specificity  specificity
+	order        int
+	declarations []declaration
+}
+
+type appliedDeclaration struct {
+	declaration
+	inline      bool
+	specificity specificity
+	order       int
+	// index is the position of the declaration within its rule
+	index int
+}
+
+// beats reports whether d takes precedence over o for the same property
+func (d appliedDeclaration) beats(o appliedDeclaration) bool {
+	if d.important != o.important {
+		return d.important
+	}
+	if d.inline != o.inline {
+		return d.inline
+	}
+	if d.specificity != o.specificity {
+		return o.specificity.less(d.specificity)
+	}
+	if d.order != o.order {
+		return d.order > o.order
+	}
+	return d.index >= o.index
+}
+
+// cascade picks the winning declaration for each property and formats
+// them as a style attribute, stylesheet properties first in cascade order
+func cascade(applied []appliedDeclaration) string {
+	winners := make(map[string]appliedDeclaration)
+	for _, d := range applied {
+		if current, ok := winners[d.property]; !ok || d.beats(current) {
+			winners[d.property] = d
+		}
+	}
+
+	sorted := make([]appliedDeclaration, 0, len(winners))
+	for _, d := range winners {
+		sorted = append(sorted, d)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.inline != b.inline {
+			return b.inline
+		}
+		if a.specificity != b.specificity {
+			return a.specificity.less(b.specificity)
+		}
+		if a.order != b.order {
+			return a.order < b.order
+		}
+		return a.index < b.index
+	})
+
+	parts := make([]string, len(sorted))
+	for i, d := range sorted {
+		if !d.inline {
+			// Inlined, the declaration already beats the stylesheet.
+			// Keeping !important would stop media queries overriding it.
+			d.important = false
+		}
+		parts[i] = d.declaration.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// styleEdit sets the style attribute of the tag tok to style
+func styleEdit(src string, tok token, style string, hasAttr bool) (edit, bool) {
+	quoted := quoteAttribute(style)
+
+	if hasAttr {
+		for _, a := range tok.attrs {
+			if a.name == "style" && a.start >= 0 {
+				return edit{start: a.start, end: a.end, text: quoted}, true
+			}
+		}
+	}
+
+	// Insert the attribute before the end of the tag
+	end := tok.end - 1
+	if end <= tok.start || src[end] != '>' {
+		return edit{}, false
+	}
+	if src[end-1] == '/' {
+		end--
+	}
+	return edit{start: end, end: end, text: ` style=` + quoted}, true
+}
+
+// quoteAttribute quotes an attribute value, preferring the quote that
+// doesn't occur in it over escaping, since CSS often contains quotes
+func quoteAttribute(value string) string {
+	value = strings.ReplaceAll(value, "&", "&amp;")
+	switch {
+	case !strings.Contains(value, `"`):
+		return `"` + value + `"`
+	case !strings.Contains(value, "'"):
+		return "'" + value + "'"
+	}
+	return `"` + strings.ReplaceAll(value, `"`, "&quot;") + `"`
+}
+
+// styleBlockEdit replaces the content of the <style> block starting at
+// tokens[i] with the rules that were not inlined, or removes the block
+func styleBlockEdit(src string, tokens []token, i int, kept []string) edit {
+	start := tokens[i]
+	contentEnd := start.end
+	if i+1 < len(tokens) && tokens[i+1].typ == textToken {
+		contentEnd = tokens[i+1].end
+	}
+
+	if len(kept) > 0 {
+		return edit{start: start.end, end: contentEnd, text: "\n" + strings.Join(kept, "\n") + "\n"}
+	}
+
+	end := contentEnd
+	for _, tok := range tokens[i+1:] {
+		if tok.typ == endTagToken && tok.name == "style" {
+			end = tok.end
+			break
+		}
+		if tok.typ != textToken {
+			break
+		}
+	}
+	return edit{start: start.start, end: end, text: ""}
+}
+
+// unstyledElements are never rendered, so styling them is pointless
+var unstyledElements = map[string]bool{
+	"head": true, "style": true, "script": true, "title": true,
+	"meta": true, "link": true, "base": true, "template": true,
+}
+
+// impliedEnds lists, for some elements, the open elements their start tag
+// implicitly closes, as in <li>one<li>two
+var impliedEnds = map[string][]string{
+	"li":     {"li"},
+	"p":      {"p"},
+	"tr":     {"tr", "td", "th"},
+	"td":     {"td", "th"},
+	"th":     {"td", "th"},
+	"option": {"option"},
+	"dt":     {"dt", "dd"},
+	"dd":     {"dt", "dd"},
+}
+
+// buildTree returns the elements of the document that can be styled, with
+// their parents
+func buildTree(tokens []token) []*element {
+	var elements []*element
+	var stack []*element
+
+	for _, tok := range tokens {
+		switch tok.typ {
+		case startTagToken:
+			if len(stack) > 0 && containsString(impliedEnds[tok.name], stack[len(stack)-1].tok.name) {
+				stack = stack[:len(stack)-1]
+			}
+
+			el := &element{tok: tok, hidden: unstyledElements[tok.name]}
+			if len(stack) > 0 {
+				el.parent = stack[len(stack)-1]
+				el.hidden = el.hidden || el.parent.hidden
+			}
+			if !el.hidden {
+				elements = append(elements, el)
+			}
+			if !voidElements[tok.name] {
+				stack = append(stack, el)
+			}
+
+		case endTagToken:
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].tok.name == tok.name {
+					stack = stack[:i]
+					break
+				}
+			}
+		}
+	}
+	return elements
+}
@@ -0,0 +1,109 @@
+package htmlmail
+
+import "testing"
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "specificity",
+			src:  `<style>p { color: red } .note { color: blue } #main { color: green }</style><p id="main" class="note">x</p><p class="note">y</p><p>z</p>`,
+			want: `<p id="main" class="note" style="color: green">x</p><p class="note" style="color: blue">y</p><p style="color: red">z</p>`,
+		},
+		{
+			name: "later rule wins a tie",
+			src:  `<style>p { color: red } p { color: blue }</style><p>x</p>`,
+			want: `<p style="color: blue">x</p>`,
+		},
+		{
+			name: "important beats specificity",
+			src:  `<style>p.note { color: blue } p { color: red !important }</style><p class="note">x</p>`,
+			want: `<p class="note" style="color: red">x</p>`,
+		},
+		{
+			name: "existing style wins",
+			src:  `<style>p { color: red; margin: 0 }</style><p style="color: blue">x</p>`,
+			want: `<p style="margin: 0; color: blue">x</p>`,
+		},
+		{
+			name: "important beats existing style",
+			src:  `<style>p { color: red !important }</style><p style="color: blue">x</p>`,
+			want: `<p style="color: red">x</p>`,
+		},
+		{
+			name: "combinators",
+			src:  `<style>div > p { color: red } div p { margin: 0 } span p { padding: 0 }</style><div><p>x</p></div>`,
+			want: `<div><p style="color: red; margin: 0">x</p></div>`,
+		},
+		{
+			name: "attribute selector",
+			src:  `<style>a[href^="https"] { color: red } td.x { padding: 0 }</style><a href="https://e">x</a>`,
+			want: `<a href="https://e" style="color: red">x</a>`,
+		},
+		{
+			name: "media query stays in style",
+			src:  `<style>p { color: red } @media (max-width: 600px) { p { color: blue } }</style><p>x</p>`,
+			want: "<style>\n@media (max-width: 600px) { p { color: blue } }\n</style><p style=\"color: red\">x</p>",
+		},
+		{
+			name: "pseudo-class stays in style",
+			src:  `<style>a:hover { color: red } a { color: blue }</style><a href="#">x</a>`,
+			want: "<style>\na:hover { color: red; }\n</style><a href=\"#\" style=\"color: blue\">x</a>",
+		},
+		{
+			name: "selector list split",
+			src:  `<style>a, a:hover { color: red }</style><a href="#">x</a>`,
+			want: "<style>\na:hover { color: red; }\n</style><a href=\"#\" style=\"color: red\">x</a>",
+		},
+		{
+			name: "nothing inlinable",
+			src:  `<style>p::first-line { color: red } li + li { margin: 0 }</style><ul><li>a<li>b</ul>`,
+			want: `<style>p::first-line { color: red } li + li { margin: 0 }</style><ul><li>a<li>b</ul>`,
+		},
+		{
+			name: "media attribute",
+			src:  `<style media="print">p { color: red }</style><p>x</p>`,
+			want: `<style media="print">p { color: red }</style><p>x</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InlineCSS(tt.src); got != tt.want {
+				t.Errorf("InlineCSS() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectorSpecificity(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     specificity
+		ok       bool
+	}{
+		{"p", specificity{0, 0, 1}, true},
+		{"*", specificity{0, 0, 0}, true},
+		{"div p", specificity{0, 0, 2}, true},
+		{"p.note", specificity{0, 1, 1}, true},
+		{"td[align=center].x", specificity{0, 2, 1}, true},
+		{"#main > .note", specificity{1, 1, 0}, true},
+		{"a:hover", specificity{}, false},
+		{"p::before", specificity{}, false},
+		{"li + li", specificity{}, false},
+		{"h1 ~ p", specificity{}, false},
+		{"div >", specificity{}, false},
+	}
+	for _, tt := range tests {
+		sel, ok := parseSelector(tt.selector)
+		if ok != tt.ok {
+			t.Errorf("parseSelector(%q) ok = %v, want %v", tt.selector, ok, tt.ok)
+			continue
+		}
+		if ok && sel.specificity() != tt.want {
+			t.Errorf("specificity(%q) = %v, want %v", tt.selector, sel.specificity(), tt.want)
+		}
+	}
+}
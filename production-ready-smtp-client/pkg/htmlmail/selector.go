@@ -0,0 +1,281 @@
+package htmlmail
+
+import (
+	"strings"
+)
+
+// element is a node of the document tree the inliner matches selectors
+// against
+type element struct {
+	tok    token
+	parent *element
+	// hidden is set for elements that are never rendered, such as those
+	// in <head>
+	hidden bool
+}
+
+// compound is a sequence of simple selectors without combinators, such
+// as p.note[lang]
+type compound struct {
+	tag     string // "" or "*" matches any element
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	name  string
+	op    string // "" for presence, or one of = ~= |= ^= $= *=
+	value string
+}
+
+// selector is a complex selector. parts[i] is connected to parts[i+1] by
+// combinators[i], which is ' ' for descendant or '>' for child.
+type selector struct {
+	parts       []compound
+	combinators []byte
+}
+
+// specificity orders selectors by the number of ids, then classes and
+// attributes, then type selectors
+type specificity [3]int
+
+func (s specificity) less(o specificity) bool {
+	for i := range s {
+		if s[i] != o[i] {
+			return s[i] < o[i]
+		}
+	}
+	return false
+}
+
+// parseSelector parses the subset of CSS selectors that can be inlined.
+// Pseudo-classes, pseudo-elements and sibling combinators depend on state
+// or context an inline style can't express, so they are reported as not
+// inlinable.
+func parseSelector(s string) (selector, bool) {
+	var sel selector
+	i := 0
+	pending := byte(0)
+
+	for {
+		for i < len(s) && isCSSSpace(s[i]) {
+			if pending == 0 {
+				pending = ' '
+			}
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+
+		switch s[i] {
+		case '>':
+			pending = '>'
+			i++
+			continue
+		case '+', '~', ':', ',':
+			return selector{}, false
+		}
+
+		c, n, ok := parseCompound(s[i:])
+		if !ok {
+			return selector{}, false
+		}
+		if len(sel.parts) > 0 {
+			if pending == 0 {
+				return selector{}, false
+			}
+			sel.combinators = append(sel.combinators, pending)
+		}
+		sel.parts = append(sel.parts, c)
+		pending = 0
+		i += n
+	}
+
+	if len(sel.parts) == 0 || pending == '>' {
+		return selector{}, false
+	}
+	return sel, true
+}
+
+func parseCompound(s string) (compound, int, bool) {
+	var c compound
+	i := 0
+
+	if i < len(s) && s[i] == '*' {
+		c.tag = "*"
+		i++
+	} else if name, n := cssIdent(s[i:]); n > 0 {
+		c.tag = strings.ToLower(name)
+		i += n
+	}
+
+	for i < len(s) {
+		switch s[i] {
+		case '.', '#':
+			name, n := cssIdent(s[i+1:])
+			if n == 0 {
+				return c, 0, false
+			}
+			if s[i] == '.' {
+				c.classes = append(c.classes, name)
+			} else {
+				c.id = name
+			}
+			i += 1 + n
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return c, 0, false
+			}
+			a, ok := parseAttrSelector(s[i+1 : i+end])
+			if !ok {
+				return c, 0, false
+			}
+			c.attrs = append(c.attrs, a)
+			i += end + 1
+		case ':':
+			return c, 0, false
+		default:
+			return c, i, i > 0
+		}
+	}
+	return c, i, i > 0
+}
+
+func parseAttrSelector(s string) (attrSelector, bool) {
+	s = strings.TrimSpace(s)
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		name, n := cssIdent(s)
+		return attrSelector{name: strings.ToLower(name)}, n > 0 && n == len(s)
+	}
+
+	opStart := eq
+	if eq > 0 && strings.IndexByte("~|^$*", s[eq-1]) >= 0 {
+		opStart = eq - 1
+	}
+	name := strings.ToLower(strings.TrimSpace(s[:opStart]))
+	value := strings.TrimSpace(s[eq+1:])
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	if name == "" {
+		return attrSelector{}, false
+	}
+	return attrSelector{name: name, op: s[opStart : eq+1], value: value}, true
+}
+
+// cssIdent reads an identifier at the start of s
+func cssIdent(s string) (string, int) {
+	n := 0
+	for n < len(s) {
+		c := s[n]
+		if c == '-' || c == '_' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (n > 0 && c >= '0' && c <= '9') {
+			n++
+			continue
+		}
+		break
+	}
+	return s[:n], n
+}
+
+func (sel selector) specificity() specificity {
+	var spec specificity
+	for _, c := range sel.parts {
+		if c.id != "" {
+			spec[0]++
+		}
+		spec[1] += len(c.classes) + len(c.attrs)
+		if c.tag != "" && c.tag != "*" {
+			spec[2]++
+		}
+	}
+	return spec
+}
+
+// matches reports whether el matches the selector, matching from the
+// rightmost compound outwards
+func (sel selector) matches(el *element) bool {
+	return sel.matchFrom(len(sel.parts)-1, el)
+}
+
+func (sel selector) matchFrom(i int, el *element) bool {
+	if !sel.parts[i].matches(el) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	if sel.combinators[i-1] == '>' {
+		return el.parent != nil && sel.matchFrom(i-1, el.parent)
+	}
+	for p := el.parent; p != nil; p = p.parent {
+		if sel.matchFrom(i-1, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c compound) matches(el *element) bool {
+	if c.tag != "" && c.tag != "*" && c.tag != el.tok.name {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := el.tok.attr("id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := el.tok.attr("class")
+		classes := strings.Fields(class)
+		for _, want := range c.classes {
+			if !containsString(classes, want) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.matches(el) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attrSelector) matches(el *element) bool {
+	value, ok := el.tok.attr(a.name)
+	if !ok {
+		return false
+	}
+
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return value == a.value
+	case "~=":
+		return containsString(strings.Fields(value), a.value)
+	case "|=":
+		return value == a.value || strings.HasPrefix(value, a.value+"-")
+	case "^=":
+		return a.value != "" && strings.HasPrefix(value, a.value)
+	case "$=":
+		return a.value != "" && strings.HasSuffix(value, a.value)
+	case "*=":
+		return a.value != "" && strings.Contains(value, a.value)
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,660 @@
+package htmlmail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ToText renders an HTML document as plain text for the text/plain
+// alternative of a message. Paragraphs and headings become blocks
+// separated by blank lines, lists get "*" or numbered markers, data tables
+// are laid out in aligned columns and links are written as "text (url)".
+// Hidden elements, styles and scripts are dropped.
+func ToText(src string) string {
+	c := &converter{root: &textWriter{lineStart: true}}
+	for _, tok := range tokenize(strings.ReplaceAll(src, "\r\n", "\n")) {
+		c.token(tok)
+	}
+	return c.finish()
+}
+
+// textWriter accumulates text, collapsing whitespace the way a browser
+// does and inserting line breaks only between pieces of text, so block
+// elements never produce leading or trailing blank lines
+type textWriter struct {
+	b strings.Builder
+	// indent starts every line, for list items and blockquotes
+	indent string
+	// breaks is the number of line breaks due before the next text, and
+	// breakIndent the indent when they were first requested
+	breaks      int
+	breakIndent string
+	// space is set when whitespace separates the last and the next text
+	space     bool
+	lineStart bool
+	// marker is set right after a list marker, where whitespace is dropped
+	marker bool
+	pre    int
+}
+
+func newTextWriter() *textWriter {
+	return &textWriter{lineStart: true}
+}
+
+// block requests that the next text starts n lines down: 1 for a new
+// line, 2 for a new paragraph
+func (w *textWriter) block(n int) {
+	if w.marker {
+		// The first block of a list item starts on the marker's line
+		return
+	}
+	if w.breaks == 0 {
+		w.breakIndent = w.indent
+	}
+	if w.breaks < n {
+		w.breaks = n
+	}
+	w.space = false
+}
+
+// lineBreak adds a line break, as <br> does
+func (w *textWriter) lineBreak() {
+	if w.breaks == 0 {
+		w.breakIndent = w.indent
+	}
+	w.breaks++
+	w.space = false
+}
+
+// prepare writes whatever must come before the next text
+func (w *textWriter) prepare() {
+	if w.breaks > 0 && w.b.Len() > 0 {
+		// A blank line only carries the indent shared by the text before
+		// and after it, so it doesn't extend a blockquote that is just
+		// starting or ending
+		blank := strings.TrimRight(commonPrefix(w.breakIndent, w.indent), " ")
+		n := min(w.breaks, 2)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				w.b.WriteString(blank)
+			}
+			w.b.WriteByte('\n')
+		}
+		w.lineStart = true
+	}
+	w.breaks = 0
+
+	if w.lineStart {
+		w.b.WriteString(w.indent)
+	} else if w.space && !w.marker {
+		w.b.WriteByte(' ')
+	}
+	w.lineStart = false
+	w.space = false
+	w.marker = false
+}
+
+// text writes text from the document, collapsing whitespace outside of
+// <pre>
+func (w *textWriter) text(s string) {
+	if w.pre > 0 {
+		w.lines(s)
+		return
+	}
+
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			w.space = true
+		}
+		return
+	}
+
+	if first, _ := utf8.DecodeRuneInString(s); unicode.IsSpace(first) {
+		w.space = true
+	}
+	for i, word := range words {
+		if i > 0 {
+			w.space = true
+		}
+		w.word(word)
+	}
+	if last, _ := utf8.DecodeLastRuneInString(s); unicode.IsSpace(last) {
+		w.space = true
+	}
+}
+
+// word writes s as is on the current line
+func (w *textWriter) word(s string) {
+	w.prepare()
+	w.b.WriteString(s)
+}
+
+// lines writes s keeping its line breaks, indenting every line
+func (w *textWriter) lines(s string) {
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			w.b.WriteByte('\n')
+			w.lineStart = true
+		}
+		if line != "" {
+			w.prepare()
+			w.b.WriteString(line)
+		}
+	}
+}
+
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
+
+func (w *textWriter) String() string {
+	return w.b.String()
+}
+
+// capture collects the text of a link, heading or table cell, which has
+// to be complete before it can be written
+type capture struct {
+	tag  string
+	w    *textWriter
+	href string
+}
+
+type list struct {
+	w       *textWriter
+	ordered bool
+	n       int
+	item    bool
+	saved   string
+}
+
+type table struct {
+	w      *textWriter
+	rows   [][]string
+	header []bool
+	row    []string
+	inRow  bool
+	isHead bool
+	cell   bool
+	layout bool
+}
+
+// indentation records the indent to restore when a blockquote or <pre>
+// ends
+type indentation struct {
+	tag   string
+	w     *textWriter
+	saved string
+}
+
+type converter struct {
+	root     *textWriter
+	captures []*capture
+	lists    []*list
+	tables   []*table
+	blocks   []indentation
+	// skip holds the open elements whose content is not rendered
+	skip []string
+}
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "title": true,
+}
+
+// blockElements start on a new line; paragraphs additionally get a blank
+// line around them
+var blockElements = map[string]int{
+	"p": 2, "dl": 2, "figure": 2, "form": 2, "fieldset": 2,
+	"div": 1, "section": 1, "article": 1, "header": 1, "footer": 1,
+	"nav": 1, "aside": 1, "main": 1, "address": 1, "center": 1,
+	"dt": 1, "dd": 1, "figcaption": 1, "caption": 1, "body": 1,
+}
+
+// w returns the writer text currently goes to
+func (c *converter) w() *textWriter {
+	if len(c.captures) > 0 {
+		return c.captures[len(c.captures)-1].w
+	}
+	return c.root
+}
+
+func (c *converter) token(tok token) {
+	if len(c.skip) > 0 {
+		top := c.skip[len(c.skip)-1]
+		switch {
+		case tok.typ == startTagToken && tok.name == top && !voidElements[top]:
+			c.skip = append(c.skip, top)
+		case tok.typ == endTagToken && tok.name == top:
+			c.skip = c.skip[:len(c.skip)-1]
+		}
+		return
+	}
+
+	switch tok.typ {
+	case textToken:
+		c.w().text(tok.text)
+	case startTagToken:
+		if skippedElements[tok.name] || isHidden(tok) {
+			if !voidElements[tok.name] {
+				c.skip = append(c.skip, tok.name)
+			}
+			return
+		}
+		c.start(tok)
+	case endTagToken:
+		c.end(tok.name)
+	}
+}
+
+func (c *converter) start(tok token) {
+	w := c.w()
+
+	switch tok.name {
+	case "br":
+		w.lineBreak()
+	case "hr":
+		w.block(2)
+		w.word("---")
+		w.block(2)
+	case "img":
+		if alt, _ := tok.attr("alt"); strings.TrimSpace(alt) != "" {
+			w.text(alt)
+		}
+	case "a":
+		href, _ := tok.attr("href")
+		c.captures = append(c.captures, &capture{tag: "a", w: newTextWriter(), href: href})
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.captures = append(c.captures, &capture{tag: tok.name, w: newTextWriter()})
+	case "ul", "ol":
+		c.startList(tok)
+	case "li":
+		c.startItem()
+	case "blockquote":
+		w.block(2)
+		c.blocks = append(c.blocks, indentation{tag: "blockquote", w: w, saved: w.indent})
+		w.indent += "> "
+	case "pre":
+		w.block(2)
+		w.pre++
+		c.blocks = append(c.blocks, indentation{tag: "pre", w: w, saved: w.indent})
+	case "table":
+		if t := c.table(); t != nil && t.cell {
+			// Tables inside tables are page layout, not data
+			t.layout = true
+		}
+		w.block(2)
+		c.tables = append(c.tables, &table{w: w})
+	case "tr":
+		if t := c.table(); t != nil {
+			c.endRow(t)
+			t.inRow = true
+			t.isHead = true
+		}
+	case "td", "th":
+		t := c.table()
+		if t == nil {
+			w.block(1)
+			return
+		}
+		c.endCell(t)
+		if !t.inRow {
+			t.inRow = true
+			t.isHead = true
+		}
+		if tok.name == "td" {
+			t.isHead = false
+		}
+		t.cell = true
+		c.captures = append(c.captures, &capture{tag: "cell", w: newTextWriter()})
+	default:
+		if n, ok := blockElements[tok.name]; ok {
+			w.block(n)
+		}
+	}
+}
+
+func (c *converter) end(name string) {
+	switch name {
+	case "a", "h1", "h2", "h3", "h4", "h5", "h6":
+		// Only close captures inside the current cell, a stray end tag
+		// must not end the cell
+		for i := len(c.captures) - 1; i >= 0 && c.captures[i].tag != "cell"; i-- {
+			if c.captures[i].tag == name {
+				for len(c.captures) > i {
+					c.closeCapture()
+				}
+				return
+			}
+		}
+	case "ul", "ol":
+		c.endList()
+	case "li":
+		if l := c.list(); l != nil {
+			c.endItem(l)
+		}
+	case "blockquote", "pre":
+		for i := len(c.blocks) - 1; i >= 0; i-- {
+			if c.blocks[i].tag != name {
+				continue
+			}
+			b := c.blocks[i]
+			c.blocks = c.blocks[:i]
+			b.w.indent = b.saved
+			if name == "pre" {
+				b.w.pre--
+			}
+			b.w.block(2)
+			return
+		}
+	case "td", "th":
+		if t := c.table(); t != nil {
+			c.endCell(t)
+		}
+	case "tr":
+		if t := c.table(); t != nil {
+			c.endRow(t)
+		}
+	case "table":
+		c.endTable()
+	default:
+		if n, ok := blockElements[name]; ok {
+			c.w().block(n)
+		}
+	}
+}
+
+// closeCapture writes the innermost capture into the writer below it
+func (c *converter) closeCapture() {
+	top := c.captures[len(c.captures)-1]
+	c.captures = c.captures[:len(c.captures)-1]
+	w := c.w()
+	text := strings.Join(strings.Fields(top.w.String()), " ")
+
+	switch top.tag {
+	case "a":
+		if link := formatLink(text, top.href); link != "" {
+			w.text(link)
+		}
+		if top.w.space {
+			w.space = true
+		}
+	case "cell":
+		if t := c.table(); t != nil {
+			t.row = append(t.row, strings.TrimSpace(top.w.String()))
+			t.cell = false
+		}
+	default:
+		if text == "" {
+			return
+		}
+		w.block(2)
+		w.word(text)
+		switch top.tag {
+		case "h1":
+			w.block(1)
+			w.word(strings.Repeat("=", utf8.RuneCountInString(text)))
+		case "h2":
+			w.block(1)
+			w.word(strings.Repeat("-", utf8.RuneCountInString(text)))
+		}
+		w.block(2)
+	}
+}
+
+// formatLink writes a link as "text (url)", leaving out whatever adds
+// nothing for a reader
+func formatLink(text, href string) string {
+	href = strings.TrimSpace(href)
+	target := href
+	if hasPrefixFold(href, "mailto:") || hasPrefixFold(href, "tel:") {
+		target = href[strings.IndexByte(href, ':')+1:]
+	}
+
+	switch {
+	case href == "", strings.HasPrefix(href, "#"), hasPrefixFold(href, "javascript:"):
+		return text
+	case text == "":
+		return target
+	case strings.EqualFold(text, target), sameURL(text, target):
+		return text
+	}
+	return text + " (" + target + ")"
+}
+
+// sameURL reports whether a link text is its URL written without the
+// scheme or trailing slash
+func sameURL(text, url string) bool {
+	for _, prefix := range []string{"https://", "http://"} {
+		if hasPrefixFold(url, prefix) {
+			url = url[len(prefix):]
+			break
+		}
+	}
+	for _, prefix := range []string{"https://", "http://"} {
+		if hasPrefixFold(text, prefix) {
+			text = text[len(prefix):]
+			break
+		}
+	}
+	return strings.EqualFold(strings.TrimSuffix(text, "/"), strings.TrimSuffix(url, "/"))
+}
+
+func (c *converter) list() *list {
+	if len(c.lists) == 0 {
+		return nil
+	}
+	return c.lists[len(c.lists)-1]
+}
+
+func (c *converter) startList(tok token) {
+	w := c.w()
+	if len(c.lists) == 0 {
+		w.block(2)
+	} else {
+		w.block(1)
+	}
+
+	l := &list{w: w, ordered: tok.name == "ol", n: 1}
+	if start, ok := tok.attr("start"); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(start)); err == nil {
+			l.n = n
+		}
+	}
+	c.lists = append(c.lists, l)
+}
+
+func (c *converter) startItem() {
+	l := c.list()
+	if l == nil {
+		// A stray <li> is rendered as an item of an implicit list
+		c.lists = append(c.lists, &list{w: c.w(), n: 1})
+		l = c.list()
+	}
+	c.endItem(l)
+
+	marker := "* "
+	if l.ordered {
+		marker = fmt.Sprintf("%d. ", l.n)
+		l.n++
+	}
+
+	l.w.block(1)
+	l.w.word(marker)
+	l.w.marker = true
+	l.saved = l.w.indent
+	l.w.indent += strings.Repeat(" ", len(marker))
+	l.item = true
+}
+
+func (c *converter) endItem(l *list) {
+	if !l.item {
+		return
+	}
+	l.w.indent = l.saved
+	l.w.block(1)
+	l.item = false
+}
+
+func (c *converter) endList() {
+	l := c.list()
+	if l == nil {
+		return
+	}
+	c.endItem(l)
+	c.lists = c.lists[:len(c.lists)-1]
+	if len(c.lists) == 0 {
+		l.w.block(2)
+	} else {
+		l.w.block(1)
+	}
+}
+
+func (c *converter) table() *table {
+	if len(c.tables) == 0 {
+		return nil
+	}
+	return c.tables[len(c.tables)-1]
+}
+
+func (c *converter) endCell(t *table) {
+	if !t.cell {
+		return
+	}
+	for len(c.captures) > 0 {
+		last := c.captures[len(c.captures)-1].tag == "cell"
+		c.closeCapture()
+		if last {
+			return
+		}
+	}
+}
+
+func (c *converter) endRow(t *table) {
+	c.endCell(t)
+	if !t.inRow {
+		return
+	}
+	if len(t.row) > 0 {
+		t.rows = append(t.rows, t.row)
+		t.header = append(t.header, t.isHead)
+	}
+	t.row = nil
+	t.inRow = false
+}
+
+func (c *converter) endTable() {
+	t := c.table()
+	if t == nil {
+		return
+	}
+	c.endRow(t)
+	c.tables = c.tables[:len(c.tables)-1]
+	t.render()
+}
+
+// render writes the table as aligned columns, or cell by cell if it is
+// used for page layout
+func (t *table) render() {
+	w := t.w
+	columns := 0
+	for _, row := range t.rows {
+		columns = max(columns, len(row))
+		for _, cell := range row {
+			if strings.Contains(cell, "\n") {
+				t.layout = true
+			}
+		}
+	}
+
+	if t.layout || columns < 2 {
+		for _, row := range t.rows {
+			for _, cell := range row {
+				if cell != "" {
+					w.block(2)
+					w.lines(cell)
+				}
+			}
+		}
+		w.block(2)
+		return
+	}
+
+	widths := make([]int, columns)
+	for _, row := range t.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	w.block(2)
+	for i, row := range t.rows {
+		cells := make([]string, columns)
+		for j := range cells {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			cells[j] = cell + strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+		}
+		w.block(1)
+		w.word(strings.TrimRight(strings.Join(cells, " | "), " "))
+
+		if t.header[i] && i == 0 && len(t.rows) > 1 {
+			rule := make([]string, columns)
+			for j, width := range widths {
+				rule[j] = strings.Repeat("-", width)
+			}
+			w.block(1)
+			w.word(strings.Join(rule, "-+-"))
+		}
+	}
+	w.block(2)
+}
+
+// isHidden reports whether an element is hidden from view, such as the
+// preheader text many templates put at the top of the body
+func isHidden(tok token) bool {
+	if _, ok := tok.attr("hidden"); ok {
+		return true
+	}
+	style, _ := tok.attr("style")
+	style = strings.ToLower(strings.Join(strings.Fields(style), ""))
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// finish closes whatever the document left open and returns the text
+func (c *converter) finish() string {
+	for len(c.captures) > 0 {
+		if c.captures[len(c.captures)-1].tag == "cell" {
+			c.endTable()
+			continue
+		}
+		c.closeCapture()
+	}
+	for len(c.tables) > 0 {
+		c.endTable()
+	}
+
+	lines := strings.Split(c.root.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\u00a0")
+	}
+	text := strings.Trim(strings.Join(lines, "\n"), "\n")
+	if text == "" {
+		return ""
+	}
+	return text + "\n"
+}
@@ -0,0 +1,144 @@
+package htmlmail
+
+import "testing"
+
+func TestToText(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "paragraphs",
+			src:  "<p>First paragraph.</p><p>Second\nparagraph.</p>",
+			want: "First paragraph.\n\nSecond paragraph.\n",
+		},
+		{
+			name: "line breaks",
+			src:  "<p>Line one<br>Line two<br/><br>Line four</p>",
+			want: "Line one\nLine two\n\nLine four\n",
+		},
+		{
+			name: "divs start new lines",
+			src:  "<div>One</div><div>Two</div>",
+			want: "One\nTwo\n",
+		},
+		{
+			name: "whitespace collapsing",
+			src:  "<p>  Lots   of\n\t space  <b>here</b>,and <i> there </i> .</p>",
+			want: "Lots of space here,and there .\n",
+		},
+		{
+			name: "entities",
+			src:  "<p>Fish &amp; chips&nbsp;&lt;3</p>",
+			want: "Fish & chips <3\n",
+		},
+		{
+			name: "unordered list",
+			src:  "<p>Items:</p><ul><li>One</li><li>Two</li></ul><p>After</p>",
+			want: "Items:\n\n* One\n* Two\n\nAfter\n",
+		},
+		{
+			name: "ordered list",
+			src:  `<ol start="3"><li>Three</li><li>Four</li></ol>`,
+			want: "3. Three\n4. Four\n",
+		},
+		{
+			name: "nested list",
+			src:  "<ul><li>One<ul><li>Nested</li></ul></li><li>Two</li></ul>",
+			want: "* One\n  * Nested\n* Two\n",
+		},
+		{
+			name: "list item paragraphs",
+			src:  "<ol><li><p>First</p><p>More</p></li><li>Second</li></ol>",
+			want: "1. First\n\n   More\n\n2. Second\n",
+		},
+		{
+			name: "headings",
+			src:  "<h1>Title</h1><h2>Section</h2><h3>Sub</h3><p>Text</p>",
+			want: "Title\n=====\n\nSection\n-------\n\nSub\n\nText\n",
+		},
+		{
+			name: "data table",
+			src:  "<table><tr><th>Item</th><th>Qty</th></tr><tr><td>Apples</td><td>3</td></tr><tr><td>Kiwi</td><td>12</td></tr></table>",
+			want: "Item   | Qty\n-------+----\nApples | 3\nKiwi   | 12\n",
+		},
+		{
+			name: "layout table",
+			src:  "<table><tr><td><table><tr><td>Logo</td></tr></table></td><td><p>Hello</p></td></tr></table>",
+			want: "Logo\n\nHello\n",
+		},
+		{
+			name: "link",
+			src:  `<p>Read <a href="https://example.com/post">the post</a> today</p>`,
+			want: "Read the post (https://example.com/post) today\n",
+		},
+		{
+			name: "link text is the URL",
+			src:  `<a href="https://example.com/">example.com</a>`,
+			want: "example.com\n",
+		},
+		{
+			name: "mailto link",
+			src:  `<a href="mailto:help@example.com">Support</a>`,
+			want: "Support (help@example.com)\n",
+		},
+		{
+			name: "anchor link",
+			src:  `<a href="#top">Back to top</a>`,
+			want: "Back to top\n",
+		},
+		{
+			name: "image link without text",
+			src:  `<a href="https://example.com"><img src="logo.png"></a>`,
+			want: "https://example.com\n",
+		},
+		{
+			name: "image alt",
+			src:  `<p><img src="logo.png" alt="Example Inc."> News</p>`,
+			want: "Example Inc. News\n",
+		},
+		{
+			name: "script and style skipped",
+			src:  "<html><head><title>T</title><style>p { color: red }</style></head><body><script>alert('x')</script><p>Visible</p><style>.a{}</style></body></html>",
+			want: "Visible\n",
+		},
+		{
+			name: "hidden preheader skipped",
+			src:  `<div style="display: none">Preview text</div><p hidden>Hidden</p><p>Body</p>`,
+			want: "Body\n",
+		},
+		{
+			name: "blockquote",
+			src:  "<p>Wrote:</p><blockquote><p>Quoted</p><p>Twice</p></blockquote><p>Reply</p>",
+			want: "Wrote:\n\n> Quoted\n>\n> Twice\n\nReply\n",
+		},
+		{
+			name: "pre keeps whitespace",
+			src:  "<p>Code:</p><pre>if x {\n    y()\n}</pre>",
+			want: "Code:\n\nif x {\n    y()\n}\n",
+		},
+		{
+			name: "horizontal rule",
+			src:  "<p>Above</p><hr><p>Below</p>",
+			want: "Above\n\n---\n\nBelow\n",
+		},
+		{
+			name: "CRLF",
+			src:  "<p>One\r\ntwo</p>",
+			want: "One two\n",
+		},
+		{
+			name: "empty",
+			src:  "<p> </p><div></div>",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToText(tt.src); got != tt.want {
+				t.Errorf("ToText =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
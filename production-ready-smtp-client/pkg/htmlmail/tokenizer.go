@@ -0,0 +1,273 @@
+package htmlmail
+
+import (
+	"html"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	textToken tokenType = iota
+	startTagToken
+	endTagToken
+	commentToken
+	// doctypeToken also covers other markup declarations and processing
+	// instructions, which are passed through untouched
+	doctypeToken
+)
+
+// attribute is one attribute of a start tag. start and end delimit the
+// raw value in the source, including any quotes, or are both -1 for an
+// attribute without a value.
+type attribute struct {
+	name  string
+	value string
+	start int
+	end   int
+}
+
+// token is a lexical unit of an HTML document. start and end delimit it in
+// the source, so callers can copy the document while rewriting parts of it.
+type token struct {
+	typ   tokenType
+	name  string // lower-cased tag name
+	attrs []attribute
+	text  string // decoded text for text tokens
+	start int
+	end   int
+}
+
+// attr returns the value of the named attribute
+func (t *token) attr(name string) (string, bool) {
+	for _, a := range t.attrs {
+		if a.name == name {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+// rawTextElements hold text that is not parsed for tags or entities
+var rawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+// tokenize splits an HTML document into tokens. It is a forgiving lexer
+// in the spirit of the HTML5 tokenizer rather than a full parser: it
+// doesn't build a tree or fix up mismatched tags, which is all the mail
+// processing in this package needs.
+func tokenize(src string) []token {
+	var tokens []token
+	pos := 0
+	textStart := 0
+
+	flushText := func(end int) {
+		if end > textStart {
+			tokens = append(tokens, token{
+				typ:   textToken,
+				text:  html.UnescapeString(src[textStart:end]),
+				start: textStart,
+				end:   end,
+			})
+		}
+	}
+
+	for pos < len(src) {
+		lt := strings.IndexByte(src[pos:], '<')
+		if lt < 0 {
+			break
+		}
+		pos += lt
+
+		tok, ok := lexTag(src, pos)
+		if !ok {
+			// A '<' that doesn't open a tag is text
+			pos++
+			continue
+		}
+
+		flushText(pos)
+		tokens = append(tokens, tok)
+		pos = tok.end
+		textStart = pos
+
+		if tok.typ == startTagToken && rawTextElements[tok.name] {
+			// Everything up to the matching end tag is text
+			end := indexFold(src[pos:], "</"+tok.name)
+			if end < 0 {
+				end = len(src) - pos
+			}
+			if end > 0 {
+				raw := src[pos : pos+end]
+				text := raw
+				if tok.name == "textarea" || tok.name == "title" {
+					text = html.UnescapeString(raw)
+				}
+				tokens = append(tokens, token{typ: textToken, text: text, start: pos, end: pos + end})
+			}
+			pos += end
+			textStart = pos
+		}
+	}
+
+	flushText(len(src))
+	return tokens
+}
+
+// lexTag reads the markup starting at src[pos] == '<'
+func lexTag(src string, pos int) (token, bool) {
+	rest := src[pos:]
+
+	switch {
+	case strings.HasPrefix(rest, "<!--"):
+		end := strings.Index(rest[4:], "-->")
+		if end < 0 {
+			return token{typ: commentToken, start: pos, end: len(src)}, true
+		}
+		return token{typ: commentToken, text: rest[4 : 4+end], start: pos, end: pos + 4 + end + 3}, true
+
+	case strings.HasPrefix(rest, "<!"), strings.HasPrefix(rest, "<?"):
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			end = len(rest) - 1
+		}
+		return token{typ: doctypeToken, start: pos, end: pos + end + 1}, true
+
+	case strings.HasPrefix(rest, "</"):
+		name, n := lexName(rest[2:])
+		if name == "" {
+			return token{}, false
+		}
+		end := strings.IndexByte(rest[2+n:], '>')
+		if end < 0 {
+			end = len(rest) - 2 - n - 1
+		}
+		return token{typ: endTagToken, name: name, start: pos, end: pos + 2 + n + end + 1}, true
+	}
+
+	name, n := lexName(rest[1:])
+	if name == "" {
+		return token{}, false
+	}
+
+	tok := token{typ: startTagToken, name: name, start: pos}
+	i := pos + 1 + n
+	for i < len(src) {
+		// Skip whitespace and stray slashes between attributes
+		for i < len(src) && (isSpace(src[i]) || src[i] == '/') {
+			i++
+		}
+		if i >= len(src) {
+			break
+		}
+		if src[i] == '>' {
+			i++
+			tok.end = i
+			return tok, true
+		}
+
+		nameStart := i
+		for i < len(src) && !isSpace(src[i]) && src[i] != '=' && src[i] != '>' && !(src[i] == '/' && i > nameStart) {
+			i++
+		}
+		attr := attribute{name: strings.ToLower(src[nameStart:i]), start: -1, end: -1}
+
+		j := i
+		for j < len(src) && isSpace(src[j]) {
+			j++
+		}
+		if j < len(src) && src[j] == '=' {
+			j++
+			for j < len(src) && isSpace(src[j]) {
+				j++
+			}
+			attr.start = j
+			if j < len(src) && (src[j] == '"' || src[j] == '\'') {
+				quote := src[j]
+				end := strings.IndexByte(src[j+1:], quote)
+				if end < 0 {
+					end = len(src) - j - 1
+					attr.value = src[j+1:]
+					j = len(src)
+				} else {
+					attr.value = src[j+1 : j+1+end]
+					j += end + 2
+				}
+			} else {
+				for j < len(src) && !isSpace(src[j]) && src[j] != '>' {
+					j++
+				}
+				attr.value = src[attr.start:j]
+			}
+			attr.end = j
+			attr.value = html.UnescapeString(attr.value)
+			i = j
+		}
+
+		tok.attrs = append(tok.attrs, attr)
+	}
+
+	tok.end = len(src)
+	return tok, true
+}
+
+// lexName reads a tag name, which must start with a letter
+func lexName(s string) (string, int) {
+	if len(s) == 0 || !isLetter(s[0]) {
+		return "", 0
+	}
+	n := 1
+	for n < len(s) && !isSpace(s[n]) && s[n] != '/' && s[n] != '>' {
+		n++
+	}
+	return strings.ToLower(s[:n]), n
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// indexFold is strings.Index with case folding
+func indexFold(s, substr string) int {
+	n := len(substr)
+	for i := 0; i+n <= len(s); i++ {
+		if strings.EqualFold(s[i:i+n], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// edit replaces src[start:end] with text
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+// applyEdits returns src with the edits applied. Edits must be in order
+// and must not overlap.
+func applyEdits(src string, edits []edit) string {
+	if len(edits) == 0 {
+		return src
+	}
+
+	var b strings.Builder
+	b.Grow(len(src))
+	pos := 0
+	for _, e := range edits {
+		b.WriteString(src[pos:e.start])
+		b.WriteString(e.text)
+		pos = e.end
+	}
+	b.WriteString(src[pos:])
+	return b.String()
+}
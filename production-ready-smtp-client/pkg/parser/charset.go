@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// windows1252 maps the bytes 0x80-0x9F of Windows-1252, where it differs
+// from ISO-8859-1. Unassigned bytes map to U+FFFD.
+var windows1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+// singleByteCharsets are the legacy charsets that are decoded, mapped to
+// whether they use the Windows-1252 characters. ISO-8859-15 only differs
+// from ISO-8859-1 in a few letters and is close enough.
+var singleByteCharsets = map[string]bool{
+	"iso-8859-1":   false,
+	"iso_8859-1":   false,
+	"latin1":       false,
+	"l1":           false,
+	"iso-8859-15":  true,
+	"windows-1252": true,
+	"cp1252":       true,
+}
+
+// toUTF8 converts text in charset to UTF-8. Besides UTF-8 and US-ASCII
+// only the Latin-1 family is supported, which covers most legacy mail;
+// text in other charsets is kept with invalid sequences replaced.
+func toUTF8(content []byte, charset string) string {
+	windows, ok := singleByteCharsets[strings.ToLower(strings.TrimSpace(charset))]
+	if !ok {
+		return strings.ToValidUTF8(string(content), "�")
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
+	for _, c := range content {
+		if windows && c >= 0x80 && c <= 0x9F {
+			b.WriteRune(windows1252[c-0x80])
+			continue
+		}
+		b.WriteRune(rune(c))
+	}
+	return b.String()
+}
+
+// charsetReader lets mime.WordDecoder decode encoded words in the legacy
+// charsets toUTF8 supports. The decoder handles UTF-8 and US-ASCII itself.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if _, ok := singleByteCharsets[strings.ToLower(charset)]; !ok {
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(toUTF8(content, charset)), nil
+}
@@ -0,0 +1,48 @@
+package parser
+
+import "strings"
+
+// unflow joins the soft-broken lines of format=flowed text (RFC 3676),
+// removing space stuffing. With delSp the space before each soft break
+// was added by the sender and is removed too.
+func unflow(text string, delSp bool) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	var paragraph strings.Builder
+	open := false
+	openDepth := 0
+
+	for _, line := range lines {
+		depth := len(line) - len(strings.TrimLeft(line, ">"))
+		content := strings.TrimPrefix(line[depth:], " ")
+
+		// A line at a new quote depth ends the paragraph before it
+		if open && depth != openDepth {
+			out = append(out, paragraph.String())
+			paragraph.Reset()
+			open = false
+		}
+		if !open {
+			paragraph.WriteString(strings.Repeat(">", depth))
+			if depth > 0 && content != "" {
+				paragraph.WriteString(" ")
+			}
+			openDepth = depth
+		}
+
+		soft := strings.HasSuffix(content, " ") && content != "-- "
+		if soft && delSp {
+			content = content[:len(content)-1]
+		}
+		paragraph.WriteString(content)
+		open = soft
+		if !soft {
+			out = append(out, paragraph.String())
+			paragraph.Reset()
+		}
+	}
+	if open {
+		out = append(out, paragraph.String())
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,358 @@
+// Package parser reads RFC 5322 / MIME messages, such as .eml files, into
+// domain.Email. It is the reverse of package composer: a parsed message
+// composes back into the same structure.
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-smtp/production-ready-smtp-client/domain"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// maxDepth bounds the nesting of multiparts, which is never deep in real
+// mail but unbounded in hostile input
+const maxDepth = 32
+
+var ErrTooDeep = errors.New("multipart nesting too deep")
+
+// skippedHeaders are not kept in Email.Headers: the composer writes them
+// from other fields, and trace headers describe a past delivery
+var skippedHeaders = map[string]bool{
+	"Mime-Version":               true,
+	"X-Priority":                 true,
+	"Importance":                 true,
+	"Received":                   true,
+	"Return-Path":                true,
+	"Delivered-To":               true,
+	"Dkim-Signature":             true,
+	"Arc-Seal":                   true,
+	"Arc-Message-Signature":      true,
+	"Arc-Authentication-Results": true,
+	"Authentication-Results":     true,
+}
+
+// Parse reads a message into an Email. Text bodies are converted to UTF-8
+// with LF line breaks. The first text/plain and text/html parts become the
+// bodies, parts with a Content-ID inside multipart/related become
+// Embedded and everything else becomes an Attachment. The text/calendar
+// alternative of an invitation is dropped; its invite.ics attachment is
+// kept.
+//
+// A message that is a single text part ends with a line break that only
+// terminates the message, as the composer adds one to bodies without it,
+// so one trailing line break is dropped from such bodies unless they are
+// base64 encoded.
+//
+// Headers that occur more than once keep their first value.
+func Parse(r io.Reader) (*domain.Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	email := &domain.Email{
+		Headers:  make(map[string]string),
+		Priority: domain.PriorityNormal,
+		Status:   domain.StatusPending,
+	}
+	if err := parseHeader(email, msg.Header); err != nil {
+		return nil, err
+	}
+
+	p := &parser{email: email}
+	if err := p.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0, false); err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+// ParseBytes parses a message held in memory
+func ParseBytes(raw []byte) (*domain.Email, error) {
+	return Parse(bytes.NewReader(raw))
+}
+
+func parseHeader(email *domain.Email, header mail.Header) error {
+	var err error
+	if from := header.Get("From"); from != "" {
+		if email.From, err = domain.ParseAddress(from); err != nil {
+			return fmt.Errorf("invalid From header: %w", err)
+		}
+	}
+	if sender := header.Get("Sender"); sender != "" {
+		if email.Sender, err = domain.ParseAddress(sender); err != nil {
+			return fmt.Errorf("invalid Sender header: %w", err)
+		}
+	}
+	for _, list := range []struct {
+		name string
+		dst  *[]domain.Address
+	}{
+		{"To", &email.To},
+		{"Cc", &email.Cc},
+		{"Bcc", &email.Bcc},
+		{"Reply-To", &email.ReplyTo},
+	} {
+		if *list.dst, err = parseAddressList(header[list.name]); err != nil {
+			return fmt.Errorf("invalid %s header: %w", list.name, err)
+		}
+	}
+
+	email.Subject = decodeHeader(header.Get("Subject"))
+	if date, err := header.Date(); err == nil {
+		email.CreatedAt = date
+	} else {
+		email.CreatedAt = time.Now()
+	}
+
+	email.MessageID = firstMessageID(header.Get("Message-Id"))
+	email.InReplyTo = firstMessageID(header.Get("In-Reply-To"))
+	email.References = messageIDs(header.Get("References"))
+	email.Priority = parsePriority(header.Get("X-Priority"), header.Get("Importance"))
+
+	for name, values := range header {
+		if skippedHeaders[name] || domain.IsReservedHeader(name) || len(values) == 0 {
+			continue
+		}
+		email.Headers[name] = decodeHeader(values[0])
+	}
+	return nil
+}
+
+// parseAddressList parses every occurrence of an address list header.
+// Empty lists, such as "undisclosed-recipients:;", give no addresses.
+func parseAddressList(values []string) ([]domain.Address, error) {
+	var addrs []domain.Address
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" || strings.HasSuffix(strings.TrimSpace(value), ":;") {
+			continue
+		}
+		list, err := domain.ParseAddressList(value)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, list...)
+	}
+	return addrs, nil
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// decodeHeader decodes RFC 2047 encoded words, keeping the value as it is
+// if they can't be decoded
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// messageIDs returns the message IDs in value without angle brackets
+func messageIDs(value string) []string {
+	var ids []string
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			return ids
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			return ids
+		}
+		if id := strings.TrimSpace(value[start+1 : start+end]); id != "" {
+			ids = append(ids, id)
+		}
+		value = value[start+end+1:]
+	}
+}
+
+func firstMessageID(value string) string {
+	if ids := messageIDs(value); len(ids) > 0 {
+		return ids[0]
+	}
+	return strings.TrimSpace(value)
+}
+
+// parsePriority maps X-Priority, falling back to Importance, the reverse
+// of composer.PriorityHeaders
+func parsePriority(xPriority, importance string) domain.Priority {
+	if fields := strings.Fields(xPriority); len(fields) > 0 {
+		switch fields[0] {
+		case "1", "2":
+			return domain.PriorityHigh
+		case "4", "5":
+			return domain.PriorityLow
+		}
+		return domain.PriorityNormal
+	}
+	switch strings.ToLower(strings.TrimSpace(importance)) {
+	case "high":
+		return domain.PriorityHigh
+	case "low":
+		return domain.PriorityLow
+	}
+	return domain.PriorityNormal
+}
+
+type parser struct {
+	email *domain.Email
+}
+
+// walk parses one part. related is set for the children of a
+// multipart/related, where parts with a Content-ID are embedded.
+func (p *parser) walk(header textproto.MIMEHeader, body io.Reader, depth int, related bool) error {
+	if depth > maxDepth {
+		return ErrTooDeep
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 5.2: missing or invalid types are plain text
+		mediaType, params = "text/plain", map[string]string{"charset": "us-ascii"}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return p.walkMultipart(mediaType, params, body, depth)
+	}
+
+	content, err := decodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s part: %w", mediaType, err)
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	// Besides RFC 2231, which ParseMediaType decodes, filenames are often
+	// RFC 2047 encoded
+	filename := decodeHeader(dispositionParams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+	contentID := strings.Trim(strings.TrimSpace(header.Get("Content-Id")), "<>")
+
+	if disposition != "attachment" && filename == "" && contentID == "" {
+		switch mediaType {
+		case "text/plain":
+			if p.email.TextBody == "" {
+				p.email.TextBody = bodyText(content, params, header, depth)
+				return nil
+			}
+		case "text/html":
+			if p.email.HTMLBody == "" {
+				p.email.HTMLBody = bodyText(content, params, header, depth)
+				return nil
+			}
+		case "text/calendar":
+			if params["method"] != "" {
+				return nil
+			}
+		}
+	}
+
+	att := domain.Attachment{
+		Filename:    filename,
+		ContentType: attachmentType(mediaType, params),
+		Data:        content,
+	}
+	if contentID != "" && disposition != "attachment" && (related || disposition == "inline") {
+		att.ContentID = contentID
+		p.email.Embedded = append(p.email.Embedded, att)
+		return nil
+	}
+	p.email.Attachments = append(p.email.Attachments, att)
+	return nil
+}
+
+func (p *parser) walkMultipart(mediaType string, params map[string]string, body io.Reader, depth int) error {
+	boundary := params["boundary"]
+	if boundary == "" {
+		return fmt.Errorf("%s without boundary", mediaType)
+	}
+
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", mediaType, err)
+		}
+		if err := p.walk(part.Header, part, depth+1, mediaType == "multipart/related"); err != nil {
+			return err
+		}
+	}
+}
+
+// attachmentType returns the content type of an attachment without the
+// name parameter, which the composer writes from the filename
+func attachmentType(mediaType string, params map[string]string) string {
+	kept := make(map[string]string, len(params))
+	for key, value := range params {
+		if key != "name" {
+			kept[key] = value
+		}
+	}
+	return mime.FormatMediaType(mediaType, kept)
+}
+
+func decodeTransfer(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// Line breaks are ignored by the decoder but not other
+		// whitespace, which some mailers leave at line ends
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		cleaned := bytes.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, raw)
+		// Padding is dropped so that unpadded base64, which is common
+		// enough, decodes too
+		cleaned = bytes.TrimRight(cleaned, "=")
+		decoded := make([]byte, base64.RawStdEncoding.DecodedLen(len(cleaned)))
+		n, err := base64.RawStdEncoding.Decode(decoded, cleaned)
+		return decoded[:n], err
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(body))
+	default:
+		return io.ReadAll(body)
+	}
+}
+
+// decodeText converts a text part to UTF-8 with LF line breaks, unwrapping
+// format=flowed text
+func decodeText(content []byte, params map[string]string) string {
+	text := toUTF8(content, params["charset"])
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if strings.EqualFold(params["format"], "flowed") {
+		text = unflow(text, strings.EqualFold(params["delsp"], "yes"))
+	}
+	return text
+}
+
+// bodyText decodes a text body. In a single-part message the last line
+// break terminates the message rather than the text, except after base64
+// where it is outside the encoded content; inside a multipart it belongs
+// to the boundary and is already gone.
+func bodyText(content []byte, params map[string]string, header textproto.MIMEHeader, depth int) string {
+	text := decodeText(content, params)
+	base64 := strings.EqualFold(strings.TrimSpace(header.Get("Content-Transfer-Encoding")), "base64")
+	if depth == 0 && !base64 {
+		text = strings.TrimSuffix(text, "\n")
+	}
+	return text
+}
@@ -2,6 +2,7 @@ package retry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"time"
@@ -76,8 +77,39 @@ func calculateBackoff(attempt int, config Config) time.Duration {
 	return time.Duration(delay)
 }
 
+// classifiedError carries an explicit retry decision made by the code that
+// produced the error, overriding the message-based heuristics below.
+type classifiedError struct {
+	err       error
+	retryable bool
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+func (e *classifiedError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, retryable: false}
+}
+
+// Temporary marks err as worth retrying
+func Temporary(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, retryable: true}
+}
+
 // IsRetryable determines if an error is temporary and worth retrying
 func IsRetryable(err error) bool {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.retryable
+	}
+	
 	// Check error message for temporary indicators
 	errStr := err.Error()
 	
@@ -5,11 +5,14 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
 	"net/smtp"
 	"os"
 	"strings"
 	"time"
 
+	"go-smtp/production-ready-smtp-client/pkg/htmlmail"
+
 	"github.com/joho/godotenv"
 )
 
@@ -44,7 +47,7 @@ func (e *HTMLEmail) Build() []byte {
 	// Embedded images
 	for cid, imgData := range e.Images {
 		buf.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-		buf.WriteString("Content-Type: image/png\r\n")
+		buf.WriteString(fmt.Sprintf("Content-Type: %s\r\n", http.DetectContentType(imgData)))
 		buf.WriteString("Content-Transfer-Encoding: base64\r\n")
 		buf.WriteString(fmt.Sprintf("Content-ID: <%s>\r\n", cid))
 		buf.WriteString("Content-Disposition: inline\r\n")
@@ -101,7 +104,9 @@ func main() {
 		From:    from,
 		To:      []string{"s.rufus.cse2023075@student.oauife.edu.ng"},
 		Subject: "HTML Email with Embedded Logo",
-		HTML: `<!DOCTYPE html>
+		// Gmail and Outlook drop <style> blocks, so the rules are moved
+		// into style attributes
+		HTML: htmlmail.InlineCSS(`<!DOCTYPE html>
 <html>
 <head>
     <style>
@@ -123,7 +128,7 @@ func main() {
         <p><strong>Best regards,</strong><br>The Team</p>
     </div>
 </body>
-</html>`,
+</html>`),
 		Images: map[string][]byte{
 			"logo": logoData,
 		},